@@ -0,0 +1,751 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: api/segmenter/v1/segmenter.proto
+
+package segmenterv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Segmenter_CreateUsers_FullMethodName        = "/segmenter.v1.Segmenter/CreateUsers"
+	Segmenter_GetUser_FullMethodName            = "/segmenter.v1.Segmenter/GetUser"
+	Segmenter_GetUsersSegments_FullMethodName   = "/segmenter.v1.Segmenter/GetUsersSegments"
+	Segmenter_UpdateUser_FullMethodName         = "/segmenter.v1.Segmenter/UpdateUser"
+	Segmenter_DeleteUser_FullMethodName         = "/segmenter.v1.Segmenter/DeleteUser"
+	Segmenter_RestoreUser_FullMethodName        = "/segmenter.v1.Segmenter/RestoreUser"
+	Segmenter_CreateSegments_FullMethodName     = "/segmenter.v1.Segmenter/CreateSegments"
+	Segmenter_GetSegment_FullMethodName         = "/segmenter.v1.Segmenter/GetSegment"
+	Segmenter_ListSegments_FullMethodName       = "/segmenter.v1.Segmenter/ListSegments"
+	Segmenter_UpdateSegment_FullMethodName      = "/segmenter.v1.Segmenter/UpdateSegment"
+	Segmenter_RenameSegment_FullMethodName      = "/segmenter.v1.Segmenter/RenameSegment"
+	Segmenter_MergeSegments_FullMethodName      = "/segmenter.v1.Segmenter/MergeSegments"
+	Segmenter_DeleteSegment_FullMethodName      = "/segmenter.v1.Segmenter/DeleteSegment"
+	Segmenter_RestoreSegment_FullMethodName     = "/segmenter.v1.Segmenter/RestoreSegment"
+	Segmenter_ListSegmentUsers_FullMethodName   = "/segmenter.v1.Segmenter/ListSegmentUsers"
+	Segmenter_AddSegmentUsers_FullMethodName    = "/segmenter.v1.Segmenter/AddSegmentUsers"
+	Segmenter_DeleteSegmentUsers_FullMethodName = "/segmenter.v1.Segmenter/DeleteSegmentUsers"
+)
+
+// SegmenterClient is the client API for Segmenter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Segmenter is the gRPC API of the service. It shares the service with the REST API:
+// a missing user or segment is NOT_FOUND, a duplicate is ALREADY_EXISTS
+// and an invalid request is INVALID_ARGUMENT.
+type SegmenterClient interface {
+	CreateUsers(ctx context.Context, in *CreateUsersRequest, opts ...grpc.CallOption) (*CreateUsersResponse, error)
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+	// GetUsersSegments returns segments of many users at once, users that do not exist are listed in missing.
+	GetUsersSegments(ctx context.Context, in *GetUsersSegmentsRequest, opts ...grpc.CallOption) (*GetUsersSegmentsResponse, error)
+	// UpdateUser adds the user to segments and removes it from segments atomically.
+	// Segments that do not exist and repeated adds are skipped.
+	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*User, error)
+	// DeleteUser archives the user; it can be restored until the archive retention passes.
+	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	RestoreUser(ctx context.Context, in *RestoreUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	CreateSegments(ctx context.Context, in *CreateSegmentsRequest, opts ...grpc.CallOption) (*CreateSegmentsResponse, error)
+	GetSegment(ctx context.Context, in *GetSegmentRequest, opts ...grpc.CallOption) (*Segment, error)
+	// ListSegments returns segments ordered by name.
+	ListSegments(ctx context.Context, in *ListSegmentsRequest, opts ...grpc.CallOption) (*ListSegmentsResponse, error)
+	// UpdateSegment changes segment metadata; unset fields are left unchanged.
+	UpdateSegment(ctx context.Context, in *UpdateSegmentRequest, opts ...grpc.CallOption) (*Segment, error)
+	// RenameSegment renames the segment keeping its members and history.
+	RenameSegment(ctx context.Context, in *RenameSegmentRequest, opts ...grpc.CallOption) (*Segment, error)
+	// MergeSegments moves members of the sources into the segment and deletes the sources.
+	MergeSegments(ctx context.Context, in *MergeSegmentsRequest, opts ...grpc.CallOption) (*MergeSegmentsResponse, error)
+	// DeleteSegment archives the segment; it can be restored until the archive retention passes.
+	DeleteSegment(ctx context.Context, in *DeleteSegmentRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	RestoreSegment(ctx context.Context, in *RestoreSegmentRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// ListSegmentUsers returns IDs of the segment members ordered by ID.
+	ListSegmentUsers(ctx context.Context, in *ListSegmentUsersRequest, opts ...grpc.CallOption) (*ListSegmentUsersResponse, error)
+	// AddSegmentUsers adds many users to the segment.
+	AddSegmentUsers(ctx context.Context, in *AddSegmentUsersRequest, opts ...grpc.CallOption) (*SegmentUsersResponse, error)
+	// DeleteSegmentUsers removes many users from the segment.
+	DeleteSegmentUsers(ctx context.Context, in *DeleteSegmentUsersRequest, opts ...grpc.CallOption) (*SegmentUsersResponse, error)
+}
+
+type segmenterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSegmenterClient(cc grpc.ClientConnInterface) SegmenterClient {
+	return &segmenterClient{cc}
+}
+
+func (c *segmenterClient) CreateUsers(ctx context.Context, in *CreateUsersRequest, opts ...grpc.CallOption) (*CreateUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateUsersResponse)
+	err := c.cc.Invoke(ctx, Segmenter_CreateUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Segmenter_GetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) GetUsersSegments(ctx context.Context, in *GetUsersSegmentsRequest, opts ...grpc.CallOption) (*GetUsersSegmentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUsersSegmentsResponse)
+	err := c.cc.Invoke(ctx, Segmenter_GetUsersSegments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, Segmenter_UpdateUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Segmenter_DeleteUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) RestoreUser(ctx context.Context, in *RestoreUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Segmenter_RestoreUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) CreateSegments(ctx context.Context, in *CreateSegmentsRequest, opts ...grpc.CallOption) (*CreateSegmentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateSegmentsResponse)
+	err := c.cc.Invoke(ctx, Segmenter_CreateSegments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) GetSegment(ctx context.Context, in *GetSegmentRequest, opts ...grpc.CallOption) (*Segment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Segment)
+	err := c.cc.Invoke(ctx, Segmenter_GetSegment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) ListSegments(ctx context.Context, in *ListSegmentsRequest, opts ...grpc.CallOption) (*ListSegmentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSegmentsResponse)
+	err := c.cc.Invoke(ctx, Segmenter_ListSegments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) UpdateSegment(ctx context.Context, in *UpdateSegmentRequest, opts ...grpc.CallOption) (*Segment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Segment)
+	err := c.cc.Invoke(ctx, Segmenter_UpdateSegment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) RenameSegment(ctx context.Context, in *RenameSegmentRequest, opts ...grpc.CallOption) (*Segment, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Segment)
+	err := c.cc.Invoke(ctx, Segmenter_RenameSegment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) MergeSegments(ctx context.Context, in *MergeSegmentsRequest, opts ...grpc.CallOption) (*MergeSegmentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MergeSegmentsResponse)
+	err := c.cc.Invoke(ctx, Segmenter_MergeSegments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) DeleteSegment(ctx context.Context, in *DeleteSegmentRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Segmenter_DeleteSegment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) RestoreSegment(ctx context.Context, in *RestoreSegmentRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Segmenter_RestoreSegment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) ListSegmentUsers(ctx context.Context, in *ListSegmentUsersRequest, opts ...grpc.CallOption) (*ListSegmentUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSegmentUsersResponse)
+	err := c.cc.Invoke(ctx, Segmenter_ListSegmentUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) AddSegmentUsers(ctx context.Context, in *AddSegmentUsersRequest, opts ...grpc.CallOption) (*SegmentUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SegmentUsersResponse)
+	err := c.cc.Invoke(ctx, Segmenter_AddSegmentUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *segmenterClient) DeleteSegmentUsers(ctx context.Context, in *DeleteSegmentUsersRequest, opts ...grpc.CallOption) (*SegmentUsersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SegmentUsersResponse)
+	err := c.cc.Invoke(ctx, Segmenter_DeleteSegmentUsers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SegmenterServer is the server API for Segmenter service.
+// All implementations must embed UnimplementedSegmenterServer
+// for forward compatibility
+//
+// Segmenter is the gRPC API of the service. It shares the service with the REST API:
+// a missing user or segment is NOT_FOUND, a duplicate is ALREADY_EXISTS
+// and an invalid request is INVALID_ARGUMENT.
+type SegmenterServer interface {
+	CreateUsers(context.Context, *CreateUsersRequest) (*CreateUsersResponse, error)
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	// GetUsersSegments returns segments of many users at once, users that do not exist are listed in missing.
+	GetUsersSegments(context.Context, *GetUsersSegmentsRequest) (*GetUsersSegmentsResponse, error)
+	// UpdateUser adds the user to segments and removes it from segments atomically.
+	// Segments that do not exist and repeated adds are skipped.
+	UpdateUser(context.Context, *UpdateUserRequest) (*User, error)
+	// DeleteUser archives the user; it can be restored until the archive retention passes.
+	DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error)
+	RestoreUser(context.Context, *RestoreUserRequest) (*emptypb.Empty, error)
+	CreateSegments(context.Context, *CreateSegmentsRequest) (*CreateSegmentsResponse, error)
+	GetSegment(context.Context, *GetSegmentRequest) (*Segment, error)
+	// ListSegments returns segments ordered by name.
+	ListSegments(context.Context, *ListSegmentsRequest) (*ListSegmentsResponse, error)
+	// UpdateSegment changes segment metadata; unset fields are left unchanged.
+	UpdateSegment(context.Context, *UpdateSegmentRequest) (*Segment, error)
+	// RenameSegment renames the segment keeping its members and history.
+	RenameSegment(context.Context, *RenameSegmentRequest) (*Segment, error)
+	// MergeSegments moves members of the sources into the segment and deletes the sources.
+	MergeSegments(context.Context, *MergeSegmentsRequest) (*MergeSegmentsResponse, error)
+	// DeleteSegment archives the segment; it can be restored until the archive retention passes.
+	DeleteSegment(context.Context, *DeleteSegmentRequest) (*emptypb.Empty, error)
+	RestoreSegment(context.Context, *RestoreSegmentRequest) (*emptypb.Empty, error)
+	// ListSegmentUsers returns IDs of the segment members ordered by ID.
+	ListSegmentUsers(context.Context, *ListSegmentUsersRequest) (*ListSegmentUsersResponse, error)
+	// AddSegmentUsers adds many users to the segment.
+	AddSegmentUsers(context.Context, *AddSegmentUsersRequest) (*SegmentUsersResponse, error)
+	// DeleteSegmentUsers removes many users from the segment.
+	DeleteSegmentUsers(context.Context, *DeleteSegmentUsersRequest) (*SegmentUsersResponse, error)
+	mustEmbedUnimplementedSegmenterServer()
+}
+
+// UnimplementedSegmenterServer must be embedded to have forward compatible implementations.
+type UnimplementedSegmenterServer struct {
+}
+
+func (UnimplementedSegmenterServer) CreateUsers(context.Context, *CreateUsersRequest) (*CreateUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUsers not implemented")
+}
+func (UnimplementedSegmenterServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedSegmenterServer) GetUsersSegments(context.Context, *GetUsersSegmentsRequest) (*GetUsersSegmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsersSegments not implemented")
+}
+func (UnimplementedSegmenterServer) UpdateUser(context.Context, *UpdateUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateUser not implemented")
+}
+func (UnimplementedSegmenterServer) DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (UnimplementedSegmenterServer) RestoreUser(context.Context, *RestoreUserRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreUser not implemented")
+}
+func (UnimplementedSegmenterServer) CreateSegments(context.Context, *CreateSegmentsRequest) (*CreateSegmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSegments not implemented")
+}
+func (UnimplementedSegmenterServer) GetSegment(context.Context, *GetSegmentRequest) (*Segment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSegment not implemented")
+}
+func (UnimplementedSegmenterServer) ListSegments(context.Context, *ListSegmentsRequest) (*ListSegmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSegments not implemented")
+}
+func (UnimplementedSegmenterServer) UpdateSegment(context.Context, *UpdateSegmentRequest) (*Segment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateSegment not implemented")
+}
+func (UnimplementedSegmenterServer) RenameSegment(context.Context, *RenameSegmentRequest) (*Segment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenameSegment not implemented")
+}
+func (UnimplementedSegmenterServer) MergeSegments(context.Context, *MergeSegmentsRequest) (*MergeSegmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeSegments not implemented")
+}
+func (UnimplementedSegmenterServer) DeleteSegment(context.Context, *DeleteSegmentRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSegment not implemented")
+}
+func (UnimplementedSegmenterServer) RestoreSegment(context.Context, *RestoreSegmentRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreSegment not implemented")
+}
+func (UnimplementedSegmenterServer) ListSegmentUsers(context.Context, *ListSegmentUsersRequest) (*ListSegmentUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSegmentUsers not implemented")
+}
+func (UnimplementedSegmenterServer) AddSegmentUsers(context.Context, *AddSegmentUsersRequest) (*SegmentUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddSegmentUsers not implemented")
+}
+func (UnimplementedSegmenterServer) DeleteSegmentUsers(context.Context, *DeleteSegmentUsersRequest) (*SegmentUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSegmentUsers not implemented")
+}
+func (UnimplementedSegmenterServer) mustEmbedUnimplementedSegmenterServer() {}
+
+// UnsafeSegmenterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SegmenterServer will
+// result in compilation errors.
+type UnsafeSegmenterServer interface {
+	mustEmbedUnimplementedSegmenterServer()
+}
+
+func RegisterSegmenterServer(s grpc.ServiceRegistrar, srv SegmenterServer) {
+	s.RegisterService(&Segmenter_ServiceDesc, srv)
+}
+
+func _Segmenter_CreateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).CreateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_CreateUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).CreateUsers(ctx, req.(*CreateUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_GetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_GetUsersSegments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsersSegmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).GetUsersSegments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_GetUsersSegments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).GetUsersSegments(ctx, req.(*GetUsersSegmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).UpdateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_UpdateUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).UpdateUser(ctx, req.(*UpdateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_DeleteUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_RestoreUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).RestoreUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_RestoreUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).RestoreUser(ctx, req.(*RestoreUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_CreateSegments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSegmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).CreateSegments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_CreateSegments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).CreateSegments(ctx, req.(*CreateSegmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_GetSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).GetSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_GetSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).GetSegment(ctx, req.(*GetSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_ListSegments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSegmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).ListSegments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_ListSegments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).ListSegments(ctx, req.(*ListSegmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_UpdateSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).UpdateSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_UpdateSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).UpdateSegment(ctx, req.(*UpdateSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_RenameSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).RenameSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_RenameSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).RenameSegment(ctx, req.(*RenameSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_MergeSegments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeSegmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).MergeSegments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_MergeSegments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).MergeSegments(ctx, req.(*MergeSegmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_DeleteSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).DeleteSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_DeleteSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).DeleteSegment(ctx, req.(*DeleteSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_RestoreSegment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreSegmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).RestoreSegment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_RestoreSegment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).RestoreSegment(ctx, req.(*RestoreSegmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_ListSegmentUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSegmentUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).ListSegmentUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_ListSegmentUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).ListSegmentUsers(ctx, req.(*ListSegmentUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_AddSegmentUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddSegmentUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).AddSegmentUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_AddSegmentUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).AddSegmentUsers(ctx, req.(*AddSegmentUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Segmenter_DeleteSegmentUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSegmentUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SegmenterServer).DeleteSegmentUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Segmenter_DeleteSegmentUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SegmenterServer).DeleteSegmentUsers(ctx, req.(*DeleteSegmentUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Segmenter_ServiceDesc is the grpc.ServiceDesc for Segmenter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Segmenter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "segmenter.v1.Segmenter",
+	HandlerType: (*SegmenterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateUsers",
+			Handler:    _Segmenter_CreateUsers_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _Segmenter_GetUser_Handler,
+		},
+		{
+			MethodName: "GetUsersSegments",
+			Handler:    _Segmenter_GetUsersSegments_Handler,
+		},
+		{
+			MethodName: "UpdateUser",
+			Handler:    _Segmenter_UpdateUser_Handler,
+		},
+		{
+			MethodName: "DeleteUser",
+			Handler:    _Segmenter_DeleteUser_Handler,
+		},
+		{
+			MethodName: "RestoreUser",
+			Handler:    _Segmenter_RestoreUser_Handler,
+		},
+		{
+			MethodName: "CreateSegments",
+			Handler:    _Segmenter_CreateSegments_Handler,
+		},
+		{
+			MethodName: "GetSegment",
+			Handler:    _Segmenter_GetSegment_Handler,
+		},
+		{
+			MethodName: "ListSegments",
+			Handler:    _Segmenter_ListSegments_Handler,
+		},
+		{
+			MethodName: "UpdateSegment",
+			Handler:    _Segmenter_UpdateSegment_Handler,
+		},
+		{
+			MethodName: "RenameSegment",
+			Handler:    _Segmenter_RenameSegment_Handler,
+		},
+		{
+			MethodName: "MergeSegments",
+			Handler:    _Segmenter_MergeSegments_Handler,
+		},
+		{
+			MethodName: "DeleteSegment",
+			Handler:    _Segmenter_DeleteSegment_Handler,
+		},
+		{
+			MethodName: "RestoreSegment",
+			Handler:    _Segmenter_RestoreSegment_Handler,
+		},
+		{
+			MethodName: "ListSegmentUsers",
+			Handler:    _Segmenter_ListSegmentUsers_Handler,
+		},
+		{
+			MethodName: "AddSegmentUsers",
+			Handler:    _Segmenter_AddSegmentUsers_Handler,
+		},
+		{
+			MethodName: "DeleteSegmentUsers",
+			Handler:    _Segmenter_DeleteSegmentUsers_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/segmenter/v1/segmenter.proto",
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+)
+
+var errImportUsage = errors.New("usage: segmenter import [-errors report.csv] file.csv")
+
+// importPollInterval is how often the import subcommand reports progress.
+const importPollInterval = time.Second
+
+// runImport handles the import subcommand: it imports the CSV file and waits for the import to finish.
+// Rejected rows are written to the -errors file, or to stderr if it is not set.
+func runImport(serv *service.Service, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	errorsPath := flags.String("errors", "", "file for rejected rows")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errImportUsage
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	job, err := serv.StartImport(file)
+	if err != nil {
+		return err
+	}
+	for job.Status == models.ImportRunning {
+		time.Sleep(importPollInterval)
+		if job, err = serv.GetImport(job.ID); err != nil {
+			return err
+		}
+		fmt.Printf("processed %d of %d rows\n", job.ProcessedRows, job.TotalRows)
+	}
+	fmt.Printf("imported %d rows, rejected %d rows, created %d users\n",
+		job.ImportedRows, job.RejectedRows, job.CreatedUsers)
+
+	if job.RejectedRows > 0 {
+		if err = writeImportErrors(serv, job.ID, *errorsPath); err != nil {
+			return err
+		}
+	}
+	if job.Status == models.ImportFailed {
+		return fmt.Errorf("import failed: %s", job.Error)
+	}
+	return nil
+}
+
+func writeImportErrors(serv *service.Service, id int, path string) error {
+	rowErrors, err := serv.GetImportErrors(id)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return service.WriteImportErrors(os.Stderr, rowErrors)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = service.WriteImportErrors(file, rowErrors); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -1,37 +1,337 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/sink"
+	"github.com/iTcatt/segmenter/internal/storage/cache"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+	"github.com/iTcatt/segmenter/internal/storage/metrics"
+	"github.com/iTcatt/segmenter/internal/storage/tracing"
+	"github.com/iTcatt/segmenter/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
 
+	grpcapi "github.com/iTcatt/segmenter/internal/api/grpc"
 	"github.com/iTcatt/segmenter/internal/api/rest"
+	"github.com/iTcatt/segmenter/internal/auth"
 	"github.com/iTcatt/segmenter/internal/config"
+	"github.com/iTcatt/segmenter/internal/health"
+	"github.com/iTcatt/segmenter/internal/lifecycle"
+	"github.com/iTcatt/segmenter/internal/logger"
 	"github.com/iTcatt/segmenter/internal/storage/postgres"
 )
 
+// commands are subcommands that run against the configured storage instead of starting the server.
+var commands = map[string]func(serv *service.Service, args []string) error{
+	"import":  runImport,
+	"export":  runExport,
+	"restore": runRestore,
+}
+
 // @title			segmenter
 // @version		1.0
 // @description	REST API server for saving users and their segments
 // @host			localhost:3000
 // @BasePath		/api
+//
+// @securityDefinitions.apikey	ApiKeyAuth
+// @in							header
+// @name						X-API-Key
+// @securityDefinitions.apikey	BearerAuth
+// @in							header
+// @name						Authorization
+// @description				JWT as "Bearer <token>"
 func main() {
+	os.Exit(run())
+}
+
+// run starts the app and returns its exit code once it has stopped. Deferred cleanups run before the exit,
+// so errors are returned as codes instead of exiting on the spot.
+func run() int {
 	cfg := config.MustLoad()
-	db, err := postgres.NewStorage(cfg.Storage)
+	// Logs go to stderr, stdout is left to the output of subcommands such as export.
+	log, err := logger.New(os.Stderr, cfg.Log)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
-	if err = db.StartUp(); err != nil {
-		log.Fatal(err)
+	slog.SetDefault(log)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db, err := postgres.NewStorage(cfg.Storage, log)
+		if err != nil {
+			return fail(log, "connect to database", err)
+		}
+		defer db.Close()
+		if err = runMigrate(db, os.Args[2:]); err != nil {
+			return fail(log, "migrate", err)
+		}
+		return 0
 	}
 
-	serv := service.NewService(db)
-	handler := rest.NewHandler(serv)
-	server := http.Server{
-		Addr:    cfg.Server.Endpoint,
-		Handler: rest.NewRouter(handler),
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return fail(log, "set up tracing", err)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.FlushTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error("flush spans", "error", err)
+		}
+	}()
+
+	checker := health.NewChecker(cfg.Health.CheckTimeout)
+	db, closeStorage, err := newStorage(cfg.Storage, log, checker)
+	if err != nil {
+		return fail(log, "create storage", err)
+	}
+	defer closeStorage()
+	measured := metrics.NewStorage(tracing.NewStorage(db), log)
+	db = measured
+	var cached *cache.Storage
+	if cfg.Cache.Enabled {
+		cached = cache.NewStorage(db, cache.NewLRU(cfg.Cache.Size, cfg.Cache.TTL), log)
+		prometheus.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "segmenter_cache_hits_total",
+				Help: "Number of user lookups served from the cache.",
+			}, func() float64 { return float64(cached.Stats().Hits) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "segmenter_cache_misses_total",
+				Help: "Number of user lookups that went to the storage.",
+			}, func() float64 { return float64(cached.Stats().Misses) }),
+		)
+		db = cached
+	}
+
+	serv := service.NewService(db, log)
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err = command(serv, os.Args[2:]); err != nil {
+				return fail(log, os.Args[1], err)
+			}
+			return 0
+		}
+	}
+
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		if authenticator, err = auth.New(cfg.Auth); err != nil {
+			return fail(log, "set up authentication", err)
+		}
+	} else {
+		log.Warn("authentication is disabled, anyone can call the API")
+	}
+
+	sinks, err := newSinks(serv, cfg)
+	if err != nil {
+		return fail(log, "create event sinks", err)
+	}
+	defer closeSinks(sinks, log)
+
+	// Listeners are bound before the workers start, so that a busy port fails the start with nothing to stop.
+	grpcListener, err := net.Listen("tcp", cfg.Server.GRPCEndpoint)
+	if err != nil {
+		return fail(log, "listen gRPC", err)
+	}
+	httpListener, err := net.Listen("tcp", cfg.Server.Endpoint)
+	if err != nil {
+		_ = grpcListener.Close()
+		return fail(log, "listen HTTP", err)
+	}
+
+	// Workers are stopped in reverse order: the event stream, webhook delivery and cache invalidation outlive
+	// the relay that feeds them, and the relay outlives the workers that write events to the outbox.
+	workers := lifecycle.NewWorkers(checker, log)
+	workers.Go("event_stream", func(ctx context.Context) { serv.RunEventStream(ctx, cfg.Stream.BufferSize) })
+	workers.Go("webhook_delivery", serv.RunWebhookDelivery)
+	if cached != nil {
+		workers.Go("cache_invalidation", cached.RunInvalidation)
+	}
+	workers.Go("outbox_relay", func(ctx context.Context) {
+		serv.RunOutboxRelay(ctx, service.RelayOptions{
+			PollInterval:   cfg.Outbox.PollInterval,
+			BatchSize:      cfg.Outbox.BatchSize,
+			Workers:        cfg.Outbox.Workers,
+			InitialBackoff: cfg.Outbox.InitialBackoff,
+			MaxBackoff:     cfg.Outbox.MaxBackoff,
+		}, sinks...)
+	})
+	workers.Go("members_gauge", func(ctx context.Context) {
+		measured.RunMembersGauge(ctx, cfg.Metrics.MembersInterval)
+	})
+	workers.Go("archive_purger", func(ctx context.Context) {
+		serv.RunArchivePurger(ctx, cfg.Archive.PurgeInterval, cfg.Archive.Retention)
+	})
+	workers.Go("expiration_reaper", func(ctx context.Context) { serv.RunExpirationReaper(ctx, cfg.Reaper.Interval) })
+	// Started last to stop first: imports and replays still running write to the storage and the outbox.
+	workers.Go("background_tasks", serv.RunTasks)
+
+	// A server that fails stops the app the same way a signal does.
+	serveErrs := make(chan error, 2)
+	grpcServer := grpcapi.NewServer(grpcapi.NewHandler(serv), log, authenticator)
+	go func() {
+		if err := grpcServer.Serve(grpcListener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			serveErrs <- fmt.Errorf("serve gRPC: %w", err)
+		}
+	}()
 
-	log.Fatal(server.ListenAndServe())
+	handler := rest.NewHandler(serv, log, rest.HandlerOptions{MaxBulkUsers: cfg.Server.MaxBulkUsers})
+	server := &http.Server{
+		Addr: cfg.Server.Endpoint,
+		Handler: rest.NewRouter(handler, rest.RouterOptions{
+			LogBodies: cfg.Log.Bodies,
+			Health:    checker,
+			Auth:      authenticator,
+		}),
+	}
+	// Event streams never end on their own, they would hold up the shutdown until its timeout.
+	server.RegisterOnShutdown(serv.CloseSubscriptions)
+	go func() {
+		if err := server.Serve(httpListener); !errors.Is(err, http.ErrServerClosed) {
+			serveErrs <- fmt.Errorf("serve HTTP: %w", err)
+		}
+	}()
+
+	exitCode := 0
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-signals.Done():
+	case err = <-serveErrs:
+		log.Error("server failed", "error", err)
+		exitCode = 1
+	}
+	// A second signal kills the app right away.
+	stop()
+
+	// The app reports itself not ready and keeps serving for the drain delay,
+	// so that the orchestrator moves traffic to other instances first.
+	checker.Drain()
+	log.Info("draining", "delay", cfg.Health.DrainDelay)
+	time.Sleep(cfg.Health.DrainDelay)
+
+	log.Info("shutting down", "timeout", cfg.Shutdown.Timeout)
+	shutdownServers(server, grpcServer, cfg.Shutdown.Timeout, log)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.WorkersTimeout)
+	defer cancel()
+	if err = workers.Stop(ctx); err != nil {
+		log.Error("stop workers", "error", err)
+	}
+	log.Info("stopped")
+	return exitCode
+}
+
+// shutdownServers stops accepting requests and waits up to timeout for in-flight ones to complete,
+// then closes the connections left.
+func shutdownServers(server *http.Server, grpcServer *grpc.Server, timeout time.Duration, log *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warn("HTTP requests did not complete", "error", err)
+		_ = server.Close()
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		log.Warn("gRPC requests did not complete", "error", ctx.Err())
+		grpcServer.Stop()
+	}
+}
+
+// fail logs the error that stops the app and returns its exit code.
+func fail(log *slog.Logger, msg string, err error) int {
+	log.Error(msg, "error", err)
+	return 1
+}
+
+// newStorage creates the storage selected by cfg.Driver and returns the function that closes it.
+// Postgres schema is migrated to the latest version, and the database and its migrations are added
+// to the readiness checks.
+func newStorage(
+	cfg config.DatabaseConfig, log *slog.Logger, checker *health.Checker,
+) (service.SegmentStorage, func(), error) {
+	switch cfg.Driver {
+	case config.DriverMemory:
+		log.Info("using in-memory storage")
+		return memory.NewStorage(), func() {}, nil
+	case config.DriverPostgres:
+		db, err := postgres.NewStorage(cfg, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = db.StartUp(); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		prometheus.MustRegister(postgres.NewPoolCollector(db))
+		checker.Add("database", db.Ping)
+		checker.Add("migrations", db.CheckMigrations)
+		return db, db.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage driver '%s'", cfg.Driver)
+	}
+}
+
+// newSinks creates the event sinks listed in the outbox config. Event streams are always fed.
+func newSinks(serv *service.Service, cfg config.Config) ([]service.EventSink, error) {
+	// Webhook delivery is configured even without the sink, replayed dead letters are delivered anyway.
+	webhooks := serv.WebhookSink(service.WebhookOptions{
+		MaxAttempts:     cfg.Webhooks.MaxAttempts,
+		InitialBackoff:  cfg.Webhooks.InitialBackoff,
+		MaxBackoff:      cfg.Webhooks.MaxBackoff,
+		Timeout:         cfg.Webhooks.Timeout,
+		RefreshInterval: cfg.Webhooks.RefreshInterval,
+		PollInterval:    cfg.Webhooks.PollInterval,
+		BatchSize:       cfg.Webhooks.BatchSize,
+	})
+	sinks := []service.EventSink{serv.StreamSink()}
+	for _, name := range cfg.Outbox.Sinks {
+		switch name {
+		case config.SinkWebhook:
+			sinks = append(sinks, webhooks)
+		case config.SinkFile:
+			file, err := sink.NewFile(cfg.Outbox.File)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, file)
+		case config.SinkKafka:
+			sinks = append(sinks, sink.NewKafka(cfg.Outbox.Kafka.Brokers, cfg.Outbox.Kafka.Topic))
+		default:
+			return nil, fmt.Errorf("unknown event sink '%s'", name)
+		}
+	}
+	return sinks, nil
+}
+
+// closeSinks closes the sinks that hold a file or a connection.
+func closeSinks(sinks []service.EventSink, log *slog.Logger) {
+	for _, sink := range sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Error("close event sink", "sink", sink.Name(), "error", err)
+			}
+		}
+	}
 }
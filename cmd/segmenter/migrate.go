@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/storage/postgres"
+)
+
+var errMigrateUsage = errors.New("usage: segmenter migrate up | down [steps] | status")
+
+// runMigrate handles the migrate subcommand.
+func runMigrate(db *postgres.Storage, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return db.MigrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return errMigrateUsage
+			}
+			steps = n
+		}
+		return db.MigrateDown(ctx, steps)
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		return printMigrationStatus(statuses)
+	default:
+		return errMigrateUsage
+	}
+}
+
+func printMigrationStatus(statuses []postgres.MigrationStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+)
+
+var (
+	errExportUsage  = errors.New("usage: segmenter export [file.jsonl]")
+	errRestoreUsage = errors.New("usage: segmenter restore [-mode replace|merge] file.jsonl")
+)
+
+// runExport handles the export subcommand: it writes the snapshot to the file, or to stdout if it is not set.
+func runExport(serv *service.Service, args []string) error {
+	if len(args) > 1 {
+		return errExportUsage
+	}
+	if len(args) == 0 {
+		_, err := serv.ExportSnapshot(context.Background(), os.Stdout)
+		return err
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	stats, err := serv.ExportSnapshot(context.Background(), file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d segments, %d users, %d memberships\n", stats.Segments, stats.Users, stats.Memberships)
+	return nil
+}
+
+// runRestore handles the restore subcommand: it loads the snapshot file, or stdin if the file is "-".
+func runRestore(serv *service.Service, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	mode := flags.String("mode", string(models.RestoreMerge), "replace archives existing data, merge keeps it")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errRestoreUsage
+	}
+	if *mode != string(models.RestoreReplace) && *mode != string(models.RestoreMerge) {
+		return errRestoreUsage
+	}
+
+	var input io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	stats, err := serv.RestoreSnapshot(context.Background(), input, models.RestoreMode(*mode))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d segments, %d users, %d memberships, skipped %d memberships\n",
+		stats.Segments, stats.Users, stats.Memberships, stats.SkippedMemberships)
+	return nil
+}
@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	segmenterv1 "github.com/iTcatt/segmenter/api/segmenter/v1"
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+func toUser(user models.User) *segmenterv1.User {
+	return &segmenterv1.User{Id: int64(user.ID), Segments: user.Segments}
+}
+
+func toSegment(segment models.Segment) *segmenterv1.Segment {
+	return &segmenterv1.Segment{
+		Name:        segment.Name,
+		AutoPercent: int32(segment.AutoPercent),
+		Description: segment.Description,
+		Owner:       segment.Owner,
+		Tags:        segment.Tags,
+		CreatedAt:   timestamppb.New(segment.CreatedAt),
+		UpdatedAt:   timestamppb.New(segment.UpdatedAt),
+	}
+}
+
+func fromUserIDs(ids []int64) []int {
+	users := make([]int, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, int(id))
+	}
+	return users
+}
+
+func toUserIDs(users []int) []int64 {
+	ids := make([]int64, 0, len(users))
+	for _, id := range users {
+		ids = append(ids, int64(id))
+	}
+	return ids
+}
+
+func sortedUserIDs(segments map[int][]string) []int {
+	ids := make([]int, 0, len(segments))
+	for id := range segments {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func toUserResults(reply map[int]string) map[int64]string {
+	results := make(map[int64]string, len(reply))
+	for id, result := range reply {
+		results[int64(id)] = result
+	}
+	return results
+}
+
+func fromMembership(membership *segmenterv1.Membership, now time.Time) (models.SegmentMembership, error) {
+	expiresAt, err := fromExpiry(membership.GetSegment(), membership.GetExpiresAt(), membership.GetTtl(), now)
+	if err != nil {
+		return models.SegmentMembership{}, err
+	}
+	return models.SegmentMembership{Segment: membership.GetSegment(), ExpiresAt: expiresAt}, nil
+}
+
+// fromExpiry returns the expiry of a membership limited by expires_at or ttl, nil if it is permanent.
+func fromExpiry(
+	segment string, expiresAt *timestamppb.Timestamp, ttl *durationpb.Duration, now time.Time,
+) (*time.Time, error) {
+	switch {
+	case expiresAt != nil:
+		if err := expiresAt.CheckValid(); err != nil {
+			return nil, fmt.Errorf("%w: segment '%s': invalid expires_at", ErrValidation, segment)
+		}
+		expiry := expiresAt.AsTime()
+		if !expiry.After(now) {
+			return nil, fmt.Errorf("%w: segment '%s': expires_at is in the past", ErrValidation, segment)
+		}
+		return &expiry, nil
+	case ttl != nil:
+		if err := ttl.CheckValid(); err != nil || ttl.AsDuration() <= 0 {
+			return nil, fmt.Errorf("%w: segment '%s': invalid ttl", ErrValidation, segment)
+		}
+		expiry := now.Add(ttl.AsDuration())
+		return &expiry, nil
+	default:
+		return nil, nil
+	}
+}
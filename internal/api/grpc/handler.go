@@ -0,0 +1,328 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	segmenterv1 "github.com/iTcatt/segmenter/api/segmenter/v1"
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+const (
+	maxBulkUsers   = 100_000
+	maxLookupUsers = 1000
+)
+
+var ErrValidation = errors.New("validation error")
+
+// SegmentService is the part of the service exposed over gRPC.
+type SegmentService interface {
+	CreateUsers(context.Context, []int) (map[int]string, error)
+	GetUser(context.Context, int) (models.User, error)
+	GetUsersSegments(context.Context, []int) (models.UsersSegments, error)
+	UpdateUser(context.Context, models.UpdateUserParams) error
+	DeleteUser(context.Context, int) error
+	RestoreUser(context.Context, int) error
+
+	CreateSegments(context.Context, []models.Segment) (map[string]string, error)
+	GetSegment(context.Context, string) (models.Segment, error)
+	ListSegments(context.Context, models.ListSegmentsParams) (models.SegmentsPage, error)
+	UpdateSegment(context.Context, models.UpdateSegmentParams) (models.Segment, error)
+	RenameSegment(context.Context, string, string) (models.Segment, error)
+	MergeSegments(context.Context, string, []string) (int64, error)
+	DeleteSegment(context.Context, string) error
+	RestoreSegment(context.Context, string) error
+
+	GetSegmentUsers(context.Context, models.SegmentUsersParams) (models.SegmentUsersPage, error)
+	AddSegmentUsers(context.Context, string, []int, *time.Time) (map[int]string, error)
+	DeleteSegmentUsers(context.Context, string, []int) (map[int]string, error)
+}
+
+// Handler implements the Segmenter gRPC service on top of SegmentService.
+type Handler struct {
+	segmenterv1.UnimplementedSegmenterServer
+
+	service SegmentService
+}
+
+func NewHandler(s SegmentService) *Handler {
+	return &Handler{
+		service: s,
+	}
+}
+
+func (h *Handler) CreateUsers(
+	ctx context.Context, req *segmenterv1.CreateUsersRequest,
+) (*segmenterv1.CreateUsersResponse, error) {
+	reply, err := h.service.CreateUsers(ctx, fromUserIDs(req.GetUsers()))
+	if err != nil {
+		return nil, err
+	}
+	return &segmenterv1.CreateUsersResponse{Results: toUserResults(reply)}, nil
+}
+
+func (h *Handler) GetUser(ctx context.Context, req *segmenterv1.GetUserRequest) (*segmenterv1.User, error) {
+	user, err := h.service.GetUser(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+func (h *Handler) GetUsersSegments(
+	ctx context.Context, req *segmenterv1.GetUsersSegmentsRequest,
+) (*segmenterv1.GetUsersSegmentsResponse, error) {
+	if len(req.GetUsers()) > maxLookupUsers {
+		return nil, fmt.Errorf("%w: at most %d users are allowed", ErrValidation, maxLookupUsers)
+	}
+
+	result, err := h.service.GetUsersSegments(ctx, fromUserIDs(req.GetUsers()))
+	if err != nil {
+		return nil, err
+	}
+	reply := &segmenterv1.GetUsersSegmentsResponse{
+		Users:   make([]*segmenterv1.User, 0, len(result.Segments)),
+		Missing: toUserIDs(result.Missing),
+	}
+	for _, userID := range sortedUserIDs(result.Segments) {
+		reply.Users = append(reply.Users, toUser(models.User{ID: userID, Segments: result.Segments[userID]}))
+	}
+	return reply, nil
+}
+
+func (h *Handler) UpdateUser(ctx context.Context, req *segmenterv1.UpdateUserRequest) (*segmenterv1.User, error) {
+	now := time.Now()
+	addSegments := make([]models.SegmentMembership, 0, len(req.GetAddSegments()))
+	for _, segment := range req.GetAddSegments() {
+		membership, err := fromMembership(segment, now)
+		if err != nil {
+			return nil, err
+		}
+		addSegments = append(addSegments, membership)
+	}
+
+	userID := int(req.GetId())
+	err := h.service.UpdateUser(ctx, models.UpdateUserParams{
+		ID:             userID,
+		AddSegments:    addSegments,
+		DeleteSegments: req.GetDeleteSegments(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	user, err := h.service.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+func (h *Handler) DeleteUser(ctx context.Context, req *segmenterv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	if err := h.service.DeleteUser(ctx, int(req.GetId())); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *Handler) RestoreUser(ctx context.Context, req *segmenterv1.RestoreUserRequest) (*emptypb.Empty, error) {
+	if err := h.service.RestoreUser(ctx, int(req.GetId())); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *Handler) CreateSegments(
+	ctx context.Context, req *segmenterv1.CreateSegmentsRequest,
+) (*segmenterv1.CreateSegmentsResponse, error) {
+	segments := make([]models.Segment, 0, len(req.GetSegments()))
+	for _, segment := range req.GetSegments() {
+		if segment.GetAutoPercent() < 0 || segment.GetAutoPercent() > 100 {
+			return nil, fmt.Errorf(
+				"%w: segment '%s': auto_percent must be between 0 and 100", ErrValidation, segment.GetName(),
+			)
+		}
+		segments = append(segments, models.Segment{
+			Name:        segment.GetName(),
+			AutoPercent: int(segment.GetAutoPercent()),
+			Description: segment.GetDescription(),
+			Owner:       segment.GetOwner(),
+			Tags:        segment.GetTags(),
+		})
+	}
+
+	reply, err := h.service.CreateSegments(ctx, segments)
+	if err != nil {
+		return nil, err
+	}
+	return &segmenterv1.CreateSegmentsResponse{Results: reply}, nil
+}
+
+func (h *Handler) GetSegment(ctx context.Context, req *segmenterv1.GetSegmentRequest) (*segmenterv1.Segment, error) {
+	segment, err := h.service.GetSegment(ctx, req.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return toSegment(segment), nil
+}
+
+func (h *Handler) ListSegments(
+	ctx context.Context, req *segmenterv1.ListSegmentsRequest,
+) (*segmenterv1.ListSegmentsResponse, error) {
+	limit, err := parsePageSize(req.GetPageSize())
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := h.service.ListSegments(ctx, models.ListSegmentsParams{
+		Prefix: req.GetPrefix(),
+		After:  after,
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &segmenterv1.ListSegmentsResponse{Segments: make([]*segmenterv1.Segment, 0, len(page.Segments))}
+	for _, segment := range page.Segments {
+		reply.Segments = append(reply.Segments, toSegment(segment))
+	}
+	if page.HasMore {
+		reply.NextPageToken = encodePageToken(page.Segments[len(page.Segments)-1].Name)
+	}
+	return reply, nil
+}
+
+func (h *Handler) UpdateSegment(
+	ctx context.Context, req *segmenterv1.UpdateSegmentRequest,
+) (*segmenterv1.Segment, error) {
+	params := models.UpdateSegmentParams{
+		Name:        req.GetName(),
+		Description: req.Description,
+		Owner:       req.Owner,
+	}
+	if req.GetTags() != nil {
+		params.Tags = append([]string{}, req.GetTags().GetTags()...)
+	}
+
+	segment, err := h.service.UpdateSegment(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return toSegment(segment), nil
+}
+
+func (h *Handler) RenameSegment(
+	ctx context.Context, req *segmenterv1.RenameSegmentRequest,
+) (*segmenterv1.Segment, error) {
+	if req.GetNewName() == "" {
+		return nil, fmt.Errorf("%w: new segment name is empty", ErrValidation)
+	}
+
+	segment, err := h.service.RenameSegment(ctx, req.GetName(), req.GetNewName())
+	if err != nil {
+		return nil, err
+	}
+	return toSegment(segment), nil
+}
+
+func (h *Handler) MergeSegments(
+	ctx context.Context, req *segmenterv1.MergeSegmentsRequest,
+) (*segmenterv1.MergeSegmentsResponse, error) {
+	target := req.GetName()
+	if len(req.GetSources()) == 0 {
+		return nil, fmt.Errorf("%w: sources are empty", ErrValidation)
+	}
+	for _, source := range req.GetSources() {
+		if source == target {
+			return nil, fmt.Errorf("%w: segment '%s' cannot be merged into itself", ErrValidation, target)
+		}
+	}
+
+	added, err := h.service.MergeSegments(ctx, target, req.GetSources())
+	if err != nil {
+		return nil, err
+	}
+	return &segmenterv1.MergeSegmentsResponse{Added: added}, nil
+}
+
+func (h *Handler) DeleteSegment(ctx context.Context, req *segmenterv1.DeleteSegmentRequest) (*emptypb.Empty, error) {
+	if err := h.service.DeleteSegment(ctx, req.GetName()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *Handler) RestoreSegment(ctx context.Context, req *segmenterv1.RestoreSegmentRequest) (*emptypb.Empty, error) {
+	if err := h.service.RestoreSegment(ctx, req.GetName()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (h *Handler) ListSegmentUsers(
+	ctx context.Context, req *segmenterv1.ListSegmentUsersRequest,
+) (*segmenterv1.ListSegmentUsersResponse, error) {
+	limit, err := parsePageSize(req.GetPageSize())
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodeIDPageToken(req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := h.service.GetSegmentUsers(ctx, models.SegmentUsersParams{
+		Segment: req.GetName(),
+		After:   after,
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &segmenterv1.ListSegmentUsersResponse{Users: toUserIDs(page.Users), Total: int64(page.Total)}
+	if page.HasMore {
+		reply.NextPageToken = encodeIDPageToken(page.Users[len(page.Users)-1])
+	}
+	return reply, nil
+}
+
+func (h *Handler) AddSegmentUsers(
+	ctx context.Context, req *segmenterv1.AddSegmentUsersRequest,
+) (*segmenterv1.SegmentUsersResponse, error) {
+	if len(req.GetUsers()) > maxBulkUsers {
+		return nil, fmt.Errorf("%w: at most %d users are allowed", ErrValidation, maxBulkUsers)
+	}
+	expiresAt, err := fromExpiry(req.GetName(), req.GetExpiresAt(), req.GetTtl(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := h.service.AddSegmentUsers(ctx, req.GetName(), fromUserIDs(req.GetUsers()), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &segmenterv1.SegmentUsersResponse{Results: toUserResults(reply)}, nil
+}
+
+func (h *Handler) DeleteSegmentUsers(
+	ctx context.Context, req *segmenterv1.DeleteSegmentUsersRequest,
+) (*segmenterv1.SegmentUsersResponse, error) {
+	if len(req.GetUsers()) > maxBulkUsers {
+		return nil, fmt.Errorf("%w: at most %d users are allowed", ErrValidation, maxBulkUsers)
+	}
+
+	reply, err := h.service.DeleteSegmentUsers(ctx, req.GetName(), fromUserIDs(req.GetUsers()))
+	if err != nil {
+		return nil, err
+	}
+	return &segmenterv1.SegmentUsersResponse{Results: toUserResults(reply)}, nil
+}
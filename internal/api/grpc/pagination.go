@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// parsePageSize returns the page size, the default one if it is not set.
+func parsePageSize(size int32) (int, error) {
+	if size == 0 {
+		return defaultPageSize, nil
+	}
+	if size < 0 || size > maxPageSize {
+		return 0, fmt.Errorf("%w: page_size must be between 1 and %d", ErrValidation, maxPageSize)
+	}
+	return int(size), nil
+}
+
+// Page tokens are opaque for clients: they hold the key of the last item on the page,
+// encoded the same way as the cursors of the REST API.
+
+func encodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid page_token", ErrValidation)
+	}
+	return string(key), nil
+}
+
+func encodeIDPageToken(id int) string {
+	return encodePageToken(strconv.Itoa(id))
+}
+
+func decodeIDPageToken(token string) (*int, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	key, err := decodePageToken(token)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid page_token", ErrValidation)
+	}
+	return &id, nil
+}
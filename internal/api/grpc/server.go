@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	segmenterv1 "github.com/iTcatt/segmenter/api/segmenter/v1"
+	"github.com/iTcatt/segmenter/internal/auth"
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// NewServer returns the gRPC server of the handler. Server reflection is enabled,
+// so clients such as grpcurl can call it without the proto files. Calls are authenticated
+// by authenticator, anyone may call if it is nil.
+func NewServer(h *Handler, log *slog.Logger, authenticator *auth.Authenticator) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{errorsInterceptor, loggerInterceptor(log)}
+	if authenticator != nil {
+		interceptors = append(interceptors, authInterceptor(authenticator, log))
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	segmenterv1.RegisterSegmenterServer(server, h)
+	reflection.Register(server)
+	return server
+}
+
+// loggerInterceptor logs every call with the request ID of the x-request-id metadata,
+// a new one if it is missing, and the caller if authInterceptor identified it. The ID is sent back in the x-request-id header.
+// It runs within errorsInterceptor to log unexpected errors before they are hidden from clients.
+func loggerInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		id := requestID(ctx)
+		ctx = logger.TrackCaller(logger.WithRequestID(ctx, id))
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+
+		reply, err := handler(ctx, req)
+		code := status.Code(toStatus(err))
+		if code == codes.Internal {
+			log.ErrorContext(ctx, "grpc request failed", "method", info.FullMethod, "error", err)
+		}
+		log.InfoContext(ctx, "grpc request",
+			"method", info.FullMethod, "code", code.String(), "duration", time.Since(start))
+		return reply, err
+	}
+}
+
+const (
+	requestIDKey     = "x-request-id"
+	apiKeyKey        = "x-api-key"
+	authorizationKey = "authorization"
+)
+
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(requestIDKey); len(ids) > 0 && logger.ValidRequestID(ids[0]) {
+		return ids[0]
+	}
+	return logger.NewRequestID()
+}
+
+// authInterceptor identifies the caller by the x-api-key metadata or a bearer token of the authorization metadata,
+// calls without valid credentials fail with Unauthenticated. Records logged with the call context carry the caller.
+func authInterceptor(authenticator *auth.Authenticator, log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		identity, err := authenticator.Authenticate(first(md.Get(apiKeyKey)), first(md.Get(authorizationKey)))
+		if err != nil {
+			log.WarnContext(ctx, "unauthenticated request", "method", info.FullMethod, "error", err)
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(semconv.EnduserID(identity.String()))
+		ctx = auth.WithIdentity(ctx, identity)
+		ctx = logger.WithCaller(ctx, identity.String())
+		return handler(ctx, req)
+	}
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// errorsInterceptor maps errors of the service to gRPC status codes.
+func errorsInterceptor(
+	ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	reply, err := handler(ctx, req)
+	return reply, toStatus(err)
+}
+
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrNotCreated), errors.Is(err, storage.ErrNotExist):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, storage.ErrAlreadyExist):
+		return status.Error(codes.AlreadyExists, "already exists")
+	case errors.Is(err, ErrValidation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,260 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	segmenterv1 "github.com/iTcatt/segmenter/api/segmenter/v1"
+	"github.com/iTcatt/segmenter/internal/auth"
+	"github.com/iTcatt/segmenter/internal/config"
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+)
+
+func newTestClient(t *testing.T, authenticator *auth.Authenticator) segmenterv1.SegmenterClient {
+	listener := bufconn.Listen(1 << 20)
+	log := logger.Discard()
+	server := NewServer(NewHandler(service.NewService(memory.NewStorage(), log)), log, authenticator)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return segmenterv1.NewSegmenterClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+
+	segments, err := client.CreateSegments(ctx, &segmenterv1.CreateSegmentsRequest{
+		Segments: []*segmenterv1.Segment{{Name: "AVITO_VOICE_MESSAGES"}, {Name: "AVITO_DISCOUNT_30", Owner: "growth"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"AVITO_VOICE_MESSAGES": "created", "AVITO_DISCOUNT_30": "created"}, segments.Results)
+
+	users, err := client.CreateUsers(ctx, &segmenterv1.CreateUsersRequest{Users: []int64{1000, 1002}})
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{1000: "created", 1002: "created"}, users.Results)
+
+	user, err := client.UpdateUser(ctx, &segmenterv1.UpdateUserRequest{
+		Id: 1000,
+		AddSegments: []*segmenterv1.Membership{
+			{Segment: "AVITO_VOICE_MESSAGES"},
+			{Segment: "AVITO_DISCOUNT_30", Expiry: &segmenterv1.Membership_Ttl{Ttl: durationpb.New(time.Hour)}},
+		},
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"AVITO_VOICE_MESSAGES", "AVITO_DISCOUNT_30"}, user.Segments)
+
+	lookup, err := client.GetUsersSegments(ctx, &segmenterv1.GetUsersSegmentsRequest{Users: []int64{1002, 1000, 1004}})
+	require.NoError(t, err)
+	require.Len(t, lookup.Users, 2)
+	assert.Equal(t, int64(1000), lookup.Users[0].Id)
+	assert.Equal(t, []string{"AVITO_DISCOUNT_30", "AVITO_VOICE_MESSAGES"}, lookup.Users[0].Segments)
+	assert.Equal(t, int64(1002), lookup.Users[1].Id)
+	assert.Empty(t, lookup.Users[1].Segments)
+	assert.Equal(t, []int64{1004}, lookup.Missing)
+
+	added, err := client.AddSegmentUsers(ctx, &segmenterv1.AddSegmentUsersRequest{
+		Name:  "AVITO_VOICE_MESSAGES",
+		Users: []int64{1000, 1002, 1004},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{1000: "already exist", 1002: "added", 1004: "not created"}, added.Results)
+
+	first, err := client.ListSegmentUsers(ctx, &segmenterv1.ListSegmentUsersRequest{
+		Name:     "AVITO_VOICE_MESSAGES",
+		PageSize: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1000}, first.Users)
+	assert.Equal(t, int64(2), first.Total)
+	require.NotEmpty(t, first.NextPageToken)
+	second, err := client.ListSegmentUsers(ctx, &segmenterv1.ListSegmentUsersRequest{
+		Name:      "AVITO_VOICE_MESSAGES",
+		PageSize:  1,
+		PageToken: first.NextPageToken,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1002}, second.Users)
+	assert.Empty(t, second.NextPageToken)
+
+	segment, err := client.UpdateSegment(ctx, &segmenterv1.UpdateSegmentRequest{
+		Name: "AVITO_DISCOUNT_30",
+		Tags: &segmenterv1.Tags{Tags: []string{"promo"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "growth", segment.Owner)
+	assert.Equal(t, []string{"promo"}, segment.Tags)
+
+	merged, err := client.MergeSegments(ctx, &segmenterv1.MergeSegmentsRequest{
+		Name:    "AVITO_DISCOUNT_30",
+		Sources: []string{"AVITO_VOICE_MESSAGES"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), merged.Added)
+
+	_, err = client.DeleteUser(ctx, &segmenterv1.DeleteUserRequest{Id: 1002})
+	require.NoError(t, err)
+	_, err = client.RestoreUser(ctx, &segmenterv1.RestoreUserRequest{Id: 1002})
+	require.NoError(t, err)
+	user, err = client.GetUser(ctx, &segmenterv1.GetUserRequest{Id: 1002})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_DISCOUNT_30"}, user.Segments)
+}
+
+func TestServer_Errors(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, nil)
+
+	_, err := client.CreateSegments(ctx, &segmenterv1.CreateSegmentsRequest{
+		Segments: []*segmenterv1.Segment{{Name: "AVITO_VOICE_MESSAGES"}, {Name: "AVITO_DISCOUNT_30"}},
+	})
+	require.NoError(t, err)
+	_, err = client.CreateUsers(ctx, &segmenterv1.CreateUsersRequest{Users: []int64{1000}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		call     func() error
+		expected codes.Code
+	}{
+		{
+			name: "missing user",
+			call: func() error {
+				_, err := client.GetUser(ctx, &segmenterv1.GetUserRequest{Id: 1002})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "missing segment",
+			call: func() error {
+				_, err := client.DeleteSegment(ctx, &segmenterv1.DeleteSegmentRequest{Name: "AVITO_PERFORMANCE_VAS"})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "taken segment name",
+			call: func() error {
+				_, err := client.RenameSegment(ctx, &segmenterv1.RenameSegmentRequest{
+					Name:    "AVITO_VOICE_MESSAGES",
+					NewName: "AVITO_DISCOUNT_30",
+				})
+				return err
+			},
+			expected: codes.AlreadyExists,
+		},
+		{
+			name: "expiry in the past",
+			call: func() error {
+				_, err := client.UpdateUser(ctx, &segmenterv1.UpdateUserRequest{
+					Id: 1000,
+					AddSegments: []*segmenterv1.Membership{{
+						Segment: "AVITO_VOICE_MESSAGES",
+						Expiry: &segmenterv1.Membership_ExpiresAt{
+							ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour)),
+						},
+					}},
+				})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "invalid page size",
+			call: func() error {
+				_, err := client.ListSegments(ctx, &segmenterv1.ListSegmentsRequest{PageSize: maxPageSize + 1})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, status.Code(test.call()))
+		})
+	}
+}
+
+func TestServer_RequestID(t *testing.T) {
+	client := newTestClient(t, nil)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "0f3a9c2d")
+
+	var header metadata.MD
+	_, err := client.ListSegments(ctx, &segmenterv1.ListSegmentsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0f3a9c2d"}, header.Get("x-request-id"))
+
+	_, err = client.ListSegments(context.Background(), &segmenterv1.ListSegmentsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Len(t, header.Get("x-request-id"), 1, "a missing ID is generated")
+}
+
+func TestServer_Auth(t *testing.T) {
+	hash := sha256.Sum256([]byte("ci-key"))
+	authenticator, err := auth.New(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "ci", Hash: hex.EncodeToString(hash[:])}},
+	})
+	require.NoError(t, err)
+	client := newTestClient(t, authenticator)
+
+	_, err = client.ListSegments(context.Background(), &segmenterv1.ListSegmentsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "ci-key2")
+	_, err = client.ListSegments(ctx, &segmenterv1.ListSegmentsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "ci-key")
+	_, err = client.ListSegments(ctx, &segmenterv1.ListSegmentsRequest{})
+	assert.NoError(t, err)
+}
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected codes.Code
+	}{
+		{err: nil, expected: codes.OK},
+		{err: fmt.Errorf("get user: %w", storage.ErrNotExist), expected: codes.NotFound},
+		{err: storage.ErrNotCreated, expected: codes.NotFound},
+		{err: storage.ErrAlreadyExist, expected: codes.AlreadyExists},
+		{err: fmt.Errorf("%w: sources are empty", ErrValidation), expected: codes.InvalidArgument},
+		{err: context.DeadlineExceeded, expected: codes.DeadlineExceeded},
+		{err: status.Error(codes.Unimplemented, "unimplemented"), expected: codes.Unimplemented},
+		{err: errors.New("unexpected error"), expected: codes.Internal},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, status.Code(toStatus(test.err)), "%v", test.err)
+	}
+}
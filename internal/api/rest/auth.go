@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/iTcatt/segmenter/internal/auth"
+	"github.com/iTcatt/segmenter/internal/logger"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// authMiddleware identifies the caller by the X-API-Key header or a bearer token of the Authorization header,
+// requests without valid credentials are rejected with 401. The caller is put into the request context,
+// records logged with it and the request span carry the caller.
+func authMiddleware(authenticator *auth.Authenticator, log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, err := authenticator.Authenticate(r.Header.Get(apiKeyHeader), r.Header.Get("Authorization"))
+			if err != nil {
+				log.WarnContext(r.Context(), "unauthenticated request", "method", r.Method, "path", r.URL.Path, "error", err)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				_ = sendJSONResponse(w, ErrorResponse{Message: err.Error()}, http.StatusUnauthorized)
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.EnduserID(identity.String()))
+			ctx := auth.WithIdentity(r.Context(), identity)
+			ctx = logger.WithCaller(ctx, identity.String())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/auth"
+	"github.com/iTcatt/segmenter/internal/config"
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+)
+
+const jwtSecret = "0123456789abcdef0123456789abcdef"
+
+func newAuthServer(t *testing.T) (*httptest.Server, *bytes.Buffer) {
+	hash := sha256.Sum256([]byte("ci-key"))
+	authenticator, err := auth.New(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "ci", Hash: hex.EncodeToString(hash[:])}},
+		JWT:     config.JWTConfig{Secret: jwtSecret},
+	})
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	log, err := logger.New(&logs, config.LogConfig{Level: "info", Format: logger.FormatJSON})
+	require.NoError(t, err)
+	h := NewHandler(service.NewService(memory.NewStorage(), logger.Discard()), log, HandlerOptions{})
+	server := httptest.NewServer(NewRouter(h, RouterOptions{Auth: authenticator}))
+	t.Cleanup(server.Close)
+	return server, &logs
+}
+
+func token(t *testing.T, secret string, expiresIn time.Duration) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(expiresIn).Unix(),
+	}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return "Bearer " + signed
+}
+
+func get(t *testing.T, server *httptest.Server, path string, header http.Header) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	require.NoError(t, err)
+	req.Header = header
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// records decodes the JSON records logged with msg. The server has to be closed first, so its handlers returned.
+func records(t *testing.T, logs *bytes.Buffer, msg string) []map[string]any {
+	var found []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(logs.Bytes()))
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		if record["msg"] == msg {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+func TestAuthMiddleware_Unauthenticated(t *testing.T) {
+	server, logs := newAuthServer(t)
+
+	for name, header := range map[string]http.Header{
+		"no credentials":  {},
+		"unknown API key": {apiKeyHeader: {"ci-key2"}},
+		"expired token":   {"Authorization": {token(t, jwtSecret, -time.Hour)}},
+		"wrong secret":    {"Authorization": {token(t, jwtSecret+"!", time.Hour)}},
+		"basic scheme":    {"Authorization": {"Basic dXNlcjpwYXNz"}},
+	} {
+		resp := get(t, server, "/api/segment", header)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, name)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"), name)
+		var body ErrorResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body), name)
+		assert.Contains(t, body.Message, auth.ErrUnauthenticated.Error(), name)
+	}
+
+	server.Close()
+	rejected := records(t, logs, "unauthenticated request")
+	require.Len(t, rejected, 5)
+	assert.Equal(t, "WARN", rejected[0]["level"])
+	assert.Equal(t, "/api/segment", rejected[0]["path"])
+	for _, record := range records(t, logs, "http request") {
+		assert.EqualValues(t, http.StatusUnauthorized, record["status"])
+		assert.NotContains(t, record, "caller")
+	}
+}
+
+func TestAuthMiddleware_Authenticated(t *testing.T) {
+	server, logs := newAuthServer(t)
+
+	resp := get(t, server, "/api/segment", http.Header{apiKeyHeader: {"ci-key"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = get(t, server, "/api/segment", http.Header{"Authorization": {token(t, jwtSecret, time.Hour)}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// The API key is checked first, a token is not used if it is wrong.
+	resp = get(t, server, "/api/segment", http.Header{
+		apiKeyHeader:    {"ci-key2"},
+		"Authorization": {token(t, jwtSecret, time.Hour)},
+	})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Health checks stay open.
+	resp = get(t, server, "/healthz", http.Header{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	server.Close()
+	requests := records(t, logs, "http request")
+	require.Len(t, requests, 3, "probes are logged at debug")
+	assert.Equal(t, "api_key:ci", requests[0]["caller"])
+	assert.Equal(t, "jwt:user-1", requests[1]["caller"])
+	assert.NotContains(t, requests[2], "caller")
+}
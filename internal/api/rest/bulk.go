@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxBulkUsers is the number of users a bulk request may list if HandlerOptions leave it unset.
+	DefaultMaxBulkUsers = 100_000
+	// bulkBytesPerUser bounds the body of a bulk request together with the number of users:
+	// it fits a user ID with a separator and indentation.
+	bulkBytesPerUser = 32
+
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// readUserIDs reads user IDs of a bulk request: a JSON array, or one ID per line
+// if the body is sent as application/x-ndjson, so large lists can be streamed.
+// Bodies listing more than maxBulkUsers users are rejected without being read in full.
+func (h *Handler) readUserIDs(w http.ResponseWriter, r *http.Request) ([]int, error) {
+	body := http.MaxBytesReader(w, r.Body, int64(h.maxBulkUsers)*bulkBytesPerUser)
+	ids, err := h.decodeUserIDs(r, body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, fmt.Errorf("%w: at most %d users are allowed, body is larger than %d bytes",
+			ErrValidation, h.maxBulkUsers, maxBytesErr.Limit)
+	}
+	return ids, err
+}
+
+func (h *Handler) decodeUserIDs(r *http.Request, body io.Reader) ([]int, error) {
+	tooMany := fmt.Errorf("%w: at most %d users are allowed", ErrValidation, h.maxBulkUsers)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeNDJSON {
+		// The array is decoded element by element to stop at the limit.
+		decoder := json.NewDecoder(body)
+		invalid := fmt.Errorf("%w: body must be a JSON array of user IDs", ErrValidation)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, wrapMaxBytes(err, invalid)
+		}
+		ids := make([]int, 0)
+		for decoder.More() {
+			if len(ids) == h.maxBulkUsers {
+				return nil, tooMany
+			}
+			var id int
+			if err := decoder.Decode(&id); err != nil {
+				return nil, wrapMaxBytes(err, invalid)
+			}
+			ids = append(ids, id)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, wrapMaxBytes(err, invalid)
+		}
+		return ids, nil
+	}
+
+	ids := make([]int, 0)
+	scanner := bufio.NewScanner(body)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		id, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid user ID '%s'", ErrValidation, line, text)
+		}
+		if len(ids) == h.maxBulkUsers {
+			return nil, tooMany
+		}
+		ids = append(ids, id)
+	}
+	return ids, scanner.Err()
+}
+
+// wrapMaxBytes returns err if the body was larger than allowed, otherwise the validation error.
+func wrapMaxBytes(err, validationErr error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return validationErr
+}
+
+// parseExpiry returns the expiry of bulk added memberships from the expires_at or ttl query parameters.
+func parseExpiry(r *http.Request, segment string) (*time.Time, error) {
+	query := r.URL.Query()
+	req := addSegmentRequest{Name: segment, TTL: query.Get("ttl")}
+	if value := query.Get("expires_at"); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: segment '%s': invalid expires_at '%s'", ErrValidation, segment, value)
+		}
+		req.ExpiresAt = &expiresAt
+	}
+
+	membership, err := req.toMembership(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return membership.ExpiresAt, nil
+}
@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+)
+
+func TestHandler_AddSegmentUsers_Limits(t *testing.T) {
+	s := service.NewService(memory.NewStorage(), logger.Discard())
+	_, err := s.CreateSegments(context.Background(), []models.Segment{{Name: "a"}})
+	require.NoError(t, err)
+	router := NewRouter(NewHandler(s, logger.Discard(), HandlerOptions{MaxBulkUsers: 2}), RouterOptions{})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		message     string
+	}{
+		{name: "array at the limit", body: "[1, 2]", status: http.StatusOK},
+		{name: "array above the limit", body: "[1, 2, 3]", status: http.StatusBadRequest, message: "at most 2 users"},
+		{
+			name:    "body above the limit",
+			body:    "[1" + strings.Repeat(" ", 2*bulkBytesPerUser) + "]",
+			status:  http.StatusBadRequest,
+			message: "body is larger than 64 bytes",
+		},
+		{name: "not an array", body: `{"users": [1]}`, status: http.StatusBadRequest, message: "JSON array"},
+		{name: "not a user ID", body: `[1, "a"]`, status: http.StatusBadRequest, message: "JSON array"},
+		{name: "lines at the limit", contentType: contentTypeNDJSON, body: "1\n2\n", status: http.StatusOK},
+		{
+			name:        "lines above the limit",
+			contentType: contentTypeNDJSON,
+			body:        "1\n2\n3\n",
+			status:      http.StatusBadRequest,
+			message:     "at most 2 users",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/segment/a/users", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tt.message)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// heartbeatInterval is how often an idle event stream sends a comment, so proxies keep the connection open.
+var heartbeatInterval = 15 * time.Second
+
+// reconnectDelay is the delay before the browser reconnects to a dropped event stream.
+const reconnectDelay = 3 * time.Second
+
+// @Summary		StreamUserEvents
+// @Description	stream events of the user as Server-Sent Events: membership changes and user.deleted.
+// @Description	The id of an event resumes the stream from it in the Last-Event-ID header;
+// @Description	a reset event means the events after it were lost and the user has to be reloaded.
+// @Tags		events
+// @Param		id				path	int		true	"user ID"
+// @Param		Last-Event-ID	header	string	false	"id of the last received event"
+// @Produce		text/event-stream
+// @Success		200
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/user/{id}/events [get]
+func (h *Handler) StreamUserEvents(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid user id '%s'", ErrValidation, id)
+	}
+	if _, err = h.service.GetUser(r.Context(), userID); err != nil {
+		return err
+	}
+	return h.streamEvents(w, r, models.EventFilter{UserID: &userID})
+}
+
+// @Summary		StreamEvents
+// @Description	stream all events as Server-Sent Events, only events of the given segments if any.
+// @Description	The id of an event resumes the stream from it in the Last-Event-ID header;
+// @Description	a reset event means the events after it were lost and the state has to be reloaded.
+// @Tags		events
+// @Param		segment			query	[]string	false	"segment name"	collectionFormat(multi)
+// @Param		Last-Event-ID	header	string		false	"id of the last received event"
+// @Produce		text/event-stream
+// @Success		200
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/events [get]
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) error {
+	segments := r.URL.Query()["segment"]
+	return h.streamEvents(w, r, models.EventFilter{Segments: segments})
+}
+
+// streamEvents writes the events selected by filter until the client disconnects or falls behind,
+// or the app shuts down.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request, filter models.EventFilter) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("response writer does not support streaming")
+	}
+
+	subscription, unsubscribe := h.service.SubscribeEvents(filter, r.Header.Get("Last-Event-ID"))
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay.Milliseconds()); err != nil {
+		return nil
+	}
+	if subscription.Reset {
+		if _, err := fmt.Fprint(w, "event: reset\ndata: {}\n\n"); err != nil {
+			return nil
+		}
+	}
+	for _, event := range subscription.Missed {
+		if err := writeEvent(w, event); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// The client fell behind or the app shuts down, it resumes from the last event it got.
+				return nil
+			}
+			if err := writeEvent(w, event); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// streamService hands out a subscription fed by the test and records how it was subscribed.
+type streamService struct {
+	SegmentService
+
+	subscription service.Subscription
+
+	mu          sync.Mutex
+	filter      models.EventFilter
+	lastEventID string
+}
+
+func (s *streamService) GetUser(_ context.Context, id int) (models.User, error) {
+	if id != 1000 {
+		return models.User{}, storage.ErrNotExist
+	}
+	return models.User{ID: id, Segments: []string{}}, nil
+}
+
+func (s *streamService) SubscribeEvents(filter models.EventFilter, lastEventID string) (service.Subscription, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter, s.lastEventID = filter, lastEventID
+	return s.subscription, func() {}
+}
+
+func openStream(t *testing.T, s SegmentService, path, lastEventID string) *bufio.Reader {
+	server := httptest.NewServer(NewRouter(NewHandler(s, logger.Discard(), HandlerOptions{}), RouterOptions{}))
+	t.Cleanup(server.Close)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	return bufio.NewReader(resp.Body)
+}
+
+// readFrame reads the stream up to the blank line ending a frame.
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	var frame strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return frame.String()
+		}
+		frame.WriteString(line)
+	}
+}
+
+func eventFrame(t *testing.T, event models.Event) string {
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+	return "id: " + event.ID + "\nevent: " + string(event.Type) + "\ndata: " + string(data) + "\n"
+}
+
+func TestHandler_StreamEvents(t *testing.T) {
+	userID := 1000
+	missed := models.Event{ID: "8", Type: models.EventMembershipAdded, UserID: &userID, Segment: "AVITO_VOICE_MESSAGES"}
+	live := models.Event{ID: "9", Type: models.EventMembershipRemoved, UserID: &userID, Segment: "AVITO_VOICE_MESSAGES"}
+	events := make(chan models.Event, 1)
+	s := &streamService{subscription: service.Subscription{Missed: []models.Event{missed}, Events: events}}
+
+	stream := openStream(t, s, "/api/events?segment=AVITO_VOICE_MESSAGES&segment=AVITO_PERFORMANCE_VAS", "7")
+	assert.Equal(t, "retry: 3000\n", readFrame(t, stream))
+	assert.Equal(t, eventFrame(t, missed), readFrame(t, stream))
+
+	events <- live
+	assert.Equal(t, eventFrame(t, live), readFrame(t, stream))
+
+	// A closed subscription ends the stream, the client reconnects with the last event ID.
+	close(events)
+	_, err := stream.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, []string{"AVITO_VOICE_MESSAGES", "AVITO_PERFORMANCE_VAS"}, s.filter.Segments)
+	assert.Equal(t, "7", s.lastEventID)
+}
+
+func TestHandler_StreamEvents_Reset(t *testing.T) {
+	userID := 1000
+	missed := models.Event{ID: "12", Type: models.EventUserDeleted, UserID: &userID}
+	s := &streamService{subscription: service.Subscription{
+		Missed: []models.Event{missed},
+		Reset:  true,
+		Events: make(chan models.Event),
+	}}
+
+	// The reset event comes before the buffered events, the client reloads the state and then applies them.
+	stream := openStream(t, s, "/api/events", "3")
+	assert.Equal(t, "retry: 3000\n", readFrame(t, stream))
+	assert.Equal(t, "event: reset\ndata: {}\n", readFrame(t, stream))
+	assert.Equal(t, eventFrame(t, missed), readFrame(t, stream))
+}
+
+func TestHandler_StreamEvents_Heartbeat(t *testing.T) {
+	interval := heartbeatInterval
+	heartbeatInterval = 10 * time.Millisecond
+	t.Cleanup(func() { heartbeatInterval = interval })
+	s := &streamService{subscription: service.Subscription{Events: make(chan models.Event)}}
+
+	stream := openStream(t, s, "/api/events", "")
+	assert.Equal(t, "retry: 3000\n", readFrame(t, stream))
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, ": heartbeat\n", readFrame(t, stream))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Empty(t, s.lastEventID)
+	assert.Empty(t, s.filter.Segments)
+}
+
+func TestHandler_StreamUserEvents(t *testing.T) {
+	s := &streamService{subscription: service.Subscription{Events: make(chan models.Event)}}
+	server := httptest.NewServer(NewRouter(NewHandler(s, logger.Discard(), HandlerOptions{}), RouterOptions{}))
+	t.Cleanup(server.Close)
+
+	for path, status := range map[string]int{"/api/user/abc/events": http.StatusBadRequest, "/api/user/1002/events": http.StatusNotFound} {
+		resp, err := server.Client().Get(server.URL + path)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, path)
+	}
+
+	stream := openStream(t, s, "/api/user/1000/events", "")
+	assert.Equal(t, "retry: 3000\n", readFrame(t, stream))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.NotNil(t, s.filter.UserID)
+	assert.Equal(t, 1000, *s.filter.UserID)
+}
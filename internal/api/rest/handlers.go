@@ -2,49 +2,118 @@ package rest
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
 )
 
 var ErrValidation = errors.New("validation error")
 
 type SegmentService interface {
-	CreateSegments(context.Context, []string) (map[string]string, error)
+	CreateSegments(context.Context, []models.Segment) (map[string]string, error)
+	GetSegment(context.Context, string) (models.Segment, error)
+	UpdateSegment(context.Context, models.UpdateSegmentParams) (models.Segment, error)
+	RenameSegment(context.Context, string, string) (models.Segment, error)
+	MergeSegments(context.Context, string, []string) (int64, error)
 	CreateUsers(context.Context, []int) (map[int]string, error)
+	AddSegmentUsers(context.Context, string, []int, *time.Time) (map[int]string, error)
+	DeleteSegmentUsers(context.Context, string, []int) (map[int]string, error)
 
 	GetUser(context.Context, int) (models.User, error)
+	GetUsersSegments(context.Context, []int) (models.UsersSegments, error)
 
 	UpdateUser(context.Context, models.UpdateUserParams) error
 
+	ListSegments(context.Context, models.ListSegmentsParams) (models.SegmentsPage, error)
+	GetSegmentUsers(context.Context, models.SegmentUsersParams) (models.SegmentUsersPage, error)
+
+	GetReport(context.Context, time.Time) ([]models.HistoryRecord, error)
+
+	StartImport(io.Reader) (models.ImportJob, error)
+	GetImport(int) (models.ImportJob, error)
+	GetImportErrors(int) ([]models.ImportRowError, error)
+
+	ExportSnapshot(context.Context, io.Writer) (models.SnapshotStats, error)
+
+	CreateWebhook(context.Context, models.Webhook) (models.Webhook, error)
+	ListWebhooks(context.Context) ([]models.Webhook, error)
+	DeleteWebhook(context.Context, int) error
+	ListDeadLetters(context.Context, int) ([]models.DeadLetter, error)
+	ReplayDeadLetters(context.Context, int) (int, error)
+
+	SubscribeEvents(models.EventFilter, string) (service.Subscription, func())
+
 	DeleteSegment(context.Context, string) error
 	DeleteUser(context.Context, int) error
+	RestoreSegment(context.Context, string) error
+	RestoreUser(context.Context, int) error
 }
 
 type Handler struct {
-	service SegmentService
+	service      SegmentService
+	log          *slog.Logger
+	maxBulkUsers int
+}
+
+// HandlerOptions configures the limits of the handler, zero fields take their defaults.
+type HandlerOptions struct {
+	// MaxBulkUsers is the number of users a bulk membership request may list, DefaultMaxBulkUsers by default.
+	MaxBulkUsers int
 }
 
-func NewHandler(s SegmentService) *Handler {
+func NewHandler(s SegmentService, log *slog.Logger, opts HandlerOptions) *Handler {
+	if opts.MaxBulkUsers <= 0 {
+		opts.MaxBulkUsers = DefaultMaxBulkUsers
+	}
 	return &Handler{
-		service: s,
+		service:      s,
+		log:          log,
+		maxBulkUsers: opts.MaxBulkUsers,
 	}
 }
 
+// createSegmentRequest is a segments entry: either a plain segment name or an object with metadata
+// and auto_percent, the share of users automatically enrolled into the segment.
+type createSegmentRequest struct {
+	Name        string   `json:"name"`
+	AutoPercent int      `json:"auto_percent,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func (c *createSegmentRequest) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		c.Name = name
+		return nil
+	}
+
+	type plain createSegmentRequest
+	return json.Unmarshal(data, (*plain)(c))
+}
+
 // @Summary		CreateSegments
-// @Description	Create segments
+// @Description	Create segments. A segments entry is a segment name or {"name", "auto_percent", "description", "owner", "tags"}
 // @Tags			segment
 // @Accept			json
 // @Produce		json
 // @Success		200	{object}	map[string]string
 // @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
 // @Router			/segment [post]
 func (h *Handler) CreateSegments(w http.ResponseWriter, r *http.Request) error {
 	body, err := io.ReadAll(r.Body)
@@ -53,13 +122,29 @@ func (h *Handler) CreateSegments(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var req struct {
-		Segments []string `json:"segments"`
+		Segments []createSegmentRequest `json:"segments"`
 	}
 	if err = json.Unmarshal(body, &req); err != nil {
 		return err
 	}
-	log.Printf("CreateSegments request: %v", req)
-	reply, err := h.service.CreateSegments(r.Context(), req.Segments)
+
+	segments := make([]models.Segment, 0, len(req.Segments))
+	for _, segment := range req.Segments {
+		if segment.AutoPercent < 0 || segment.AutoPercent > 100 {
+			h.log.DebugContext(r.Context(), "invalid auto_percent",
+				"segment", segment.Name, "auto_percent", segment.AutoPercent)
+			return fmt.Errorf("%w: segment '%s': auto_percent must be between 0 and 100", ErrValidation, segment.Name)
+		}
+		segments = append(segments, models.Segment{
+			Name:        segment.Name,
+			AutoPercent: segment.AutoPercent,
+			Description: segment.Description,
+			Owner:       segment.Owner,
+			Tags:        segment.Tags,
+		})
+	}
+
+	reply, err := h.service.CreateSegments(r.Context(), segments)
 	if err != nil {
 		return err
 	}
@@ -73,6 +158,9 @@ func (h *Handler) CreateSegments(w http.ResponseWriter, r *http.Request) error {
 // @Produce		json
 // @Success		200	{object}	map[int]string
 // @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
 // @Router			/user [post]
 func (h *Handler) CreateUsers(w http.ResponseWriter, r *http.Request) error {
 	body, err := io.ReadAll(r.Body)
@@ -87,8 +175,6 @@ func (h *Handler) CreateUsers(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	log.Printf("Create users request: %v", req)
-
 	reply, err := h.service.CreateUsers(r.Context(), req.Users)
 	if err != nil {
 		return err
@@ -96,8 +182,48 @@ func (h *Handler) CreateUsers(w http.ResponseWriter, r *http.Request) error {
 	return sendJSONResponse(w, reply, http.StatusCreated)
 }
 
+// addSegmentRequest is an add_segments entry: either a plain segment name
+// or an object that limits the membership by expires_at (RFC 3339) or ttl (e.g. "720h").
+type addSegmentRequest struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+}
+
+func (a *addSegmentRequest) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		a.Name = name
+		return nil
+	}
+
+	type plain addSegmentRequest
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+func (a addSegmentRequest) toMembership(now time.Time) (models.SegmentMembership, error) {
+	membership := models.SegmentMembership{Segment: a.Name}
+	switch {
+	case a.ExpiresAt != nil && a.TTL != "":
+		return membership, fmt.Errorf("%w: segment '%s': expires_at and ttl are mutually exclusive", ErrValidation, a.Name)
+	case a.ExpiresAt != nil:
+		if !a.ExpiresAt.After(now) {
+			return membership, fmt.Errorf("%w: segment '%s': expires_at is in the past", ErrValidation, a.Name)
+		}
+		membership.ExpiresAt = a.ExpiresAt
+	case a.TTL != "":
+		ttl, err := time.ParseDuration(a.TTL)
+		if err != nil || ttl <= 0 {
+			return membership, fmt.Errorf("%w: segment '%s': invalid ttl '%s'", ErrValidation, a.Name, a.TTL)
+		}
+		expiresAt := now.Add(ttl)
+		membership.ExpiresAt = &expiresAt
+	}
+	return membership, nil
+}
+
 // @Summary		UpdateUser
-// @Description	Update user segments
+// @Description	Update user segments. An add_segments entry is a segment name or {"name", "expires_at"|"ttl"}
 // @Tags			user
 // @Param			id	path	int	true	"userID"
 // @Accept			json
@@ -105,16 +231,18 @@ func (h *Handler) CreateUsers(w http.ResponseWriter, r *http.Request) error {
 // @Success		200	{object}	models.User
 // @Failure		404
 // @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
 // @Router			/user/{id} [patch]
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
-	op := "UpdateUser:"
+	op := "UpdateUser"
 
 	id := chi.URLParam(r, "id")
-	log.Printf("%s received user_id '%s'", op, id)
 
 	userID, err := strconv.Atoi(id)
 	if err != nil {
-		log.Printf("%v: %v", ErrValidation, err)
+		h.log.DebugContext(r.Context(), "invalid request", "op", op, "error", err)
 		return ErrValidation
 	}
 
@@ -124,17 +252,27 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var req struct {
-		AddSegments    []string `json:"add_segments"`
-		DeleteSegments []string `json:"delete_segments"`
+		AddSegments    []addSegmentRequest `json:"add_segments"`
+		DeleteSegments []string            `json:"delete_segments"`
 	}
 	if err = json.Unmarshal(body, &req); err != nil {
 		return err
 	}
-	log.Printf("%s received '%v'", op, req)
+
+	now := time.Now()
+	addSegments := make([]models.SegmentMembership, 0, len(req.AddSegments))
+	for _, segment := range req.AddSegments {
+		membership, err := segment.toMembership(now)
+		if err != nil {
+			h.log.DebugContext(r.Context(), "invalid request", "op", op, "error", err)
+			return err
+		}
+		addSegments = append(addSegments, membership)
+	}
 
 	err = h.service.UpdateUser(r.Context(), models.UpdateUserParams{
 		ID:             userID,
-		AddSegments:    req.AddSegments,
+		AddSegments:    addSegments,
 		DeleteSegments: req.DeleteSegments,
 	})
 	if err != nil {
@@ -155,16 +293,18 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
 // @Success		200	{object}	models.User
 // @Failure		404
 // @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
 // @Router		/user/{id} [get]
 func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) error {
-	op := "GetUserSegmentsHandler:"
+	op := "GetUserSegmentsHandler"
 
 	id := chi.URLParam(r, "id")
-	log.Printf("%s received user_id '%s'", op, id)
 
 	userID, err := strconv.Atoi(id)
 	if err != nil {
-		log.Printf("%v: %v", ErrValidation, err)
+		h.log.DebugContext(r.Context(), "invalid request", "op", op, "error", err)
 		return ErrValidation
 	}
 
@@ -176,19 +316,425 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) error {
 	return sendJSONResponse(w, user, http.StatusOK)
 }
 
+// maxLookupUsers bounds the number of users looked up by one request.
+const maxLookupUsers = 1000
+
+type GetUsersSegmentsResponse struct {
+	Users   map[int][]string `json:"users"`
+	Missing []int            `json:"missing"`
+}
+
+// @Summary		GetUsersSegments
+// @Description	get segments of many users at once; users that do not exist are listed in missing
+// @Tags		user
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	GetUsersSegmentsResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/user/batch [post]
+func (h *Handler) GetUsersSegments(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Users []int `json:"users"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("%w: body must be {\"users\": [user IDs]}", ErrValidation)
+	}
+	if len(req.Users) > maxLookupUsers {
+		return fmt.Errorf("%w: at most %d users are allowed", ErrValidation, maxLookupUsers)
+	}
+
+	result, err := h.service.GetUsersSegments(r.Context(), req.Users)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, GetUsersSegmentsResponse{Users: result.Segments, Missing: result.Missing}, http.StatusOK)
+}
+
+// @Summary		GetSegment
+// @Description	get segment with its metadata
+// @Tags		segment
+// @Param		name	path	string	true	"segment name"
+// @Produce		json
+// @Success		200	{object}	models.Segment
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name} [get]
+func (h *Handler) GetSegment(w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	segment, err := h.service.GetSegment(r.Context(), name)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, segment, http.StatusOK)
+}
+
+// @Summary		UpdateSegment
+// @Description	update segment metadata; omitted fields are left unchanged
+// @Tags		segment
+// @Param		name	path	string	true	"segment name"
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	models.Segment
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name} [patch]
+func (h *Handler) UpdateSegment(w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Description *string  `json:"description"`
+		Owner       *string  `json:"owner"`
+		Tags        []string `json:"tags"`
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		return err
+	}
+
+	segment, err := h.service.UpdateSegment(r.Context(), models.UpdateSegmentParams{
+		Name:        name,
+		Description: req.Description,
+		Owner:       req.Owner,
+		Tags:        req.Tags,
+	})
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, segment, http.StatusOK)
+}
+
+// @Summary		RenameSegment
+// @Description	rename segment keeping its members and history
+// @Tags		segment
+// @Param		name	path	string	true	"segment name"
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	models.Segment
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		409
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name}/rename [post]
+func (h *Handler) RenameSegment(w http.ResponseWriter, r *http.Request) error {
+	name := chi.URLParam(r, "name")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		return err
+	}
+
+	if req.Name == "" {
+		return fmt.Errorf("%w: new segment name is empty", ErrValidation)
+	}
+
+	segment, err := h.service.RenameSegment(r.Context(), name, req.Name)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, segment, http.StatusOK)
+}
+
+type MergeSegmentsResponse struct {
+	Added int64 `json:"added"`
+}
+
+// @Summary		MergeSegments
+// @Description	move members of the source segments into the segment and delete the sources
+// @Tags		segment
+// @Param		name	path	string	true	"target segment name"
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	MergeSegmentsResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name}/merge [post]
+func (h *Handler) MergeSegments(w http.ResponseWriter, r *http.Request) error {
+	target := chi.URLParam(r, "name")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Sources []string `json:"sources"`
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		return err
+	}
+
+	if len(req.Sources) == 0 {
+		return fmt.Errorf("%w: sources are empty", ErrValidation)
+	}
+	for _, source := range req.Sources {
+		if source == target {
+			return fmt.Errorf("%w: segment '%s' cannot be merged into itself", ErrValidation, target)
+		}
+	}
+
+	added, err := h.service.MergeSegments(r.Context(), target, req.Sources)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, MergeSegmentsResponse{Added: added}, http.StatusOK)
+}
+
+type ListSegmentsResponse struct {
+	Segments   []models.Segment `json:"segments"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// @Summary		ListSegments
+// @Description	list segments ordered by name
+// @Tags		segment
+// @Param		prefix	query	string	false	"segment name prefix"
+// @Param		cursor	query	string	false	"next_cursor from the previous page"
+// @Param		limit	query	int		false	"page size, 100 by default"
+// @Produce		json
+// @Success		200	{object}	ListSegmentsResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment [get]
+func (h *Handler) ListSegments(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	limit, err := parseLimit(query)
+	if err != nil {
+		return err
+	}
+	after, err := decodeCursor(query.Get("cursor"))
+	if err != nil {
+		return err
+	}
+
+	page, err := h.service.ListSegments(r.Context(), models.ListSegmentsParams{
+		Prefix: query.Get("prefix"),
+		After:  after,
+		Limit:  limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply := ListSegmentsResponse{Segments: page.Segments}
+	if page.HasMore {
+		reply.NextCursor = encodeCursor(page.Segments[len(page.Segments)-1].Name)
+	}
+	return sendJSONResponse(w, reply, http.StatusOK)
+}
+
+type SegmentUsersResponse struct {
+	Users      []int  `json:"users"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// @Summary		GetSegmentUsers
+// @Description	list IDs of the segment members
+// @Tags		segment
+// @Param		name	path	string	true	"segment name"
+// @Param		cursor	query	string	false	"next_cursor from the previous page"
+// @Param		limit	query	int		false	"page size, 100 by default"
+// @Produce		json
+// @Success		200	{object}	SegmentUsersResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name}/users [get]
+func (h *Handler) GetSegmentUsers(w http.ResponseWriter, r *http.Request) error {
+	segment := chi.URLParam(r, "name")
+	query := r.URL.Query()
+	limit, err := parseLimit(query)
+	if err != nil {
+		return err
+	}
+	after, err := decodeIDCursor(query.Get("cursor"))
+	if err != nil {
+		return err
+	}
+
+	page, err := h.service.GetSegmentUsers(r.Context(), models.SegmentUsersParams{
+		Segment: segment,
+		After:   after,
+		Limit:   limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply := SegmentUsersResponse{Users: page.Users, Total: page.Total}
+	if page.HasMore {
+		reply.NextCursor = encodeCursor(strconv.Itoa(page.Users[len(page.Users)-1]))
+	}
+	return sendJSONResponse(w, reply, http.StatusOK)
+}
+
+// @Summary		AddSegmentUsers
+// @Description	add many users to the segment. The body is a JSON array of user IDs or, with the
+// @Description	application/x-ndjson content type, one user ID per line
+// @Tags		segment
+// @Param		name		path	string	true	"segment name"
+// @Param		ttl			query	string	false	"membership duration, e.g. 72h"
+// @Param		expires_at	query	string	false	"membership expiry time in RFC 3339"
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	map[int]string
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name}/users [post]
+func (h *Handler) AddSegmentUsers(w http.ResponseWriter, r *http.Request) error {
+	segment := chi.URLParam(r, "name")
+
+	expiresAt, err := parseExpiry(r, segment)
+	if err != nil {
+		return err
+	}
+	users, err := h.readUserIDs(w, r)
+	if err != nil {
+		return err
+	}
+
+	reply, err := h.service.AddSegmentUsers(r.Context(), segment, users, expiresAt)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, reply, http.StatusOK)
+}
+
+// @Summary		DeleteSegmentUsers
+// @Description	remove many users from the segment. The body is the same as for adding users
+// @Tags		segment
+// @Param		name	path	string	true	"segment name"
+// @Accept		json
+// @Produce		json
+// @Success		200	{object}	map[int]string
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name}/users [delete]
+func (h *Handler) DeleteSegmentUsers(w http.ResponseWriter, r *http.Request) error {
+	segment := chi.URLParam(r, "name")
+
+	users, err := h.readUserIDs(w, r)
+	if err != nil {
+		return err
+	}
+
+	reply, err := h.service.DeleteSegmentUsers(r.Context(), segment, users)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, reply, http.StatusOK)
+}
+
+// reportHeader names the columns of the report, it is the first line of the CSV.
+var reportHeader = []string{"user_id", "segment", "operation", "timestamp"}
+
+// @Summary		GetReport
+// @Description	get CSV report of membership changes for the month, the first line is the header user_id;segment;operation;timestamp
+// @Tags		report
+// @Param		period	query	string	true	"month in YYYY-MM format"
+// @Produce		text/csv
+// @Success		200	{file}	file
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/report [get]
+func (h *Handler) GetReport(w http.ResponseWriter, r *http.Request) error {
+	op := "GetReport"
+
+	period := r.URL.Query().Get("period")
+
+	month, err := time.Parse("2006-01", period)
+	if err != nil {
+		h.log.DebugContext(r.Context(), "invalid request", "op", op, "error", err)
+		return ErrValidation
+	}
+
+	records, err := h.service.GetReport(r.Context(), month)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"report_%s.csv\"", period))
+
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+	if err = writer.Write(reportHeader); err != nil {
+		return err
+	}
+	for _, record := range records {
+		err = writer.Write([]string{
+			strconv.Itoa(record.UserID),
+			record.Segment,
+			string(record.Operation),
+			record.Timestamp.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
 // @Summary		DeleteSegment
-// @Description	delete segment
+// @Description	archive segment; it can be restored until the archive retention passes
 // @Tags		segment
 // @Param		name	path	string	true	"segment name"
 // @Success		204
 // @Failure		404
 // @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
 // @Router		/segment/{name} [delete]
 func (h *Handler) DeleteSegment(w http.ResponseWriter, r *http.Request) error {
-	op := "DeleteSegment"
-
 	segment := chi.URLParam(r, "name")
-	log.Printf("%s received segment name '%s'", op, segment)
 
 	if err := h.service.DeleteSegment(r.Context(), segment); err != nil {
 		return err
@@ -199,22 +745,24 @@ func (h *Handler) DeleteSegment(w http.ResponseWriter, r *http.Request) error {
 }
 
 // @Summary		DeleteUser
-// @Description	delete user
+// @Description	archive user; it can be restored until the archive retention passes
 // @Tags		user
 // @Param		id	path	int	true	"userID"
 // @Success		204
 // @Failure		404
 // @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
 // @Router		/user/{id} [delete]
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
-	op := "DeleteUserHandler:"
+	op := "DeleteUserHandler"
 
 	id := chi.URLParam(r, "id")
-	log.Printf("%s received user_id '%s'", op, id)
 
 	userID, err := strconv.Atoi(id)
 	if err != nil {
-		log.Printf("%v: %v", ErrValidation, err)
+		h.log.DebugContext(r.Context(), "invalid request", "op", op, "error", err)
 		return ErrValidation
 	}
 
@@ -225,3 +773,56 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
+
+// @Summary		RestoreSegment
+// @Description	restore archived segment with its memberships
+// @Tags		segment
+// @Param		name	path	string	true	"segment name"
+// @Success		204
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/segment/{name}/restore [post]
+func (h *Handler) RestoreSegment(w http.ResponseWriter, r *http.Request) error {
+	segment := chi.URLParam(r, "name")
+
+	if err := h.service.RestoreSegment(r.Context(), segment); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// @Summary		RestoreUser
+// @Description	restore archived user with its memberships
+// @Tags		user
+// @Param		id	path	int	true	"userID"
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/user/{id}/restore [post]
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) error {
+	op := "RestoreUser"
+
+	id := chi.URLParam(r, "id")
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		h.log.DebugContext(r.Context(), "invalid request", "op", op, "error", err)
+		return ErrValidation
+	}
+
+	if err := h.service.RestoreUser(r.Context(), userID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+)
+
+func TestHandler_GetReport(t *testing.T) {
+	ctx := context.Background()
+	s := service.NewService(memory.NewStorage(), logger.Discard())
+	_, err := s.CreateSegments(ctx, []models.Segment{{Name: "a"}})
+	require.NoError(t, err)
+	_, err = s.CreateUsers(ctx, []int{1})
+	require.NoError(t, err)
+	_, err = s.AddSegmentUsers(ctx, "a", []int{1}, nil)
+	require.NoError(t, err)
+	router := NewRouter(NewHandler(s, logger.Discard(), HandlerOptions{}), RouterOptions{})
+
+	period := time.Now().UTC().Format("2006-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/report?period="+period, nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	reader := csv.NewReader(rec.Body)
+	reader.Comma = ';'
+	lines, err := reader.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"user_id", "segment", "operation", "timestamp"}, lines[0])
+	assert.Equal(t, []string{"1", "a", "add"}, lines[1][:3])
+}
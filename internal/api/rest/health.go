@@ -0,0 +1,32 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/iTcatt/segmenter/internal/health"
+)
+
+// probePaths are polled by the orchestrator every few seconds, so they are neither traced
+// nor logged above the debug level.
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// livenessHandler reports that the process is up and serving requests.
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	_ = sendJSONResponse(w, health.Report{Status: health.StatusOK}, http.StatusOK)
+}
+
+// readinessHandler reports every check of the checker, with 503 if any of them fails.
+func readinessHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Ready(r.Context())
+		status := http.StatusOK
+		if report.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		_ = sendJSONResponse(w, report, status)
+	}
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/iTcatt/segmenter/internal/service"
+)
+
+// maxImportSize bounds the size of an uploaded CSV file.
+const maxImportSize = 64 << 20
+
+// @Summary		StartImport
+// @Description	import users and memberships from a CSV of user_id,segment[,expires_at] rows in the background
+// @Tags		import
+// @Accept		text/csv
+// @Produce		json
+// @Success		202	{object}	models.ImportJob
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/import [post]
+func (h *Handler) StartImport(w http.ResponseWriter, r *http.Request) error {
+	job, err := h.service.StartImport(http.MaxBytesReader(w, r.Body, maxImportSize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return fmt.Errorf("%w: file is larger than %d bytes", ErrValidation, maxBytesErr.Limit)
+	}
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, job, http.StatusAccepted)
+}
+
+// @Summary		GetImport
+// @Description	get import job progress
+// @Tags		import
+// @Param		id	path	int	true	"import job ID"
+// @Produce		json
+// @Success		200	{object}	models.ImportJob
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/import/{id} [get]
+func (h *Handler) GetImport(w http.ResponseWriter, r *http.Request) error {
+	id, err := importID(r)
+	if err != nil {
+		return err
+	}
+
+	job, err := h.service.GetImport(id)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, job, http.StatusOK)
+}
+
+// @Summary		GetImportErrors
+// @Description	download rejected rows of the import job as CSV of line,user_id,segment,expires_at,reason
+// @Tags		import
+// @Param		id	path	int	true	"import job ID"
+// @Produce		text/csv
+// @Success		200
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/import/{id}/errors [get]
+func (h *Handler) GetImportErrors(w http.ResponseWriter, r *http.Request) error {
+	id, err := importID(r)
+	if err != nil {
+		return err
+	}
+
+	rowErrors, err := h.service.GetImportErrors(id)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"import_%d_errors.csv\"", id))
+	return service.WriteImportErrors(w, rowErrors)
+}
+
+func importID(r *http.Request) (int, error) {
+	id := chi.URLParam(r, "id")
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid import id '%s'", ErrValidation, id)
+	}
+	return n, nil
+}
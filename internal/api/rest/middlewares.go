@@ -1,11 +1,17 @@
 package rest
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/iTcatt/segmenter/internal/logger"
 	"github.com/iTcatt/segmenter/internal/storage"
 )
 
@@ -26,6 +32,8 @@ func errorsMiddleware(h wrapperHandler) http.HandlerFunc {
 			w.WriteHeader(http.StatusNotFound)
 		case errors.Is(err, storage.ErrNotExist):
 			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, storage.ErrAlreadyExist):
+			w.WriteHeader(http.StatusConflict)
 		case errors.Is(err, ErrValidation):
 			_ = sendJSONResponse(w, ErrorResponse{Message: err.Error()}, http.StatusBadRequest)
 		default:
@@ -36,12 +44,81 @@ func errorsMiddleware(h wrapperHandler) http.HandlerFunc {
 
 func sendJSONResponse(w http.ResponseWriter, data interface{}, status int) error {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(data)
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMiddleware takes the request ID from the X-Request-ID header, a new one if it is missing
+// or malformed, and sends it back in the same header. Records logged with the request context carry it.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !logger.ValidRequestID(id) {
+			id = logger.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logger.WithRequestID(r.Context(), id)))
+	})
+}
+
+// maxLoggedBody limits the logged part of request and response bodies, streams have no end.
+const maxLoggedBody = 4 << 10
 
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		return err
+// loggerMiddleware logs every request once it is served, with the caller if authMiddleware identified it.
+// Bodies are logged only if logBodies is set: they may carry user data, and are cut to maxLoggedBody.
+func loggerMiddleware(log *slog.Logger, logBodies bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			r = r.WithContext(logger.TrackCaller(r.Context()))
+
+			var request, response limitedBuffer
+			if logBodies {
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(r.Body, &request), r.Body}
+				ww.Tee(&response)
+			}
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			attrs := []any{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"duration", time.Since(start),
+			}
+			if logBodies {
+				attrs = append(attrs, "request_body", request.String(), "response_body", response.String())
+			}
+			level := slog.LevelInfo
+			switch {
+			case probePaths[r.URL.Path]:
+				level = slog.LevelDebug
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			}
+			log.Log(r.Context(), level, "http request", attrs...)
+		})
 	}
-	w.WriteHeader(status)
+}
 
-	log.Printf("sending response: %v", data)
-	return nil
+// limitedBuffer keeps the first maxLoggedBody bytes written to it and drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if free := maxLoggedBody - b.Len(); free > 0 {
+		b.Buffer.Write(p[:min(len(p), free)])
+	}
+	return len(p), nil
 }
@@ -0,0 +1,57 @@
+package rest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// parseLimit returns the page size from the limit query parameter.
+func parseLimit(query url.Values) (int, error) {
+	limit := query.Get("limit")
+	if limit == "" {
+		return defaultPageLimit, nil
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 || n > maxPageLimit {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrValidation, maxPageLimit)
+	}
+	return n, nil
+}
+
+// Cursors are opaque for clients: they hold the key of the last item on the page.
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid cursor", ErrValidation)
+	}
+	return string(key), nil
+}
+
+func decodeIDCursor(cursor string) (*int, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	key, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrValidation)
+	}
+	return &id, nil
+}
@@ -4,23 +4,71 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/iTcatt/segmenter/docs"
+	"github.com/iTcatt/segmenter/internal/auth"
+	"github.com/iTcatt/segmenter/internal/health"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/swaggo/http-swagger"
 )
 
-func NewRouter(h *Handler) http.Handler {
+// RouterOptions configures the middlewares of the router.
+type RouterOptions struct {
+	// LogBodies logs request and response bodies along with requests.
+	LogBodies bool
+	// Health runs the readiness checks of /readyz.
+	Health *health.Checker
+	// Auth authenticates the callers of the API, which is open to anyone if it is nil.
+	// Health checks, metrics and the API docs are always open.
+	Auth *auth.Authenticator
+}
+
+func NewRouter(h *Handler, opts RouterOptions) http.Handler {
 	router := chi.NewRouter()
 
-	router.Use(middleware.Logger)
+	router.Use(tracingMiddleware)
+	router.Use(requestIDMiddleware)
+	router.Use(loggerMiddleware(h.log, opts.LogBodies))
+	router.Use(metricsMiddleware)
+
+	router.Group(func(router chi.Router) {
+		if opts.Auth != nil {
+			router.Use(authMiddleware(opts.Auth, h.log))
+		}
 
-	router.Get("/api/user/{id}", errorsMiddleware(h.GetUser))
-	router.Post("/api/user", errorsMiddleware(h.CreateUsers))
-	router.Post("/api/segment", errorsMiddleware(h.CreateSegments))
-	router.Patch("/api/user/{id}", errorsMiddleware(h.UpdateUser))
-	router.Delete("/api/user/{id}", errorsMiddleware(h.DeleteUser))
-	router.Delete("/api/segment/{name}", errorsMiddleware(h.DeleteSegment))
+		router.Get("/api/user/{id}", errorsMiddleware(h.GetUser))
+		router.Get("/api/user/{id}/events", errorsMiddleware(h.StreamUserEvents))
+		router.Post("/api/user", errorsMiddleware(h.CreateUsers))
+		router.Post("/api/user/batch", errorsMiddleware(h.GetUsersSegments))
+		router.Post("/api/segment", errorsMiddleware(h.CreateSegments))
+		router.Get("/api/segment", errorsMiddleware(h.ListSegments))
+		router.Get("/api/segment/{name}", errorsMiddleware(h.GetSegment))
+		router.Patch("/api/segment/{name}", errorsMiddleware(h.UpdateSegment))
+		router.Post("/api/segment/{name}/rename", errorsMiddleware(h.RenameSegment))
+		router.Post("/api/segment/{name}/merge", errorsMiddleware(h.MergeSegments))
+		router.Get("/api/segment/{name}/users", errorsMiddleware(h.GetSegmentUsers))
+		router.Post("/api/segment/{name}/users", errorsMiddleware(h.AddSegmentUsers))
+		router.Delete("/api/segment/{name}/users", errorsMiddleware(h.DeleteSegmentUsers))
+		router.Patch("/api/user/{id}", errorsMiddleware(h.UpdateUser))
+		router.Delete("/api/user/{id}", errorsMiddleware(h.DeleteUser))
+		router.Post("/api/user/{id}/restore", errorsMiddleware(h.RestoreUser))
+		router.Delete("/api/segment/{name}", errorsMiddleware(h.DeleteSegment))
+		router.Post("/api/segment/{name}/restore", errorsMiddleware(h.RestoreSegment))
+		router.Get("/api/report", errorsMiddleware(h.GetReport))
+		router.Post("/api/import", errorsMiddleware(h.StartImport))
+		router.Get("/api/import/{id}", errorsMiddleware(h.GetImport))
+		router.Get("/api/import/{id}/errors", errorsMiddleware(h.GetImportErrors))
+		router.Get("/api/export", errorsMiddleware(h.ExportSnapshot))
+		router.Post("/api/webhook", errorsMiddleware(h.CreateWebhook))
+		router.Get("/api/webhook", errorsMiddleware(h.ListWebhooks))
+		router.Delete("/api/webhook/{id}", errorsMiddleware(h.DeleteWebhook))
+		router.Get("/api/webhook/{id}/dead-letters", errorsMiddleware(h.ListDeadLetters))
+		router.Post("/api/webhook/{id}/replay", errorsMiddleware(h.ReplayDeadLetters))
+		router.Get("/api/events", errorsMiddleware(h.StreamEvents))
+	})
 
+	router.Get("/healthz", livenessHandler)
+	router.Get("/readyz", readinessHandler(opts.Health))
+	router.Handle("/metrics", promhttp.Handler())
 	router.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL("swagger/doc.json")))
 
 	return router
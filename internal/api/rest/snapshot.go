@@ -0,0 +1,28 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// @Summary		ExportSnapshot
+// @Description	stream all segments with metadata, users and memberships as a versioned JSON lines snapshot
+// @Tags		snapshot
+// @Produce		application/x-ndjson
+// @Success		200
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/export [get]
+func (h *Handler) ExportSnapshot(w http.ResponseWriter, r *http.Request) error {
+	filename := fmt.Sprintf("segmenter_%s.jsonl", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	if _, err := h.service.ExportSnapshot(r.Context(), w); err != nil {
+		// The response has already started; a snapshot without the end record is rejected by the restore.
+		h.log.ErrorContext(r.Context(), "export snapshot", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+type createWebhookRequest struct {
+	URL    string             `json:"url"`
+	Events []models.EventType `json:"events,omitempty"`
+	Secret string             `json:"secret,omitempty"`
+}
+
+type ReplayDeadLettersResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// @Summary		CreateWebhook
+// @Description	subscribe URL to events: user.created, user.deleted, segment.created, segment.deleted,
+// @Description	membership.added, membership.removed and membership.expired; all of them if events are empty.
+// @Description	The secret that signs deliveries is generated unless given and is returned only here.
+// @Tags		webhook
+// @Accept		json
+// @Produce		json
+// @Param		webhook	body	createWebhookRequest	true	"webhook"
+// @Success		201	{object}	models.Webhook
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/webhook [post]
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) error {
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("%w: %v", ErrValidation, err)
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrValidation)
+	}
+	for _, event := range req.Events {
+		if !knownEvent(event) {
+			return fmt.Errorf("%w: unknown event '%s'", ErrValidation, event)
+		}
+	}
+
+	webhook, err := h.service.CreateWebhook(r.Context(), models.Webhook{
+		URL:    req.URL,
+		Events: req.Events,
+		Secret: req.Secret,
+	})
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, webhook, http.StatusCreated)
+}
+
+// @Summary		ListWebhooks
+// @Description	list webhooks without their secrets
+// @Tags		webhook
+// @Produce		json
+// @Success		200	{array}	models.Webhook
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/webhook [get]
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) error {
+	webhooks, err := h.service.ListWebhooks(r.Context())
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, webhooks, http.StatusOK)
+}
+
+// @Summary		DeleteWebhook
+// @Description	delete webhook with its dead letters
+// @Tags		webhook
+// @Param		id	path	int	true	"webhook ID"
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/webhook/{id} [delete]
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	id, err := webhookID(r)
+	if err != nil {
+		return err
+	}
+
+	if err = h.service.DeleteWebhook(r.Context(), id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// @Summary		ListDeadLetters
+// @Description	list events that were not delivered to the webhook after all attempts
+// @Tags		webhook
+// @Param		id	path	int	true	"webhook ID"
+// @Produce		json
+// @Success		200	{array}	models.DeadLetter
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/webhook/{id}/dead-letters [get]
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	id, err := webhookID(r)
+	if err != nil {
+		return err
+	}
+
+	letters, err := h.service.ListDeadLetters(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, letters, http.StatusOK)
+}
+
+// @Summary		ReplayDeadLetters
+// @Description	queue events of the dead letters of the webhook for delivery again with a fresh number of attempts;
+// @Description	the dead letters are removed
+// @Tags		webhook
+// @Param		id	path	int	true	"webhook ID"
+// @Produce		json
+// @Success		200	{object}	ReplayDeadLettersResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404
+// @Failure		500	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Security	ApiKeyAuth
+// @Security	BearerAuth
+// @Router		/webhook/{id}/replay [post]
+func (h *Handler) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) error {
+	id, err := webhookID(r)
+	if err != nil {
+		return err
+	}
+
+	replayed, err := h.service.ReplayDeadLetters(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	return sendJSONResponse(w, ReplayDeadLettersResponse{Replayed: replayed}, http.StatusOK)
+}
+
+func webhookID(r *http.Request) (int, error) {
+	id := chi.URLParam(r, "id")
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid webhook id '%s'", ErrValidation, id)
+	}
+	return n, nil
+}
+
+func knownEvent(event models.EventType) bool {
+	for _, known := range models.EventTypes {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
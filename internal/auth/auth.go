@@ -0,0 +1,165 @@
+// Package auth identifies the callers of the API by static API keys and JWT bearer tokens.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/iTcatt/segmenter/internal/config"
+)
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// ErrUnauthenticated is returned if a request carries no credentials or they are not valid.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// minSecretLength is the shortest HMAC secret accepted, shorter ones can be brute-forced from a token.
+const minSecretLength = 32
+
+// leeway is the clock skew allowed when checking the expiration and the start time of tokens.
+const leeway = 30 * time.Second
+
+// Identity is the caller of a request.
+type Identity struct {
+	// Subject names the caller: the name of the API key or the subject of the token.
+	Subject string
+	// Method is how the caller authenticated, MethodAPIKey or MethodJWT.
+	Method string
+}
+
+func (i Identity) String() string {
+	return i.Method + ":" + i.Subject
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity of the caller.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the caller, false if the request was not authenticated.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// Authenticator verifies the credentials of requests.
+type Authenticator struct {
+	apiKeys map[[sha256.Size]byte]string
+	secret  []byte
+	keys    map[string]publicKey
+	parser  *jwt.Parser
+}
+
+// New creates an Authenticator accepting the API keys and the tokens set by cfg.
+// It fails if cfg sets neither, such an Authenticator would reject every request.
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{apiKeys: make(map[[sha256.Size]byte]string, len(cfg.APIKeys))}
+	names := make(map[string]bool, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		if key.Name == "" {
+			return nil, errors.New("API key has no name")
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("API key '%s' is listed twice", key.Name)
+		}
+		names[key.Name] = true
+
+		var hash [sha256.Size]byte
+		if n, err := hex.Decode(hash[:], []byte(key.Hash)); err != nil || n != sha256.Size {
+			return nil, fmt.Errorf("API key '%s': hash is not a hex SHA-256", key.Name)
+		}
+		a.apiKeys[hash] = key.Name
+	}
+
+	var methods []string
+	if cfg.JWT.Secret != "" {
+		if len(cfg.JWT.Secret) < minSecretLength {
+			return nil, fmt.Errorf("JWT secret is shorter than %d bytes", minSecretLength)
+		}
+		a.secret = []byte(cfg.JWT.Secret)
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.JWT.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWT.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("load JWKS: %w", err)
+		}
+		a.keys = keys
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512",
+			"ES256", "ES384", "ES512", "EdDSA")
+	}
+	if len(a.apiKeys) == 0 && len(methods) == 0 {
+		return nil, errors.New("no API keys, JWT secret or JWKS file are set")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired(), jwt.WithLeeway(leeway)}
+	if cfg.JWT.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.JWT.Issuer))
+	}
+	if cfg.JWT.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.JWT.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// Authenticate identifies the caller by the API key or, if it is empty, by the bearer token
+// of the Authorization header.
+func (a *Authenticator) Authenticate(apiKey, authorization string) (Identity, error) {
+	if apiKey != "" {
+		name, ok := a.apiKeys[sha256.Sum256([]byte(apiKey))]
+		if !ok {
+			return Identity{}, fmt.Errorf("%w: unknown API key", ErrUnauthenticated)
+		}
+		return Identity{Subject: name, Method: MethodAPIKey}, nil
+	}
+
+	scheme, token, _ := strings.Cut(authorization, " ")
+	if authorization == "" {
+		return Identity{}, fmt.Errorf("%w: missing credentials", ErrUnauthenticated)
+	}
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return Identity{}, fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+	}
+	if a.secret == nil && a.keys == nil {
+		return Identity{}, fmt.Errorf("%w: tokens are not accepted", ErrUnauthenticated)
+	}
+
+	var claims jwt.RegisteredClaims
+	if _, err := a.parser.ParseWithClaims(token, &claims, a.key); err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	return Identity{Subject: claims.Subject, Method: MethodJWT}, nil
+}
+
+// key returns the key verifying the signature of the token: the secret for HMAC,
+// otherwise the key of the JWKS named by the kid header.
+func (a *Authenticator) key(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := a.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key '%s' is not for %s", kid, token.Method.Alg())
+	}
+	return key.key, nil
+}
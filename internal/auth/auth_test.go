@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/config"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key crypto.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return "Bearer " + signed
+}
+
+func claims(subject string, expiresIn time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": subject,
+		"iss": "https://auth.example.com",
+		"aud": "segmenter",
+		"exp": time.Now().Add(expiresIn).Unix(),
+	}
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestAuthenticator_APIKey(t *testing.T) {
+	a, err := New(config.AuthConfig{APIKeys: []config.APIKeyConfig{
+		{Name: "ci", Hash: hash("ci-key")},
+		{Name: "analytics", Hash: hash("analytics-key")},
+	}})
+	require.NoError(t, err)
+
+	identity, err := a.Authenticate("analytics-key", "")
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Subject: "analytics", Method: MethodAPIKey}, identity)
+	assert.Equal(t, "api_key:analytics", identity.String())
+
+	_, err = a.Authenticate("ci-key2", "")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = a.Authenticate("", "")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = a.Authenticate("", sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims("user", time.Hour)))
+	assert.ErrorIs(t, err, ErrUnauthenticated, "tokens are not accepted without a secret or JWKS")
+}
+
+func TestAuthenticator_HMAC(t *testing.T) {
+	a, err := New(config.AuthConfig{JWT: config.JWTConfig{
+		Secret:   secret,
+		Issuer:   "https://auth.example.com",
+		Audience: "segmenter",
+	}})
+	require.NoError(t, err)
+	key := []byte(secret)
+
+	identity, err := a.Authenticate("", sign(t, jwt.SigningMethodHS256, key, "", claims("user-1", time.Hour)))
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Subject: "user-1", Method: MethodJWT}, identity)
+
+	wrongIssuer := claims("user-1", time.Hour)
+	wrongIssuer["iss"] = "https://evil.example.com"
+	noExpiry := claims("user-1", time.Hour)
+	delete(noExpiry, "exp")
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{name: "expired", authorization: sign(t, jwt.SigningMethodHS256, key, "", claims("user-1", -time.Hour))},
+		{name: "no expiry", authorization: sign(t, jwt.SigningMethodHS256, key, "", noExpiry)},
+		{name: "wrong issuer", authorization: sign(t, jwt.SigningMethodHS256, key, "", wrongIssuer)},
+		{name: "no subject", authorization: sign(t, jwt.SigningMethodHS256, key, "", claims("", time.Hour))},
+		{
+			name:          "wrong secret",
+			authorization: sign(t, jwt.SigningMethodHS256, []byte(secret+"!"), "", claims("user-1", time.Hour)),
+		},
+		{
+			name:          "unsigned",
+			authorization: sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims("user-1", time.Hour)),
+		},
+		{name: "basic scheme", authorization: "Basic dXNlcjpwYXNz"},
+		{name: "malformed", authorization: "Bearer token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := a.Authenticate("", test.authorization)
+			assert.ErrorIs(t, err, ErrUnauthenticated)
+		})
+	}
+}
+
+func TestAuthenticator_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig",
+			"n": encodeInt(rsaKey.N), "e": encodeInt(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeInt(ecKey.X), "y": encodeInt(ecKey.Y)},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edPublic)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	a, err := New(config.AuthConfig{JWT: config.JWTConfig{JWKSFile: path}})
+	require.NoError(t, err)
+
+	for _, token := range []string{
+		sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", claims("user-1", time.Hour)),
+		sign(t, jwt.SigningMethodES256, ecKey, "ec", claims("user-1", time.Hour)),
+		sign(t, jwt.SigningMethodEdDSA, edKey, "ed", claims("user-1", time.Hour)),
+	} {
+		identity, err := a.Authenticate("", token)
+		require.NoError(t, err)
+		assert.Equal(t, Identity{Subject: "user-1", Method: MethodJWT}, identity)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	for name, token := range map[string]string{
+		"unknown kid":     sign(t, jwt.SigningMethodRS256, rsaKey, "other", claims("user-1", time.Hour)),
+		"wrong key":       sign(t, jwt.SigningMethodRS256, otherKey, "rsa", claims("user-1", time.Hour)),
+		"wrong algorithm": sign(t, jwt.SigningMethodRS512, rsaKey, "rsa", claims("user-1", time.Hour)),
+		"encryption key":  sign(t, jwt.SigningMethodRS256, rsaKey, "enc", claims("user-1", time.Hour)),
+		"HMAC":            sign(t, jwt.SigningMethodHS256, []byte(secret), "rsa", claims("user-1", time.Hour)),
+	} {
+		_, err := a.Authenticate("", token)
+		assert.ErrorIs(t, err, ErrUnauthenticated, name)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for name, cfg := range map[string]config.AuthConfig{
+		"no name":        {APIKeys: []config.APIKeyConfig{{Hash: hash("key")}}},
+		"listed twice":   {APIKeys: []config.APIKeyConfig{{Name: "ci", Hash: hash("a")}, {Name: "ci", Hash: hash("b")}}},
+		"plain key":      {APIKeys: []config.APIKeyConfig{{Name: "ci", Hash: "ci-key"}}},
+		"short secret":   {JWT: config.JWTConfig{Secret: "secret"}},
+		"no JWKS file":   {JWT: config.JWTConfig{JWKSFile: filepath.Join(t.TempDir(), "jwks.json")}},
+		"no credentials": {Enabled: true},
+	} {
+		_, err := New(cfg)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestIdentityContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	identity := Identity{Subject: "ci", Method: MethodAPIKey}
+	got, ok := FromContext(WithIdentity(context.Background(), identity))
+	assert.True(t, ok)
+	assert.Equal(t, identity, got)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// jwk is a public key of a JSON Web Key Set, RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey verifies tokens signed with alg, with any algorithm of its type if alg is empty.
+type publicKey struct {
+	alg string
+	key any
+}
+
+// loadJWKS reads the signing keys of the JWKS file by their kid. Encryption keys are skipped.
+func loadJWKS(path string) (map[string]publicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if _, ok := keys[k.Kid]; ok {
+			return nil, fmt.Errorf("key '%s' is listed twice", k.Kid)
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", k.Kid, err)
+		}
+		keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var validate ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, validate = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, validate = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, validate = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		// The point is checked to be on the curve by parsing it as an uncompressed ECDH key.
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x.Bytes()) > size || len(y.Bytes()) > size {
+			return nil, errors.New("point is not on the curve")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		x.FillBytes(point[1 : 1+size])
+		y.FillBytes(point[1+size:])
+		if _, err = validate.NewPublicKey(point); err != nil {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+// decodeInt decodes an unsigned big-endian integer in unpadded base64url.
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -8,21 +8,55 @@ import (
 )
 
 type Config struct {
-	Server  ServerConfig
-	Storage DatabaseConfig
+	Server   ServerConfig
+	Storage  DatabaseConfig
+	Reaper   ReaperConfig
+	Archive  ArchiveConfig
+	Webhooks WebhooksConfig
+	Outbox   OutboxConfig
+	Stream   StreamConfig
+	Cache    CacheConfig
+	Metrics  MetricsConfig
+	Tracing  TracingConfig
+	Log      LogConfig
+	Health   HealthConfig
+	Shutdown ShutdownConfig
+	Auth     AuthConfig
 }
 
+// ServerConfig sets the addresses of the REST API and the gRPC API.
+// MaxBulkUsers bounds the number of users listed by one bulk membership request to the REST API.
 type ServerConfig struct {
-	Endpoint string `yaml:"endpoint"`
+	Endpoint     string `yaml:"endpoint"`
+	GRPCEndpoint string `yaml:"grpc_endpoint" env:"GRPC_ENDPOINT" env-default:"[::]:3001"`
+	MaxBulkUsers int    `yaml:"max_bulk_users" env:"MAX_BULK_USERS" env-default:"100000"`
 }
 
+const (
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// DatabaseConfig selects the storage: Driver is either DriverPostgres or DriverMemory.
+// Connection settings are used by postgres only.
 type DatabaseConfig struct {
+	Driver   string        `yaml:"driver" env:"STORAGE_DRIVER" env-default:"postgres"`
 	Host     string        `yaml:"host"`
 	Port     string        `yaml:"port"`
 	DBName   string        `yaml:"name"`
 	User     string        `yaml:"user"`
 	Password string        `yaml:"password"`
 	Timeout  time.Duration `yaml:"timeout"`
+
+	MaxConns        int32         `yaml:"max_conns" env-default:"10"`
+	MinConns        int32         `yaml:"min_conns" env-default:"0"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" env-default:"1h"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" env-default:"30m"`
+}
+
+// ReaperConfig controls the background removal of expired segment memberships.
+type ReaperConfig struct {
+	Interval time.Duration `yaml:"interval" env-default:"1m"`
 }
 
 func MustLoad() Config {
@@ -37,3 +71,132 @@ func MustLoad() Config {
 	}
 	return config
 }
+
+// ArchiveConfig controls how long deleted segments and users can be restored before they are purged.
+type ArchiveConfig struct {
+	Retention     time.Duration `yaml:"retention" env-default:"720h"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env-default:"1h"`
+}
+
+// WebhooksConfig controls delivery of events to webhooks: the delivery queue is read every PollInterval
+// by BatchSize deliveries, failed deliveries are retried with a backoff that doubles from InitialBackoff
+// up to MaxBackoff, MaxAttempts times in total.
+type WebhooksConfig struct {
+	MaxAttempts     int           `yaml:"max_attempts" env-default:"6"`
+	InitialBackoff  time.Duration `yaml:"initial_backoff" env-default:"1s"`
+	MaxBackoff      time.Duration `yaml:"max_backoff" env-default:"5m"`
+	Timeout         time.Duration `yaml:"timeout" env-default:"10s"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env-default:"30s"`
+	PollInterval    time.Duration `yaml:"poll_interval" env-default:"1s"`
+	BatchSize       int           `yaml:"batch_size" env-default:"100"`
+}
+
+const (
+	SinkWebhook = "webhook"
+	SinkFile    = "file"
+	SinkKafka   = "kafka"
+)
+
+// OutboxConfig controls the relay of events from the outbox to the sinks listed in Sinks.
+// File is the path of the file sink, "-" for stdout. A failed round is retried with a backoff
+// that doubles from InitialBackoff up to MaxBackoff.
+type OutboxConfig struct {
+	PollInterval   time.Duration `yaml:"poll_interval" env-default:"1s"`
+	BatchSize      int           `yaml:"batch_size" env-default:"500"`
+	Workers        int           `yaml:"workers" env-default:"8"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env-default:"1s"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"1m"`
+	Sinks          []string      `yaml:"sinks" env:"OUTBOX_SINKS" env-default:"webhook"`
+	File           string        `yaml:"file" env-default:"-"`
+	Kafka          KafkaConfig   `yaml:"kafka"`
+}
+
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers" env:"KAFKA_BROKERS" env-default:"localhost:9092"`
+	Topic   string   `yaml:"topic" env-default:"segmenter.events"`
+}
+
+// StreamConfig controls the Server-Sent Events streams: the latest BufferSize events are kept
+// to resume streams by Last-Event-ID.
+type StreamConfig struct {
+	BufferSize int `yaml:"buffer_size" env-default:"1000"`
+}
+
+// CacheConfig controls the in-process cache of user lookups: up to Size users are kept for TTL.
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled" env:"CACHE_ENABLED" env-default:"false"`
+	Size    int           `yaml:"size" env-default:"100000"`
+	TTL     time.Duration `yaml:"ttl" env-default:"1m"`
+}
+
+// MetricsConfig controls the metrics served at /metrics: segment member counts are refreshed
+// every MembersInterval.
+type MetricsConfig struct {
+	MembersInterval time.Duration `yaml:"members_interval" env-default:"1m"`
+}
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// TracingConfig selects where spans are exported: ExporterOTLP sends them over gRPC to Endpoint,
+// ExporterStdout prints them to stderr, ExporterNone disables tracing. SampleRatio is the share of new traces
+// that are recorded, traces continued from a traceparent header follow its sampling decision.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" env-default:"localhost:4317"`
+	Insecure    bool    `yaml:"insecure" env-default:"true"`
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"`
+}
+
+// LogConfig sets the lowest level logged, debug, info, warn or error, and the format, text or json.
+// Request and response bodies are logged only if Bodies is set, as they may carry user data.
+type LogConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" env-default:"info"`
+	Format string `yaml:"format" env:"LOG_FORMAT" env-default:"text"`
+	Bodies bool   `yaml:"bodies" env:"LOG_BODIES" env-default:"false"`
+}
+
+// HealthConfig controls the readiness checks: a check that takes longer than CheckTimeout fails.
+// On SIGTERM the app reports itself not ready for DrainDelay before it stops serving,
+// so that the orchestrator moves traffic to other instances.
+type HealthConfig struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" env-default:"2s"`
+	DrainDelay   time.Duration `yaml:"drain_delay" env:"DRAIN_DELAY" env-default:"5s"`
+}
+
+// ShutdownConfig bounds the stages of the shutdown that follow the drain delay: in-flight HTTP and gRPC requests
+// are given Timeout to complete, background workers WorkersTimeout to stop and spans FlushTimeout to be exported.
+type ShutdownConfig struct {
+	Timeout        time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT" env-default:"15s"`
+	WorkersTimeout time.Duration `yaml:"workers_timeout" env-default:"10s"`
+	FlushTimeout   time.Duration `yaml:"flush_timeout" env-default:"5s"`
+}
+
+// AuthConfig controls who may call the REST and gRPC APIs. While Enabled, as it is by default, every request
+// has to carry an API key listed in APIKeys or a JWT verified as JWT sets, and the app does not start without
+// either; health checks, metrics and the API docs stay open. Disabling it opens the APIs to anyone.
+type AuthConfig struct {
+	Enabled bool           `yaml:"enabled" env:"AUTH_ENABLED" env-default:"true"`
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+	JWT     JWTConfig      `yaml:"jwt"`
+}
+
+// APIKeyConfig is a static API key: Hash is the hex SHA-256 of the key, so the key itself is kept
+// by its caller only. Name identifies the caller in logs.
+type APIKeyConfig struct {
+	Name string `yaml:"name"`
+	Hash string `yaml:"hash"`
+}
+
+// JWTConfig sets how bearer tokens are verified: HMAC-signed tokens with Secret, tokens signed
+// with RSA, ECDSA or Ed25519 with the public keys of the JWKS file. Either may be empty to disable it.
+// Issuer and Audience, if set, must match the claims of tokens.
+type JWTConfig struct {
+	Secret   string `yaml:"secret" env:"JWT_SECRET"`
+	JWKSFile string `yaml:"jwks_file" env:"JWT_JWKS_FILE"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
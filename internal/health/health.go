@@ -0,0 +1,129 @@
+// Package health reports whether the app is ready to serve traffic.
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Check returns an error if a dependency of the app is not usable.
+type Check func(ctx context.Context) error
+
+// Report is the outcome of every check. Status is StatusOK only if all checks pass.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	errStopped  = errors.New("stopped")
+	errDraining = errors.New("shutting down")
+)
+
+// Checker runs the readiness checks. Once Drain is called the app reports itself not ready,
+// so that traffic is moved to other instances before it stops.
+type Checker struct {
+	timeout  time.Duration
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// NewChecker returns a Checker that fails checks which take longer than timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add registers the check under the name, replacing a check of the same name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Go runs the background worker in a goroutine and registers a check of the same name
+// that fails once the worker returns.
+func (c *Checker) Go(name string, run func()) {
+	var stopped atomic.Bool
+	c.Add(name, func(context.Context) error {
+		if stopped.Load() {
+			return errStopped
+		}
+		return nil
+	})
+	go func() {
+		defer stopped.Store(true)
+		run()
+	}()
+}
+
+// Drain makes the app report itself not ready from now on.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Ready runs all checks concurrently and reports their outcome.
+func (c *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	c.mu.RLock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			errs[i] = run(ctx, check)
+		}(i, c.checks[name])
+	}
+	c.mu.RUnlock()
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(names)+1)}
+	if c.draining.Load() {
+		errs = append(errs, errDraining)
+		names = append(names, "shutdown")
+	}
+	for i, name := range names {
+		result := CheckResult{Status: StatusOK}
+		if errs[i] != nil {
+			result = CheckResult{Status: StatusFailing, Error: errs[i].Error()}
+			report.Status = StatusFailing
+		}
+		report.Checks[name] = result
+	}
+	return report
+}
+
+// run returns the error of the check, or the error of ctx if the check does not return in time.
+func run(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
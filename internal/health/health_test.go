@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Ready(t *testing.T) {
+	ctx := context.Background()
+	c := NewChecker(50 * time.Millisecond)
+	assert.Equal(t, Report{Status: StatusOK, Checks: map[string]CheckResult{}}, c.Ready(ctx))
+
+	c.Add("database", func(context.Context) error { return nil })
+	c.Add("migrations", func(context.Context) error { return errors.New("2 migrations are pending") })
+	c.Add("cache", func(ctx context.Context) error {
+		<-time.After(time.Second)
+		return nil
+	})
+
+	assert.Equal(t, Report{
+		Status: StatusFailing,
+		Checks: map[string]CheckResult{
+			"cache":      {Status: StatusFailing, Error: context.DeadlineExceeded.Error()},
+			"database":   {Status: StatusOK},
+			"migrations": {Status: StatusFailing, Error: "2 migrations are pending"},
+		},
+	}, c.Ready(ctx))
+}
+
+func TestChecker_Go(t *testing.T) {
+	ctx := context.Background()
+	c := NewChecker(time.Second)
+	stop := make(chan struct{})
+	c.Go("expiration_reaper", func() { <-stop })
+	assert.Equal(t, StatusOK, c.Ready(ctx).Status)
+
+	close(stop)
+	assert.Eventually(t, func() bool {
+		return c.Ready(ctx).Checks["expiration_reaper"] == CheckResult{Status: StatusFailing, Error: "stopped"}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestChecker_Drain(t *testing.T) {
+	ctx := context.Background()
+	c := NewChecker(time.Second)
+	c.Add("database", func(context.Context) error { return nil })
+
+	c.Drain()
+	report := c.Ready(ctx)
+	assert.Equal(t, StatusFailing, report.Status)
+	assert.Equal(t, CheckResult{Status: StatusOK}, report.Checks["database"])
+	assert.Equal(t, CheckResult{Status: StatusFailing, Error: "shutting down"}, report.Checks["shutdown"])
+}
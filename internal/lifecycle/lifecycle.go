@@ -0,0 +1,59 @@
+// Package lifecycle runs the background workers of the app and stops them in order on shutdown.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/iTcatt/segmenter/internal/health"
+)
+
+type worker struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Workers starts background workers, each with its own context, and reports them by the readiness checks.
+type Workers struct {
+	checker *health.Checker
+	log     *slog.Logger
+	workers []worker
+}
+
+func NewWorkers(checker *health.Checker, log *slog.Logger) *Workers {
+	return &Workers{checker: checker, log: log}
+}
+
+// Go runs the worker in a goroutine until Stop cancels its context.
+// The worker is reported under the name, its readiness check fails once it returns.
+func (w *Workers) Go(name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	w.workers = append(w.workers, worker{name: name, cancel: cancel, done: done})
+	w.checker.Go(name, func() {
+		defer close(done)
+		run(ctx)
+	})
+}
+
+// Stop cancels the workers one at a time in the reverse order they were started and waits for each to return,
+// so a worker outlives the workers started after it, such as a relay of the events other workers write.
+// If ctx is done first, the remaining workers are cancelled without waiting.
+func (w *Workers) Stop(ctx context.Context) error {
+	for i := len(w.workers) - 1; i >= 0; i-- {
+		worker := w.workers[i]
+		worker.cancel()
+		select {
+		case <-worker.done:
+			w.log.DebugContext(ctx, "worker stopped", "worker", worker.name)
+		case <-ctx.Done():
+			for _, rest := range w.workers[:i] {
+				rest.cancel()
+			}
+			return fmt.Errorf("stop %s: %w", worker.name, ctx.Err())
+		}
+	}
+	return nil
+}
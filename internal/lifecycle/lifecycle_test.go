@@ -0,0 +1,65 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/health"
+	"github.com/iTcatt/segmenter/internal/logger"
+)
+
+func TestWorkers_Stop(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	workers := NewWorkers(checker, logger.Discard())
+
+	var (
+		mu      sync.Mutex
+		stopped []string
+	)
+	for _, name := range []string{"event_stream", "outbox_relay", "expiration_reaper"} {
+		name := name
+		workers.Go(name, func(ctx context.Context) {
+			<-ctx.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = append(stopped, name)
+		})
+	}
+	assert.Equal(t, health.StatusOK, checker.Ready(context.Background()).Status)
+
+	require.NoError(t, workers.Stop(context.Background()))
+	assert.Equal(t, []string{"expiration_reaper", "outbox_relay", "event_stream"}, stopped)
+	assert.Eventually(t, func() bool {
+		return checker.Ready(context.Background()).Status == health.StatusFailing
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWorkers_StopTimeout(t *testing.T) {
+	workers := NewWorkers(health.NewChecker(time.Second), logger.Discard())
+	cancelled := make(chan struct{})
+	workers.Go("outbox_relay", func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	})
+	release := make(chan struct{})
+	defer close(release)
+	workers.Go("expiration_reaper", func(context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := workers.Stop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "expiration_reaper")
+
+	// The workers left are cancelled anyway.
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("outbox relay was not cancelled")
+	}
+}
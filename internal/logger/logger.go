@@ -0,0 +1,137 @@
+// Package logger creates the structured logger of the app. Records logged with a context carry
+// the request ID, the caller and the trace ID of the context.
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/iTcatt/segmenter/internal/config"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New creates a logger writing to w at the level and in the format set by cfg.
+func New(w io.Writer, cfg config.LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s'", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format '%s'", cfg.Format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Discard returns a logger that drops all records.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether an ID received from a client can be used as is: it is printable ASCII
+// of at most 128 characters, so it cannot forge log lines or bloat them.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type callerKey struct{}
+
+type trackedCallerKey struct{}
+
+// TrackCaller returns a copy of ctx that takes the caller set by WithCaller on contexts derived from it.
+// Middleware logging a request once it is served uses it to log the caller authenticated further down the chain.
+func TrackCaller(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trackedCallerKey{}, new(atomic.Pointer[string]))
+}
+
+// WithCaller returns a copy of ctx carrying the authenticated caller of the request.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	if tracked, ok := ctx.Value(trackedCallerKey{}).(*atomic.Pointer[string]); ok {
+		tracked.Store(&caller)
+	}
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// Caller returns the caller of ctx, an empty string if there is none.
+func Caller(ctx context.Context) string {
+	if caller, ok := ctx.Value(callerKey{}).(string); ok {
+		return caller
+	}
+	if tracked, ok := ctx.Value(trackedCallerKey{}).(*atomic.Pointer[string]); ok {
+		if caller := tracked.Load(); caller != nil {
+			return *caller
+		}
+	}
+	return ""
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if caller := Caller(ctx); caller != "" {
+		record.AddAttrs(slog.String("caller", caller))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(&buf, config.LogConfig{Level: "warn", Format: FormatJSON})
+	require.NoError(t, err)
+
+	ctx := WithCaller(WithRequestID(context.Background(), "0f3a9c2d"), "api_key:ci")
+	log.InfoContext(ctx, "user created", "user_id", 1000)
+	assert.Zero(t, buf.Len(), "records below the level are dropped")
+
+	log.With("op", "UpdateUser").WarnContext(ctx, "segment not created", "segment", "AVITO_VOICE_MESSAGES")
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "segment not created", record["msg"])
+	assert.Equal(t, "UpdateUser", record["op"])
+	assert.Equal(t, "0f3a9c2d", record["request_id"])
+	assert.Equal(t, "api_key:ci", record["caller"])
+}
+
+func TestTrackCaller(t *testing.T) {
+	ctx := TrackCaller(WithRequestID(context.Background(), "0f3a9c2d"))
+	assert.Empty(t, Caller(ctx))
+
+	authenticated := WithCaller(ctx, "api_key:ci")
+	assert.Equal(t, "api_key:ci", Caller(authenticated))
+	assert.Equal(t, "api_key:ci", Caller(ctx), "the caller is seen by the context it was tracked on")
+	assert.Empty(t, Caller(WithRequestID(context.Background(), "0f3a9c2d")))
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, config.LogConfig{Level: "verbose", Format: FormatText})
+	assert.Error(t, err)
+	_, err = New(&bytes.Buffer{}, config.LogConfig{Level: "info", Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, ValidRequestID("0f3a9c2d-4e1b"))
+	assert.True(t, ValidRequestID(NewRequestID()))
+	assert.False(t, ValidRequestID(""))
+	assert.False(t, ValidRequestID("0f3a9c2d\nlevel=ERROR msg=forged"))
+	assert.False(t, ValidRequestID(strings.Repeat("a", 129)))
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type Operation string
+
+const (
+	OperationAdd    Operation = "add"
+	OperationRemove Operation = "remove"
+	OperationExpire Operation = "expire"
+)
+
+// HistoryRecord is a single change of the user's membership in a segment.
+type HistoryRecord struct {
+	UserID    int
+	Segment   string
+	Operation Operation
+	Timestamp time.Time
+}
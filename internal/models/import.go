@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type ImportStatus string
+
+const (
+	ImportRunning ImportStatus = "running"
+	ImportDone    ImportStatus = "done"
+	ImportFailed  ImportStatus = "failed"
+)
+
+// ImportJob describes a background import of users and memberships from a CSV file.
+type ImportJob struct {
+	ID            int          `json:"id"`
+	Status        ImportStatus `json:"status"`
+	TotalRows     int          `json:"total_rows"`
+	ProcessedRows int          `json:"processed_rows"`
+	ImportedRows  int          `json:"imported_rows"`
+	RejectedRows  int          `json:"rejected_rows"`
+	CreatedUsers  int          `json:"created_users"`
+	Error         string       `json:"error,omitempty"`
+	StartedAt     time.Time    `json:"started_at"`
+	FinishedAt    *time.Time   `json:"finished_at,omitempty"`
+}
+
+// ImportRowError describes a rejected CSV row: Line is its line number, Record holds its fields.
+type ImportRowError struct {
+	Line   int
+	Record []string
+	Reason string
+}
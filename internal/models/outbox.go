@@ -0,0 +1,8 @@
+package models
+
+// OutboxEvent is an event written to the outbox in the transaction of the change it describes.
+// Seq orders events in the order they were written; it is also the event ID.
+type OutboxEvent struct {
+	Seq   int64
+	Event Event
+}
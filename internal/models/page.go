@@ -0,0 +1,28 @@
+package models
+
+// ListSegmentsParams selects a page of segments ordered by name.
+// After is the name of the last segment on the previous page.
+type ListSegmentsParams struct {
+	Prefix string
+	After  string
+	Limit  int
+}
+
+type SegmentsPage struct {
+	Segments []Segment
+	HasMore  bool
+}
+
+// SegmentUsersParams selects a page of segment members ordered by ID.
+// After is the ID of the last user on the previous page, nil for the first page.
+type SegmentUsersParams struct {
+	Segment string
+	After   *int
+	Limit   int
+}
+
+type SegmentUsersPage struct {
+	Users   []int
+	Total   int
+	HasMore bool
+}
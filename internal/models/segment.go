@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Segment describes a segment and its metadata.
+// AutoPercent is the share of users, from 0 to 100, automatically enrolled into the segment.
+type Segment struct {
+	Name        string    `json:"name"`
+	AutoPercent int       `json:"auto_percent"`
+	Description string    `json:"description"`
+	Owner       string    `json:"owner"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// UpdateSegmentParams changes metadata of the segment Name. Nil fields are left unchanged.
+type UpdateSegmentParams struct {
+	Name        string
+	Description *string
+	Owner       *string
+	Tags        []string
+}
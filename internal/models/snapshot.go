@@ -0,0 +1,23 @@
+package models
+
+// SnapshotVersion is the version of the snapshot format written by the export.
+const SnapshotVersion = 1
+
+// RestoreMode defines what happens to the data already in the storage when a snapshot is restored.
+type RestoreMode string
+
+const (
+	// RestoreReplace archives all segments and users before loading the snapshot.
+	RestoreReplace RestoreMode = "replace"
+	// RestoreMerge keeps the existing data: segments, users and memberships that already exist are left unchanged.
+	RestoreMerge RestoreMode = "merge"
+)
+
+// SnapshotStats counts records written by the export or loaded by the restore.
+type SnapshotStats struct {
+	Segments    int `json:"segments"`
+	Users       int `json:"users"`
+	Memberships int `json:"memberships"`
+	// SkippedMemberships are restored memberships that have expired or whose user is missing.
+	SkippedMemberships int `json:"skipped_memberships,omitempty"`
+}
@@ -1,12 +1,49 @@
 package models
 
+import "time"
+
 type User struct {
 	ID       int      `json:"id"`
 	Segments []string `json:"segments"`
+	// NextExpiry is when the first of Segments expires, nil if all of them are permanent.
+	// Caches keep the user no longer than that.
+	NextExpiry *time.Time `json:"-"`
+}
+
+// SegmentMembership describes a segment the user is added to.
+// A nil ExpiresAt means the membership is permanent.
+type SegmentMembership struct {
+	Segment   string
+	ExpiresAt *time.Time
+}
+
+// SegmentMember is a user in a segment. A nil ExpiresAt means the membership is permanent.
+type SegmentMember struct {
+	UserID    int
+	ExpiresAt *time.Time
 }
 
 type UpdateUserParams struct {
 	ID             int
-	AddSegments    []string
+	AddSegments    []SegmentMembership
 	DeleteSegments []string
 }
+
+// BulkMembershipResult is the outcome of adding many users to a segment or removing them from it.
+// Users neither changed nor missing were already in the segment or were not in it.
+type BulkMembershipResult struct {
+	// Changed are users that were added to or removed from the segment.
+	Changed []int
+	// Missing are users that do not exist.
+	Missing []int
+}
+
+// UsersSegments is the result of looking up many users at once.
+type UsersSegments struct {
+	// Segments are the active segments of every found user, ordered by name.
+	Segments map[int][]string
+	// Missing are users that do not exist.
+	Missing []int
+	// NextExpiry is when the first segment of a user expires, users whose segments are all permanent are not listed.
+	NextExpiry map[int]time.Time
+}
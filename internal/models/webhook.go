@@ -0,0 +1,113 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventUserCreated       EventType = "user.created"
+	EventUserDeleted       EventType = "user.deleted"
+	EventSegmentCreated    EventType = "segment.created"
+	EventSegmentDeleted    EventType = "segment.deleted"
+	EventMembershipAdded   EventType = "membership.added"
+	EventMembershipRemoved EventType = "membership.removed"
+	EventMembershipExpired EventType = "membership.expired"
+)
+
+// EventTypes are all event types in the order they are documented.
+var EventTypes = []EventType{
+	EventUserCreated,
+	EventUserDeleted,
+	EventSegmentCreated,
+	EventSegmentDeleted,
+	EventMembershipAdded,
+	EventMembershipRemoved,
+	EventMembershipExpired,
+}
+
+// Event is a change published to the event sinks. UserID and Segment are set for the events they apply to.
+type Event struct {
+	ID        string     `json:"id"`
+	Type      EventType  `json:"type"`
+	Timestamp time.Time  `json:"timestamp"`
+	UserID    *int       `json:"user_id,omitempty"`
+	Segment   string     `json:"segment,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Key is the ordering key of the event: events of a user are keyed by the user, other events by the segment.
+// Events with the same key are published in the order they were written.
+func (e Event) Key() string {
+	if e.UserID != nil {
+		return "user:" + strconv.Itoa(*e.UserID)
+	}
+	return "segment:" + e.Segment
+}
+
+// EventFilter selects events of a stream: events of the user if UserID is set
+// and events of any of the segments if Segments are given. The zero filter selects all events.
+type EventFilter struct {
+	UserID   *int
+	Segments []string
+}
+
+func (f EventFilter) Match(e Event) bool {
+	if f.UserID != nil && (e.UserID == nil || *e.UserID != *f.UserID) {
+		return false
+	}
+	if len(f.Segments) == 0 {
+		return true
+	}
+	for _, segment := range f.Segments {
+		if e.Segment == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is a subscription to events. An empty Events list subscribes to all events.
+// Secret signs the deliveries, it is only returned when the webhook is created.
+type Webhook struct {
+	ID        int         `json:"id"`
+	URL       string      `json:"url"`
+	Events    []EventType `json:"events"`
+	Secret    string      `json:"secret,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Subscribed reports whether the webhook receives events of the type.
+func (w Webhook) Subscribed(eventType EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range w.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is an event that could not be delivered to the webhook after all attempts.
+type DeadLetter struct {
+	ID        int64     `json:"id"`
+	WebhookID int       `json:"webhook_id"`
+	Event     Event     `json:"event"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// WebhookDelivery is an event queued for the webhook. A failed delivery is attempted again at NextAttemptAt.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int
+	Event         Event
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+}
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+const (
+	// importBatchSize is the number of rows added to a segment by one storage call.
+	importBatchSize = 1000
+	// maxImportJobs bounds the number of jobs kept in memory; the oldest finished jobs are forgotten first.
+	maxImportJobs = 100
+)
+
+// importRow is a valid CSV row of user_id,segment[,expires_at].
+type importRow struct {
+	line      int
+	record    []string
+	userID    int
+	segment   string
+	expiresAt *time.Time
+}
+
+type importJob struct {
+	mu        sync.Mutex
+	job       models.ImportJob
+	rowErrors []models.ImportRowError
+}
+
+// importJobs keeps import jobs of this process, they are lost on restart.
+type importJobs struct {
+	mu     sync.Mutex
+	nextID int
+	jobs   map[int]*importJob
+	order  []int
+}
+
+func newImportJobs() *importJobs {
+	return &importJobs{
+		nextID: 1,
+		jobs:   make(map[int]*importJob),
+	}
+}
+
+// StartImport reads a CSV of user_id,segment[,expires_at] rows and imports them in the background:
+// missing users are created and added to the segments. A header row is skipped.
+// Malformed rows are rejected at once; the returned job tracks the progress.
+// The import is cancelled if the service shuts down before it finishes.
+func (s *Service) StartImport(r io.Reader) (models.ImportJob, error) {
+	rows, rejected, err := parseImport(r, time.Now())
+	if err != nil {
+		s.log.Error("read import", "error", err)
+		return models.ImportJob{}, err
+	}
+
+	job := s.imports.add(len(rows) + len(rejected))
+	for _, rowError := range rejected {
+		job.reject(rowError.Line, rowError.Record, rowError.Reason)
+	}
+	err = s.tasks.run(func(ctx context.Context) { s.runImport(ctx, job, rows) })
+	if err != nil {
+		job.finish(err)
+		s.log.Error("start import", "error", err)
+		return models.ImportJob{}, err
+	}
+
+	snapshot := job.snapshot()
+	s.log.Info("import started", "import_id", snapshot.ID, "rows", snapshot.TotalRows)
+	return snapshot, nil
+}
+
+// GetImport returns the import job. It returns storage.ErrNotExist for unknown jobs.
+func (s *Service) GetImport(id int) (models.ImportJob, error) {
+	job, ok := s.imports.get(id)
+	if !ok {
+		return models.ImportJob{}, storage.ErrNotExist
+	}
+	return job.snapshot(), nil
+}
+
+// GetImportErrors returns rows rejected by the import job so far, ordered by line.
+func (s *Service) GetImportErrors(id int) ([]models.ImportRowError, error) {
+	job, ok := s.imports.get(id)
+	if !ok {
+		return nil, storage.ErrNotExist
+	}
+	return job.rejected(), nil
+}
+
+// WriteImportErrors writes rejected rows as CSV of line,user_id,segment,expires_at,reason.
+func WriteImportErrors(w io.Writer, rowErrors []models.ImportRowError) error {
+	writer := csv.NewWriter(w)
+	for _, rowError := range rowErrors {
+		fields := make([]string, 3)
+		copy(fields, rowError.Record)
+		record := append([]string{strconv.Itoa(rowError.Line)}, fields...)
+		if err := writer.Write(append(record, rowError.Reason)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (s *Service) runImport(ctx context.Context, job *importJob, rows []importRow) {
+	err := s.importRows(ctx, job, rows)
+	job.finish(err)
+
+	result := job.snapshot()
+	if err != nil {
+		s.log.ErrorContext(ctx, "import failed", "import_id", result.ID, "error", err)
+		return
+	}
+	s.log.InfoContext(ctx, "import finished",
+		"import_id", result.ID, "imported", result.ImportedRows, "rejected", result.RejectedRows)
+}
+
+func (s *Service) importRows(ctx context.Context, job *importJob, rows []importRow) error {
+	segments := make(map[string]bool)
+	for _, row := range rows {
+		if _, ok := segments[row.segment]; ok {
+			continue
+		}
+		_, err := s.repo.GetSegment(ctx, row.segment)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+		segments[row.segment] = err == nil
+	}
+	rows = job.rejectRows(rows, func(row importRow) string {
+		if !segments[row.segment] {
+			return "segment not exist"
+		}
+		return ""
+	})
+
+	userIDs := make([]int, 0, len(rows))
+	seen := make(map[int]struct{}, len(rows))
+	for _, row := range rows {
+		if _, ok := seen[row.userID]; !ok {
+			seen[row.userID] = struct{}{}
+			userIDs = append(userIDs, row.userID)
+		}
+	}
+	created, err := s.CreateUsers(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	job.createdUsers(created)
+	rows = job.rejectRows(rows, func(row importRow) string {
+		if created[row.userID] == "not created" {
+			return "user not created"
+		}
+		return ""
+	})
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].segment != rows[j].segment {
+			return rows[i].segment < rows[j].segment
+		}
+		return expiresBefore(rows[i].expiresAt, rows[j].expiresAt)
+	})
+	for len(rows) > 0 {
+		// Batches are not rolled back: an import cancelled on shutdown keeps the rows imported so far.
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		n := 1
+		for n < len(rows) && n < importBatchSize && sameMembership(rows[0], rows[n]) {
+			n++
+		}
+		if err = s.importBatch(ctx, job, rows[:n]); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// importBatch adds users of rows with the same segment and expiry.
+func (s *Service) importBatch(ctx context.Context, job *importJob, rows []importRow) error {
+	userIDs := make([]int, 0, len(rows))
+	for _, row := range rows {
+		userIDs = append(userIDs, row.userID)
+	}
+
+	result, err := s.repo.AddUsersToSegment(ctx, rows[0].segment, userIDs, rows[0].expiresAt)
+	if errors.Is(err, storage.ErrNotExist) {
+		// The segment was deleted after the import started.
+		job.rejectRows(rows, func(importRow) string { return "segment not exist" })
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	missing := make(map[int]struct{}, len(result.Missing))
+	for _, userID := range result.Missing {
+		missing[userID] = struct{}{}
+	}
+	rows = job.rejectRows(rows, func(row importRow) string {
+		if _, ok := missing[row.userID]; ok {
+			return "user not exist"
+		}
+		return ""
+	})
+	job.imported(len(rows))
+	return nil
+}
+
+// parseImport returns valid rows and rows rejected because they are malformed.
+// It fails only if r cannot be read.
+func parseImport(r io.Reader, now time.Time) ([]importRow, []models.ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows := make([]importRow, 0)
+	rejected := make([]models.ImportRowError, 0)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rejected = append(rejected, models.ImportRowError{Line: parseErr.Line, Reason: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if first && strings.TrimSpace(record[0]) == "user_id" {
+			continue
+		}
+		row, reason := parseImportRow(record, now)
+		if reason != "" {
+			rejected = append(rejected, models.ImportRowError{Line: line, Record: record, Reason: reason})
+			continue
+		}
+		row.line = line
+		rows = append(rows, row)
+	}
+	return rows, rejected, nil
+}
+
+// parseImportRow returns the row or the reason it is rejected.
+func parseImportRow(record []string, now time.Time) (importRow, string) {
+	row := importRow{record: record}
+	if len(record) < 2 || len(record) > 3 {
+		return row, "expected user_id,segment[,expires_at]"
+	}
+
+	userID, err := strconv.Atoi(strings.TrimSpace(record[0]))
+	if err != nil {
+		return row, "invalid user_id"
+	}
+	row.userID = userID
+
+	row.segment = strings.TrimSpace(record[1])
+	if row.segment == "" {
+		return row, "empty segment"
+	}
+
+	if len(record) == 3 && strings.TrimSpace(record[2]) != "" {
+		expiresAt, err := time.Parse(time.RFC3339, strings.TrimSpace(record[2]))
+		if err != nil {
+			return row, "invalid expires_at"
+		}
+		if !expiresAt.After(now) {
+			return row, "expires_at is in the past"
+		}
+		row.expiresAt = &expiresAt
+	}
+	return row, ""
+}
+
+func sameMembership(a, b importRow) bool {
+	return a.segment == b.segment && !expiresBefore(a.expiresAt, b.expiresAt) && !expiresBefore(b.expiresAt, a.expiresAt)
+}
+
+// expiresBefore orders expiry times with permanent memberships first.
+func expiresBefore(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	return a.Before(*b)
+}
+
+// add creates a running job of total rows and forgets the oldest finished job if there are too many.
+func (j *importJobs) add(total int) *importJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job := &importJob{
+		job: models.ImportJob{
+			ID:        j.nextID,
+			Status:    models.ImportRunning,
+			TotalRows: total,
+			StartedAt: time.Now(),
+		},
+		rowErrors: make([]models.ImportRowError, 0),
+	}
+	j.jobs[job.job.ID] = job
+	j.order = append(j.order, job.job.ID)
+	j.nextID++
+
+	if len(j.order) > maxImportJobs {
+		for i, id := range j.order {
+			if j.jobs[id].snapshot().Status != models.ImportRunning {
+				delete(j.jobs, id)
+				j.order = append(j.order[:i], j.order[i+1:]...)
+				break
+			}
+		}
+	}
+	return job
+}
+
+func (j *importJobs) get(id int) (*importJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	return job, ok
+}
+
+func (j *importJob) snapshot() models.ImportJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job := j.job
+	if job.FinishedAt != nil {
+		finishedAt := *job.FinishedAt
+		job.FinishedAt = &finishedAt
+	}
+	return job
+}
+
+func (j *importJob) rejected() []models.ImportRowError {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rowErrors := make([]models.ImportRowError, len(j.rowErrors))
+	copy(rowErrors, j.rowErrors)
+	sort.SliceStable(rowErrors, func(a, b int) bool {
+		return rowErrors[a].Line < rowErrors[b].Line
+	})
+	return rowErrors
+}
+
+func (j *importJob) reject(line int, record []string, reason string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.rowErrors = append(j.rowErrors, models.ImportRowError{Line: line, Record: record, Reason: reason})
+	j.job.RejectedRows++
+	j.job.ProcessedRows++
+}
+
+// rejectRows rejects rows for which reason returns a non-empty reason and returns the rest.
+func (j *importJob) rejectRows(rows []importRow, reason func(importRow) string) []importRow {
+	accepted := make([]importRow, 0, len(rows))
+	for _, row := range rows {
+		if r := reason(row); r != "" {
+			j.reject(row.line, row.record, r)
+			continue
+		}
+		accepted = append(accepted, row)
+	}
+	return accepted
+}
+
+func (j *importJob) createdUsers(reply map[int]string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, result := range reply {
+		if result == "created" {
+			j.job.CreatedUsers++
+		}
+	}
+}
+
+func (j *importJob) imported(rows int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.job.ImportedRows += rows
+	j.job.ProcessedRows += rows
+}
+
+func (j *importJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	finishedAt := time.Now()
+	j.job.FinishedAt = &finishedAt
+	j.job.Status = models.ImportDone
+	if err != nil {
+		j.job.Status = models.ImportFailed
+		j.job.Error = err.Error()
+	}
+}
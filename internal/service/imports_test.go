@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service/mocks"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+func TestParseImport(t *testing.T) {
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	input := strings.Join([]string{
+		"user_id,segment,expires_at",
+		"1,AVITO_VOICE_MESSAGES",
+		"2, AVITO_DISCOUNT_30 ,2023-10-01T00:00:00Z",
+		"x,AVITO_VOICE_MESSAGES",
+		"3,",
+		"4,AVITO_VOICE_MESSAGES,2023-08-01T00:00:00Z",
+		"5,AVITO_VOICE_MESSAGES,tomorrow",
+		"6",
+		`7,"AVITO`,
+	}, "\n")
+
+	rows, rejected, err := parseImport(strings.NewReader(input), now)
+	require.NoError(t, err)
+
+	expiresAt := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	require.Len(t, rows, 2)
+	assert.Equal(t, importRow{line: 2, record: []string{"1", "AVITO_VOICE_MESSAGES"}, userID: 1,
+		segment: "AVITO_VOICE_MESSAGES"}, rows[0])
+	assert.Equal(t, 3, rows[1].line)
+	assert.Equal(t, "AVITO_DISCOUNT_30", rows[1].segment)
+	assert.Equal(t, &expiresAt, rows[1].expiresAt)
+
+	reasons := make(map[int]string)
+	for _, rowError := range rejected {
+		reasons[rowError.Line] = rowError.Reason
+	}
+	assert.Equal(t, map[int]string{
+		4: "invalid user_id",
+		5: "empty segment",
+		6: "expires_at is in the past",
+		7: "invalid expires_at",
+		8: "expected user_id,segment[,expires_at]",
+		9: `extraneous or missing " in quoted-field`,
+	}, reasons)
+}
+
+func TestService_StartImport(t *testing.T) {
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("GetSegment", mock.Anything, "a").
+		Return(models.Segment{Name: "a"}, nil).
+		Once()
+	mockStorage.
+		On("GetSegment", mock.Anything, "b").
+		Return(models.Segment{}, storage.ErrNotExist).
+		Once()
+	mockStorage.
+		On("GetAutoSegments", mock.Anything).
+		Return([]models.Segment{}, nil).
+		Once()
+	mockStorage.
+		On("CreateUser", mock.Anything, 1).
+		Return(nil).
+		Once()
+	mockStorage.
+		On("CreateUser", mock.Anything, 2).
+		Return(storage.ErrAlreadyExist).
+		Once()
+	mockStorage.
+		On("AddUsersToSegment", mock.Anything, "a", []int{1, 2}, (*time.Time)(nil)).
+		Return(models.BulkMembershipResult{Changed: []int{1}, Missing: []int{}}, nil).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	job, err := service.StartImport(strings.NewReader("1,a\n2,a\n3,b\nx,a\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, job.TotalRows)
+
+	require.Eventually(t, func() bool {
+		job, err = service.GetImport(job.ID)
+		return err == nil && job.Status != models.ImportRunning
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, models.ImportDone, job.Status)
+	assert.Equal(t, 4, job.ProcessedRows)
+	assert.Equal(t, 2, job.ImportedRows)
+	assert.Equal(t, 2, job.RejectedRows)
+	assert.Equal(t, 1, job.CreatedUsers)
+
+	rowErrors, err := service.GetImportErrors(job.ID)
+	require.NoError(t, err)
+	var report bytes.Buffer
+	require.NoError(t, WriteImportErrors(&report, rowErrors))
+	assert.Equal(t, "3,3,b,,segment not exist\n4,x,a,,invalid user_id\n", report.String())
+
+	_, err = service.GetImport(job.ID + 1)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func TestService_RunTasks_CancelsImport(t *testing.T) {
+	started := make(chan struct{})
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("GetSegment", mock.Anything, "a").
+		Return(models.Segment{}, context.Canceled).
+		Run(func(args mock.Arguments) {
+			close(started)
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	job, err := service.StartImport(strings.NewReader("1,a\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	service.RunTasks(ctx)
+
+	// RunTasks returns only once the import is finished.
+	job, err = service.GetImport(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ImportFailed, job.Status)
+	assert.Equal(t, context.Canceled.Error(), job.Error)
+
+	_, err = service.StartImport(strings.NewReader("2,a\n"))
+	assert.ErrorIs(t, err, errShuttingDown)
+}
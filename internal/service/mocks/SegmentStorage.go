@@ -7,6 +7,8 @@ import (
 
 	models "github.com/iTcatt/segmenter/internal/models"
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // SegmentStorage is an autogenerated mock type for the SegmentStorage type
@@ -14,17 +16,35 @@ type SegmentStorage struct {
 	mock.Mock
 }
 
-// AddUserToSegment provides a mock function with given fields: ctx, userID, segment
-func (_m *SegmentStorage) AddUserToSegment(ctx context.Context, userID int, segment string) error {
-	ret := _m.Called(ctx, userID, segment)
+// AddDeadLetter provides a mock function with given fields: ctx, letter
+func (_m *SegmentStorage) AddDeadLetter(ctx context.Context, letter models.DeadLetter) error {
+	ret := _m.Called(ctx, letter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AddDeadLetter")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.DeadLetter) error); ok {
+		r0 = rf(ctx, letter)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddUserToSegment provides a mock function with given fields: ctx, userID, segment, expiresAt
+func (_m *SegmentStorage) AddUserToSegment(ctx context.Context, userID int, segment string, expiresAt *time.Time) error {
+	ret := _m.Called(ctx, userID, segment, expiresAt)
 
 	if len(ret) == 0 {
 		panic("no return value specified for AddUserToSegment")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int, string) error); ok {
-		r0 = rf(ctx, userID, segment)
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, *time.Time) error); ok {
+		r0 = rf(ctx, userID, segment, expiresAt)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -32,17 +52,151 @@ func (_m *SegmentStorage) AddUserToSegment(ctx context.Context, userID int, segm
 	return r0
 }
 
-// CreateSegment provides a mock function with given fields: ctx, name
-func (_m *SegmentStorage) CreateSegment(ctx context.Context, name string) error {
-	ret := _m.Called(ctx, name)
+// AddUsersToSegment provides a mock function with given fields: ctx, segment, userIDs, expiresAt
+func (_m *SegmentStorage) AddUsersToSegment(ctx context.Context, segment string, userIDs []int, expiresAt *time.Time) (models.BulkMembershipResult, error) {
+	ret := _m.Called(ctx, segment, userIDs, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AddUsersToSegment")
+	}
+
+	var r0 models.BulkMembershipResult
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int, *time.Time) (models.BulkMembershipResult, error)); ok {
+		return rf(ctx, segment, userIDs, expiresAt)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int, *time.Time) models.BulkMembershipResult); ok {
+		r0 = rf(ctx, segment, userIDs, expiresAt)
+	} else {
+		r0 = ret.Get(0).(models.BulkMembershipResult)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []int, *time.Time) error); ok {
+		r1 = rf(ctx, segment, userIDs, expiresAt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddWebhookDelivery provides a mock function with given fields: ctx, delivery
+func (_m *SegmentStorage) AddWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	ret := _m.Called(ctx, delivery)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AddWebhookDelivery")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.WebhookDelivery) error); ok {
+		r0 = rf(ctx, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ClaimWebhookDeliveries provides a mock function with given fields: ctx, now, lockedUntil, limit
+func (_m *SegmentStorage) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	ret := _m.Called(ctx, now, lockedUntil, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ClaimWebhookDeliveries")
+	}
+
+	var r0 []models.WebhookDelivery
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time, int) ([]models.WebhookDelivery, error)); ok {
+		return rf(ctx, now, lockedUntil, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time, int) []models.WebhookDelivery); ok {
+		r0 = rf(ctx, now, lockedUntil, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.WebhookDelivery)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time, int) error); ok {
+		r1 = rf(ctx, now, lockedUntil, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountMembers provides a mock function with given fields: ctx
+func (_m *SegmentStorage) CountMembers(ctx context.Context) (map[string]int, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountMembers")
+	}
+
+	var r0 map[string]int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (map[string]int, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) map[string]int); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountSegmentUsers provides a mock function with given fields: ctx, segment
+func (_m *SegmentStorage) CountSegmentUsers(ctx context.Context, segment string) (int, error) {
+	ret := _m.Called(ctx, segment)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountSegmentUsers")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (int, error)); ok {
+		return rf(ctx, segment)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) int); ok {
+		r0 = rf(ctx, segment)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, segment)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateSegment provides a mock function with given fields: ctx, segment
+func (_m *SegmentStorage) CreateSegment(ctx context.Context, segment models.Segment) error {
+	ret := _m.Called(ctx, segment)
 
 	if len(ret) == 0 {
 		panic("no return value specified for CreateSegment")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, name)
+	if rf, ok := ret.Get(0).(func(context.Context, models.Segment) error); ok {
+		r0 = rf(ctx, segment)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -68,6 +222,100 @@ func (_m *SegmentStorage) CreateUser(ctx context.Context, id int) error {
 	return r0
 }
 
+// CreateWebhook provides a mock function with given fields: ctx, webhook
+func (_m *SegmentStorage) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	ret := _m.Called(ctx, webhook)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateWebhook")
+	}
+
+	var r0 models.Webhook
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Webhook) (models.Webhook, error)); ok {
+		return rf(ctx, webhook)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.Webhook) models.Webhook); ok {
+		r0 = rf(ctx, webhook)
+	} else {
+		r0 = ret.Get(0).(models.Webhook)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.Webhook) error); ok {
+		r1 = rf(ctx, webhook)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteDeadLetter provides a mock function with given fields: ctx, id
+func (_m *SegmentStorage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteDeadLetter")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteExpiredSegments provides a mock function with given fields: ctx
+func (_m *SegmentStorage) DeleteExpiredSegments(ctx context.Context) ([]models.HistoryRecord, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteExpiredSegments")
+	}
+
+	var r0 []models.HistoryRecord
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]models.HistoryRecord, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []models.HistoryRecord); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.HistoryRecord)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteOutbox provides a mock function with given fields: ctx, seqs
+func (_m *SegmentStorage) DeleteOutbox(ctx context.Context, seqs []int64) error {
+	ret := _m.Called(ctx, seqs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteOutbox")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []int64) error); ok {
+		r0 = rf(ctx, seqs)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteSegment provides a mock function with given fields: ctx, name
 func (_m *SegmentStorage) DeleteSegment(ctx context.Context, name string) error {
 	ret := _m.Called(ctx, name)
@@ -122,27 +370,93 @@ func (_m *SegmentStorage) DeleteUserFromSegment(ctx context.Context, userID int,
 	return r0
 }
 
-// GetUser provides a mock function with given fields: ctx, id
-func (_m *SegmentStorage) GetUser(ctx context.Context, id int) (models.User, error) {
-	ret := _m.Called(ctx, id)
+// DeleteUsersFromSegment provides a mock function with given fields: ctx, segment, userIDs
+func (_m *SegmentStorage) DeleteUsersFromSegment(ctx context.Context, segment string, userIDs []int) (models.BulkMembershipResult, error) {
+	ret := _m.Called(ctx, segment, userIDs)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetUser")
+		panic("no return value specified for DeleteUsersFromSegment")
 	}
 
-	var r0 models.User
+	var r0 models.BulkMembershipResult
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int) (models.User, error)); ok {
-		return rf(ctx, id)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int) (models.BulkMembershipResult, error)); ok {
+		return rf(ctx, segment, userIDs)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int) models.User); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int) models.BulkMembershipResult); ok {
+		r0 = rf(ctx, segment, userIDs)
+	} else {
+		r0 = ret.Get(0).(models.BulkMembershipResult)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []int) error); ok {
+		r1 = rf(ctx, segment, userIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, id
+func (_m *SegmentStorage) DeleteWebhook(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteWebhook")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
 		r0 = rf(ctx, id)
 	} else {
-		r0 = ret.Get(0).(models.User)
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhookDelivery provides a mock function with given fields: ctx, id
+func (_m *SegmentStorage) DeleteWebhookDelivery(ctx context.Context, id int64) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteWebhookDelivery")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FetchOutbox provides a mock function with given fields: ctx, limit
+func (_m *SegmentStorage) FetchOutbox(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	ret := _m.Called(ctx, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for FetchOutbox")
+	}
+
+	var r0 []models.OutboxEvent
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]models.OutboxEvent, error)); ok {
+		return rf(ctx, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []models.OutboxEvent); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.OutboxEvent)
+		}
 	}
 
 	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
-		r1 = rf(ctx, id)
+		r1 = rf(ctx, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -150,27 +464,87 @@ func (_m *SegmentStorage) GetUser(ctx context.Context, id int) (models.User, err
 	return r0, r1
 }
 
-// IsUserCreated provides a mock function with given fields: ctx, userID
-func (_m *SegmentStorage) IsUserCreated(ctx context.Context, userID int) (bool, error) {
-	ret := _m.Called(ctx, userID)
+// GetAutoSegments provides a mock function with given fields: ctx
+func (_m *SegmentStorage) GetAutoSegments(ctx context.Context) ([]models.Segment, error) {
+	ret := _m.Called(ctx)
 
 	if len(ret) == 0 {
-		panic("no return value specified for IsUserCreated")
+		panic("no return value specified for GetAutoSegments")
 	}
 
-	var r0 bool
+	var r0 []models.Segment
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int) (bool, error)); ok {
-		return rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context) ([]models.Segment, error)); ok {
+		return rf(ctx)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int) bool); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context) []models.Segment); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Get(0).(bool)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Segment)
+		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
-		r1 = rf(ctx, userID)
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetHistory provides a mock function with given fields: ctx, from, to
+func (_m *SegmentStorage) GetHistory(ctx context.Context, from time.Time, to time.Time) ([]models.HistoryRecord, error) {
+	ret := _m.Called(ctx, from, to)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetHistory")
+	}
+
+	var r0 []models.HistoryRecord
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time) ([]models.HistoryRecord, error)); ok {
+		return rf(ctx, from, to)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time) []models.HistoryRecord); ok {
+		r0 = rf(ctx, from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.HistoryRecord)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time) error); ok {
+		r1 = rf(ctx, from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSegment provides a mock function with given fields: ctx, name
+func (_m *SegmentStorage) GetSegment(ctx context.Context, name string) (models.Segment, error) {
+	ret := _m.Called(ctx, name)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSegment")
+	}
+
+	var r0 models.Segment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (models.Segment, error)); ok {
+		return rf(ctx, name)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) models.Segment); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Get(0).(models.Segment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -178,6 +552,529 @@ func (_m *SegmentStorage) IsUserCreated(ctx context.Context, userID int) (bool,
 	return r0, r1
 }
 
+// GetSegmentMembers provides a mock function with given fields: ctx, segment, after, limit
+func (_m *SegmentStorage) GetSegmentMembers(ctx context.Context, segment string, after *int, limit int) ([]models.SegmentMember, error) {
+	ret := _m.Called(ctx, segment, after, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSegmentMembers")
+	}
+
+	var r0 []models.SegmentMember
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *int, int) ([]models.SegmentMember, error)); ok {
+		return rf(ctx, segment, after, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, *int, int) []models.SegmentMember); ok {
+		r0 = rf(ctx, segment, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.SegmentMember)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, *int, int) error); ok {
+		r1 = rf(ctx, segment, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSegmentUsers provides a mock function with given fields: ctx, segment, after, limit
+func (_m *SegmentStorage) GetSegmentUsers(ctx context.Context, segment string, after *int, limit int) ([]int, error) {
+	ret := _m.Called(ctx, segment, after, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSegmentUsers")
+	}
+
+	var r0 []int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *int, int) ([]int, error)); ok {
+		return rf(ctx, segment, after, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, *int, int) []int); ok {
+		r0 = rf(ctx, segment, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, *int, int) error); ok {
+		r1 = rf(ctx, segment, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUser provides a mock function with given fields: ctx, id
+func (_m *SegmentStorage) GetUser(ctx context.Context, id int) (models.User, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUser")
+	}
+
+	var r0 models.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (models.User, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) models.User); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Get(0).(models.User)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserIDs provides a mock function with given fields: ctx
+func (_m *SegmentStorage) GetUserIDs(ctx context.Context) ([]int, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUserIDs")
+	}
+
+	var r0 []int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]int, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []int); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUsersSegments provides a mock function with given fields: ctx, userIDs
+func (_m *SegmentStorage) GetUsersSegments(ctx context.Context, userIDs []int) (models.UsersSegments, error) {
+	ret := _m.Called(ctx, userIDs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUsersSegments")
+	}
+
+	var r0 models.UsersSegments
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []int) (models.UsersSegments, error)); ok {
+		return rf(ctx, userIDs)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []int) models.UsersSegments); ok {
+		r0 = rf(ctx, userIDs)
+	} else {
+		r0 = ret.Get(0).(models.UsersSegments)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, userIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InTx provides a mock function with given fields: ctx, fn
+func (_m *SegmentStorage) InTx(ctx context.Context, fn func(context.Context) error) error {
+	ret := _m.Called(ctx, fn)
+
+	if len(ret) == 0 {
+		panic("no return value specified for InTx")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(context.Context) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IsUserCreated provides a mock function with given fields: ctx, userID
+func (_m *SegmentStorage) IsUserCreated(ctx context.Context, userID int) (bool, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for IsUserCreated")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (bool, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) bool); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListDeadLetters provides a mock function with given fields: ctx, webhookID
+func (_m *SegmentStorage) ListDeadLetters(ctx context.Context, webhookID int) ([]models.DeadLetter, error) {
+	ret := _m.Called(ctx, webhookID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListDeadLetters")
+	}
+
+	var r0 []models.DeadLetter
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]models.DeadLetter, error)); ok {
+		return rf(ctx, webhookID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []models.DeadLetter); ok {
+		r0 = rf(ctx, webhookID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.DeadLetter)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, webhookID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListSegments provides a mock function with given fields: ctx, prefix, after, limit
+func (_m *SegmentStorage) ListSegments(ctx context.Context, prefix string, after string, limit int) ([]models.Segment, error) {
+	ret := _m.Called(ctx, prefix, after, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListSegments")
+	}
+
+	var r0 []models.Segment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int) ([]models.Segment, error)); ok {
+		return rf(ctx, prefix, after, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int) []models.Segment); ok {
+		r0 = rf(ctx, prefix, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Segment)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, int) error); ok {
+		r1 = rf(ctx, prefix, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListWebhooks provides a mock function with given fields: ctx
+func (_m *SegmentStorage) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListWebhooks")
+	}
+
+	var r0 []models.Webhook
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]models.Webhook, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []models.Webhook); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Webhook)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListenEvents provides a mock function with given fields: ctx, handle
+func (_m *SegmentStorage) ListenEvents(ctx context.Context, handle func(models.Event)) error {
+	ret := _m.Called(ctx, handle)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListenEvents")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(models.Event)) error); ok {
+		r0 = rf(ctx, handle)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LockOutbox provides a mock function with given fields: ctx
+func (_m *SegmentStorage) LockOutbox(ctx context.Context) (func(), bool, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LockOutbox")
+	}
+
+	var r0 func()
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context) (func(), bool, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) func()); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(func())
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) bool); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context) error); ok {
+		r2 = rf(ctx)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// MergeSegments provides a mock function with given fields: ctx, target, sources
+func (_m *SegmentStorage) MergeSegments(ctx context.Context, target string, sources []string) ([]int, error) {
+	ret := _m.Called(ctx, target, sources)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MergeSegments")
+	}
+
+	var r0 []int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) ([]int, error)); ok {
+		return rf(ctx, target, sources)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) []int); ok {
+		r0 = rf(ctx, target, sources)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(ctx, target, sources)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NotifyEvents provides a mock function with given fields: ctx, events
+func (_m *SegmentStorage) NotifyEvents(ctx context.Context, events []models.Event) error {
+	ret := _m.Called(ctx, events)
+
+	if len(ret) == 0 {
+		panic("no return value specified for NotifyEvents")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []models.Event) error); ok {
+		r0 = rf(ctx, events)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PurgeArchived provides a mock function with given fields: ctx, before
+func (_m *SegmentStorage) PurgeArchived(ctx context.Context, before time.Time) (int64, error) {
+	ret := _m.Called(ctx, before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for PurgeArchived")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int64, error)); ok {
+		return rf(ctx, before)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RenameSegment provides a mock function with given fields: ctx, name, newName
+func (_m *SegmentStorage) RenameSegment(ctx context.Context, name string, newName string) (models.Segment, error) {
+	ret := _m.Called(ctx, name, newName)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RenameSegment")
+	}
+
+	var r0 models.Segment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (models.Segment, error)); ok {
+		return rf(ctx, name, newName)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) models.Segment); ok {
+		r0 = rf(ctx, name, newName)
+	} else {
+		r0 = ret.Get(0).(models.Segment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, name, newName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreSegment provides a mock function with given fields: ctx, name
+func (_m *SegmentStorage) RestoreSegment(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RestoreSegment")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreUser provides a mock function with given fields: ctx, id
+func (_m *SegmentStorage) RestoreUser(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RestoreUser")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateSegment provides a mock function with given fields: ctx, params
+func (_m *SegmentStorage) UpdateSegment(ctx context.Context, params models.UpdateSegmentParams) (models.Segment, error) {
+	ret := _m.Called(ctx, params)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateSegment")
+	}
+
+	var r0 models.Segment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.UpdateSegmentParams) (models.Segment, error)); ok {
+		return rf(ctx, params)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.UpdateSegmentParams) models.Segment); ok {
+		r0 = rf(ctx, params)
+	} else {
+		r0 = ret.Get(0).(models.Segment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.UpdateSegmentParams) error); ok {
+		r1 = rf(ctx, params)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateWebhookDelivery provides a mock function with given fields: ctx, delivery
+func (_m *SegmentStorage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	ret := _m.Called(ctx, delivery)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateWebhookDelivery")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.WebhookDelivery) error); ok {
+		r0 = rf(ctx, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewSegmentStorage creates a new instance of SegmentStorage. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewSegmentStorage(t interface {
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// EventSink publishes events outside the service.
+type EventSink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Publish delivers events that share an ordering key in their order.
+	// Events are published again if it fails, so a sink may see an event more than once.
+	Publish(ctx context.Context, events []models.Event) error
+}
+
+// RelayOptions configures the relay of the outbox to the sinks.
+type RelayOptions struct {
+	// PollInterval is how often the outbox is read while it has no more events than BatchSize.
+	PollInterval time.Duration
+	// BatchSize bounds the number of events read from the outbox at once.
+	BatchSize int
+	// Workers is the number of ordering keys published concurrently.
+	Workers int
+	// InitialBackoff is the delay after a failed round, it doubles with every failed round up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// RunOutboxRelay publishes events of the outbox to every sink until ctx is done.
+// An event is deleted from the outbox once all sinks published it, so events are delivered at least once.
+// Events of one key, such as all events of a user, are published in the order they were written:
+// a key whose events failed is retried from its first unpublished event, other keys go on.
+// Only one relay at a time reads the outbox, other app instances wait for their turn.
+func (s *Service) RunOutboxRelay(ctx context.Context, opts RelayOptions, sinks ...EventSink) {
+	failures := 0
+	for {
+		delay := opts.PollInterval
+		relayed, err := s.relayOutbox(ctx, opts, sinks)
+		switch {
+		case err != nil:
+			failures++
+			delay = backoff(opts.InitialBackoff, opts.MaxBackoff, failures)
+			s.log.ErrorContext(ctx, "relay outbox", "error", err)
+		case relayed == opts.BatchSize:
+			// More events are likely waiting.
+			failures = 0
+			delay = 0
+		default:
+			failures = 0
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// relayOutbox publishes a batch of the outbox and deletes the published events.
+// It returns the number of published events.
+func (s *Service) relayOutbox(ctx context.Context, opts RelayOptions, sinks []EventSink) (int, error) {
+	unlock, locked, err := s.repo.LockOutbox(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("lock outbox: %w", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+	defer unlock()
+
+	events, err := s.repo.FetchOutbox(ctx, opts.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("fetch outbox: %w", err)
+	}
+	groups := groupByKey(events)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		published = make([]int64, 0, len(events))
+		errs      []error
+		workers   = make(chan struct{}, max(opts.Workers, 1))
+	)
+	for _, group := range groups {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(group []models.OutboxEvent) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			err := publish(ctx, sinks, group)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("key '%s': %w", group[0].Event.Key(), err))
+				return
+			}
+			for _, event := range group {
+				published = append(published, event.Seq)
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	if len(published) > 0 {
+		// Published events are deleted on shutdown too, so they are not published again.
+		if err = s.repo.DeleteOutbox(context.WithoutCancel(ctx), published); err != nil {
+			return 0, fmt.Errorf("delete published events: %w", err)
+		}
+	}
+	return len(published), errors.Join(errs...)
+}
+
+// publish hands the events of one key to every sink.
+func publish(ctx context.Context, sinks []EventSink, group []models.OutboxEvent) error {
+	events := make([]models.Event, 0, len(group))
+	for _, event := range group {
+		events = append(events, event.Event)
+	}
+	for _, sink := range sinks {
+		if err := sink.Publish(ctx, events); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
+
+// groupByKey splits the events by their key keeping the order of events within a key.
+func groupByKey(events []models.OutboxEvent) [][]models.OutboxEvent {
+	index := make(map[string]int)
+	groups := make([][]models.OutboxEvent, 0)
+	for _, event := range events {
+		key := event.Event.Key()
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], event)
+	}
+	return groups
+}
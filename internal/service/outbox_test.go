@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service/mocks"
+)
+
+// recordingSink records published events and fails for the keys in fail.
+type recordingSink struct {
+	mu        sync.Mutex
+	fail      map[string]bool
+	published map[string][]string
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Publish(_ context.Context, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := events[0].Key()
+	if s.fail[key] {
+		return errors.New("unavailable")
+	}
+	for _, event := range events {
+		s.published[key] = append(s.published[key], event.ID)
+	}
+	return nil
+}
+
+func outboxEvent(seq int64, event models.Event) models.OutboxEvent {
+	event.ID = strconv.FormatInt(seq, 10)
+	return models.OutboxEvent{Seq: seq, Event: event}
+}
+
+func TestService_relayOutbox(t *testing.T) {
+	first, second := 1, 2
+	events := []models.OutboxEvent{
+		outboxEvent(1, models.Event{Type: models.EventUserCreated, UserID: &first}),
+		outboxEvent(2, models.Event{Type: models.EventSegmentCreated, Segment: "a"}),
+		outboxEvent(3, models.Event{Type: models.EventUserCreated, UserID: &second}),
+		outboxEvent(4, models.Event{Type: models.EventMembershipAdded, UserID: &first, Segment: "a"}),
+		outboxEvent(5, models.Event{Type: models.EventMembershipAdded, UserID: &second, Segment: "a"}),
+	}
+
+	unlocked := false
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("LockOutbox", mock.Anything).
+		Return(func() { unlocked = true }, true, nil).
+		Once()
+	mockStorage.
+		On("FetchOutbox", mock.Anything, 10).
+		Return(events, nil).
+		Once()
+	mockStorage.
+		On("DeleteOutbox", mock.Anything, mock.MatchedBy(func(seqs []int64) bool {
+			sorted := slices.Clone(seqs)
+			slices.Sort(sorted)
+			return slices.Equal([]int64{1, 2, 4}, sorted)
+		})).
+		Return(nil).
+		Once()
+
+	sink := &recordingSink{fail: map[string]bool{"user:2": true}, published: make(map[string][]string)}
+	service := NewService(mockStorage, logger.Discard())
+	relayed, err := service.relayOutbox(context.Background(), RelayOptions{BatchSize: 10, Workers: 2}, []EventSink{sink})
+	assert.ErrorContains(t, err, "key 'user:2': recording: unavailable")
+	assert.Equal(t, 3, relayed)
+	assert.True(t, unlocked)
+	assert.Equal(t, map[string][]string{"user:1": {"1", "4"}, "segment:a": {"2"}}, sink.published)
+}
+
+func TestService_relayOutbox_Locked(t *testing.T) {
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("LockOutbox", mock.Anything).
+		Return(nil, false, nil).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	relayed, err := service.relayOutbox(context.Background(), RelayOptions{BatchSize: 10}, nil)
+	require.NoError(t, err)
+	assert.Zero(t, relayed)
+}
+
+func TestService_RunOutboxRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	userID := 1
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("LockOutbox", mock.Anything).
+		Return(func() {}, true, nil)
+	mockStorage.
+		On("FetchOutbox", mock.Anything, 1).
+		Return([]models.OutboxEvent{
+			outboxEvent(1, models.Event{Type: models.EventUserCreated, UserID: &userID}),
+		}, nil).
+		Once()
+	mockStorage.
+		On("DeleteOutbox", mock.Anything, []int64{1}).
+		Return(nil).
+		Once()
+	// A full batch is followed by another read at once.
+	mockStorage.
+		On("FetchOutbox", mock.Anything, 1).
+		Return([]models.OutboxEvent{}, nil).
+		Run(func(mock.Arguments) { cancel() }).
+		Once()
+
+	sink := &recordingSink{published: make(map[string][]string)}
+	service := NewService(mockStorage, logger.Discard())
+	done := make(chan struct{})
+	go func() {
+		service.RunOutboxRelay(ctx, RelayOptions{PollInterval: time.Hour, BatchSize: 1, Workers: 1}, sink)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay did not stop")
+	}
+	assert.Equal(t, map[string][]string{"user:1": {"1"}}, sink.published)
+}
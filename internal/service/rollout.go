@@ -0,0 +1,23 @@
+package service
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// inRollout deterministically decides whether the user falls into the percent share of the segment.
+// The decision depends only on the user ID and the segment name, so it is stable between calls.
+func inRollout(userID int, segment string, percent int) bool {
+	if percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strconv.Itoa(userID)))
+	_, _ = h.Write([]byte{':'})
+	_, _ = h.Write([]byte(segment))
+	return h.Sum32()%100 < uint32(percent)
+}
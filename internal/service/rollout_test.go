@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInRollout(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent int
+		min     int
+		max     int
+	}{
+		{name: "zero percent", percent: 0, min: 0, max: 0},
+		{name: "full rollout", percent: 100, min: 10000, max: 10000},
+		{name: "ten percent", percent: 10, min: 900, max: 1100},
+		{name: "half", percent: 50, min: 4800, max: 5200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count := 0
+			for userID := 1; userID <= 10000; userID++ {
+				if inRollout(userID, "AVITO_EXPERIMENT", test.percent) {
+					count++
+				}
+			}
+			assert.GreaterOrEqual(t, count, test.min)
+			assert.LessOrEqual(t, count, test.max)
+		})
+	}
+}
+
+func TestInRollout_Pinned(t *testing.T) {
+	// Changing the bucketing moves users between segments, the pinned decisions catch it.
+	for userID, expected := range map[int]bool{1: true, 2: true, 3: true, 4: false, 5: true, 8: false} {
+		assert.Equal(t, expected, inRollout(userID, "half", 50), "user %d", userID)
+	}
+}
+
+func TestInRollout_Stable(t *testing.T) {
+	for userID := 1; userID <= 1000; userID++ {
+		first := inRollout(userID, "AVITO_EXPERIMENT", 30)
+		assert.Equal(t, first, inRollout(userID, "AVITO_EXPERIMENT", 30))
+		if first {
+			assert.True(t, inRollout(userID, "AVITO_EXPERIMENT", 60), "raising the percent must keep enrolled users")
+		}
+	}
+}
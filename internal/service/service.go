@@ -3,56 +3,175 @@ package service
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
 
 	"github.com/iTcatt/segmenter/internal/models"
 	"github.com/iTcatt/segmenter/internal/storage"
 )
 
+var tracer = otel.Tracer("github.com/iTcatt/segmenter/internal/service")
+
 //go:generate mockery --name SegmentStorage
 type SegmentStorage interface {
-	CreateSegment(ctx context.Context, name string) error
+	// InTx runs fn in a transaction: storage calls made with the ctx passed to fn
+	// are committed together if fn returns nil and rolled back otherwise.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+
+	CreateSegment(ctx context.Context, segment models.Segment) error
+	GetSegment(ctx context.Context, name string) (models.Segment, error)
+	UpdateSegment(ctx context.Context, params models.UpdateSegmentParams) (models.Segment, error)
+	RenameSegment(ctx context.Context, name, newName string) (models.Segment, error)
+	MergeSegments(ctx context.Context, target string, sources []string) ([]int, error)
 	CreateUser(ctx context.Context, id int) error
-	AddUserToSegment(ctx context.Context, userID int, segment string) error
+	AddUserToSegment(ctx context.Context, userID int, segment string, expiresAt *time.Time) error
+	AddUsersToSegment(
+		ctx context.Context, segment string, userIDs []int, expiresAt *time.Time,
+	) (models.BulkMembershipResult, error)
+	DeleteUsersFromSegment(ctx context.Context, segment string, userIDs []int) (models.BulkMembershipResult, error)
 
 	IsUserCreated(ctx context.Context, userID int) (bool, error)
 	GetUser(ctx context.Context, id int) (models.User, error)
+	GetUsersSegments(ctx context.Context, userIDs []int) (models.UsersSegments, error)
+	GetUserIDs(ctx context.Context) ([]int, error)
+	GetAutoSegments(ctx context.Context) ([]models.Segment, error)
+
+	ListSegments(ctx context.Context, prefix, after string, limit int) ([]models.Segment, error)
+	GetSegmentUsers(ctx context.Context, segment string, after *int, limit int) ([]int, error)
+	GetSegmentMembers(ctx context.Context, segment string, after *int, limit int) ([]models.SegmentMember, error)
+	CountSegmentUsers(ctx context.Context, segment string) (int, error)
+	CountMembers(ctx context.Context) (map[string]int, error)
 
 	DeleteSegment(ctx context.Context, name string) error
 	DeleteUser(ctx context.Context, id int) error
 	DeleteUserFromSegment(ctx context.Context, userID int, segment string) error
+	DeleteExpiredSegments(ctx context.Context) ([]models.HistoryRecord, error)
+
+	RestoreSegment(ctx context.Context, name string) error
+	RestoreUser(ctx context.Context, id int) error
+	PurgeArchived(ctx context.Context, before time.Time) (int64, error)
+
+	GetHistory(ctx context.Context, from, to time.Time) ([]models.HistoryRecord, error)
+
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	AddDeadLetter(ctx context.Context, letter models.DeadLetter) error
+	ListDeadLetters(ctx context.Context, webhookID int) ([]models.DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id int64) error
+
+	// Webhook deliveries wait in a queue until they are sent or dead-lettered.
+	// ClaimWebhookDeliveries returns deliveries due at now in the order they were queued and postpones them
+	// until lockedUntil, so other app instances do not send them while their attempt is made.
+	AddWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	ClaimWebhookDeliveries(
+		ctx context.Context, now, lockedUntil time.Time, limit int,
+	) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	DeleteWebhookDelivery(ctx context.Context, id int64) error
+
+	// Every change writes its events to the outbox in the same transaction.
+	// LockOutbox returns false if another relay holds the lock.
+	LockOutbox(ctx context.Context) (unlock func(), locked bool, err error)
+	FetchOutbox(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	DeleteOutbox(ctx context.Context, seqs []int64) error
+
+	// NotifyEvents broadcasts events to the listeners of all app instances.
+	NotifyEvents(ctx context.Context, events []models.Event) error
+	ListenEvents(ctx context.Context, handle func(models.Event)) error
 }
 
 type Service struct {
-	repo SegmentStorage
+	repo     SegmentStorage
+	log      *slog.Logger
+	imports  *importJobs
+	webhooks *webhookSink
+	stream   *eventStream
+	tasks    *tasks
 }
 
-func NewService(repo SegmentStorage) *Service {
-	return &Service{repo: repo}
+func NewService(repo SegmentStorage, log *slog.Logger) *Service {
+	return &Service{
+		repo:     repo,
+		log:      log,
+		imports:  newImportJobs(),
+		webhooks: newWebhookSink(repo, log),
+		stream:   newEventStream(),
+		tasks:    newTasks(),
+	}
 }
 
-func (s *Service) CreateSegments(ctx context.Context, segments []string) (map[string]string, error) {
+func (s *Service) CreateSegments(ctx context.Context, segments []models.Segment) (map[string]string, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateSegments")
+	defer span.End()
+
 	reply := make(map[string]string)
 	for _, segment := range segments {
-		err := s.repo.CreateSegment(ctx, segment)
+		err := s.createSegment(ctx, segment)
 		switch {
 		case errors.Is(err, storage.ErrAlreadyExist):
-			if _, ok := reply[segment]; !ok {
-				reply[segment] = "already exist"
+			if _, ok := reply[segment.Name]; !ok {
+				reply[segment.Name] = "already exist"
 			}
-			log.Printf("EXIST: segment '%s' already exist", segment)
+			s.log.InfoContext(ctx, "segment already exists", "segment", segment.Name)
 		case err == nil:
-			reply[segment] = "created"
-			log.Printf("SUCCESS: segment '%s' was created", segment)
+			reply[segment.Name] = "created"
+			s.log.InfoContext(ctx, "segment created", "segment", segment.Name)
 		default:
-			reply[segment] = "not created"
-			log.Printf("ERROR: create segment '%s' failed: %v\n", segment, err)
+			reply[segment.Name] = "not created"
+			s.log.ErrorContext(ctx, "create segment", "segment", segment.Name, "error", err)
 		}
 	}
 	return reply, nil
 }
 
+// createSegment creates the segment and adds the segment's AutoPercent share of existing users to it
+// in one transaction, so a segment is never left partly enrolled.
+func (s *Service) createSegment(ctx context.Context, segment models.Segment) error {
+	if segment.AutoPercent == 0 {
+		return s.repo.CreateSegment(ctx, segment)
+	}
+
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateSegment(ctx, segment); err != nil {
+			return err
+		}
+		users, err := s.repo.GetUserIDs(ctx)
+		if err != nil {
+			return fmt.Errorf("get users: %w", err)
+		}
+		rollout := make([]int, 0, len(users))
+		for _, userID := range users {
+			if inRollout(userID, segment.Name, segment.AutoPercent) {
+				rollout = append(rollout, userID)
+			}
+		}
+		if len(rollout) == 0 {
+			return nil
+		}
+		result, err := s.repo.AddUsersToSegment(ctx, segment.Name, rollout, nil)
+		if err != nil {
+			return fmt.Errorf("enroll users: %w", err)
+		}
+		s.log.InfoContext(ctx, "users enrolled to segment",
+			"segment", segment.Name, "enrolled", len(result.Changed), "users", len(users))
+		return nil
+	})
+}
+
 func (s *Service) CreateUsers(ctx context.Context, users []int) (map[int]string, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateUsers")
+	defer span.End()
+
+	autoSegments, err := s.repo.GetAutoSegments(ctx)
+	if err != nil {
+		s.log.ErrorContext(ctx, "get auto segments", "error", err)
+		return nil, err
+	}
+
 	result := make(map[int]string)
 	for _, userID := range users {
 		err := s.repo.CreateUser(ctx, userID)
@@ -61,83 +180,359 @@ func (s *Service) CreateUsers(ctx context.Context, users []int) (map[int]string,
 			if _, ok := result[userID]; !ok {
 				result[userID] = "already exist"
 			}
-			log.Printf("EXIST: user '%d' already exist", userID)
+			s.log.InfoContext(ctx, "user already exists", "user_id", userID)
 		case err == nil:
 			result[userID] = "created"
-			log.Printf("SUCCESS: user '%d' was created", userID)
+			s.log.InfoContext(ctx, "user created", "user_id", userID)
+			s.enrollNewUser(ctx, userID, autoSegments)
 		default:
 			result[userID] = "not created"
-			log.Printf("ERROR: create user '%d' failed: %v", userID, err)
+			s.log.ErrorContext(ctx, "create user", "user_id", userID, "error", err)
 		}
 	}
 	return result, nil
 }
 
+// enrollNewUser adds the user to the auto segments whose rollout it falls into.
+func (s *Service) enrollNewUser(ctx context.Context, userID int, autoSegments []models.Segment) {
+	for _, segment := range autoSegments {
+		if !inRollout(userID, segment.Name, segment.AutoPercent) {
+			continue
+		}
+		err := s.repo.AddUserToSegment(ctx, userID, segment.Name, nil)
+		if err != nil && !errors.Is(err, storage.ErrAlreadyExist) {
+			s.log.ErrorContext(ctx, "add user to segment", "user_id", userID, "segment", segment.Name, "error", err)
+		}
+	}
+}
+
 func (s *Service) GetUser(ctx context.Context, id int) (models.User, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetUser")
+	defer span.End()
+
 	user, err := s.repo.GetUser(ctx, id)
 	if err != nil {
-		log.Printf("ERROR: get user '%d': %v", id, err)
+		s.log.ErrorContext(ctx, "get user", "user_id", id, "error", err)
 		return models.User{}, err
 	}
 	return user, nil
 }
 
-func (s *Service) UpdateUser(ctx context.Context, params models.UpdateUserParams) error {
-	isCreated, err := s.repo.IsUserCreated(ctx, params.ID)
+// GetUsersSegments returns segments of many users at once, users that do not exist are reported as missing.
+func (s *Service) GetUsersSegments(ctx context.Context, users []int) (models.UsersSegments, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetUsersSegments")
+	defer span.End()
+
+	result, err := s.repo.GetUsersSegments(ctx, users)
 	if err != nil {
-		return err
-	}
-	if !isCreated {
-		return storage.ErrNotExist
+		s.log.ErrorContext(ctx, "get segments of users", "users", len(users), "error", err)
+		return models.UsersSegments{}, err
 	}
+	return result, nil
+}
 
-	for _, segment := range params.AddSegments {
-		err = s.repo.AddUserToSegment(ctx, params.ID, segment)
-		switch {
-		case err == nil:
-			log.Printf("SUCCESS: segment '%s' was updated", segment)
-		case errors.Is(err, storage.ErrAlreadyExist):
-			log.Printf("user '%d' already exist in segment '%s'", params.ID, segment)
-			continue
-		case errors.Is(err, storage.ErrNotExist):
-			log.Printf("segment '%s' not created", segment)
-			continue
-		default:
-			log.Printf("ERROR: add user segment to segment failed: %v", err)
+// UpdateUser applies all adds and deletes atomically: on an unexpected error none of them is applied.
+// Segments that do not exist and repeated adds are skipped.
+func (s *Service) UpdateUser(ctx context.Context, params models.UpdateUserParams) error {
+	ctx, span := tracer.Start(ctx, "Service.UpdateUser")
+	defer span.End()
+
+	return s.repo.InTx(ctx, func(ctx context.Context) error {
+		isCreated, err := s.repo.IsUserCreated(ctx, params.ID)
+		if err != nil {
 			return err
 		}
-	}
+		if !isCreated {
+			return storage.ErrNotExist
+		}
 
-	for _, segment := range params.DeleteSegments {
-		err = s.repo.DeleteUserFromSegment(ctx, params.ID, segment)
-		switch {
-		case err == nil:
-			log.Printf("SUCCESS: user '%d' was deleted from segment '%s'", params.ID, segment)
-		case errors.Is(err, storage.ErrNotExist):
-			log.Printf("segment '%s' not created", segment)
-			continue
-		default:
-			log.Printf("ERROR: delete user segment from segment failed: %v", err)
-			return err
+		for _, membership := range params.AddSegments {
+			segment := membership.Segment
+			err = s.repo.AddUserToSegment(ctx, params.ID, segment, membership.ExpiresAt)
+			switch {
+			case err == nil:
+				s.log.InfoContext(ctx, "user added to segment", "user_id", params.ID, "segment", segment)
+			case errors.Is(err, storage.ErrAlreadyExist):
+				s.log.InfoContext(ctx, "user already in segment", "user_id", params.ID, "segment", segment)
+				continue
+			case errors.Is(err, storage.ErrNotExist):
+				s.log.InfoContext(ctx, "segment not created", "segment", segment)
+				continue
+			default:
+				s.log.ErrorContext(ctx, "add user to segment", "user_id", params.ID, "segment", segment, "error", err)
+				return err
+			}
+		}
+
+		for _, segment := range params.DeleteSegments {
+			err = s.repo.DeleteUserFromSegment(ctx, params.ID, segment)
+			switch {
+			case err == nil:
+				s.log.InfoContext(ctx, "user deleted from segment", "user_id", params.ID, "segment", segment)
+			case errors.Is(err, storage.ErrNotExist):
+				s.log.InfoContext(ctx, "segment not created", "segment", segment)
+				continue
+			default:
+				s.log.ErrorContext(ctx, "delete user from segment", "user_id", params.ID, "segment", segment, "error", err)
+				return err
+			}
 		}
+		return nil
+	})
+}
+
+// AddSegmentUsers adds the users to the segment and returns the result for every user:
+// "added", "already exist" or "not created" if the user does not exist.
+func (s *Service) AddSegmentUsers(
+	ctx context.Context, segment string, users []int, expiresAt *time.Time,
+) (map[int]string, error) {
+	ctx, span := tracer.Start(ctx, "Service.AddSegmentUsers")
+	defer span.End()
+
+	bulk, err := s.repo.AddUsersToSegment(ctx, segment, users, expiresAt)
+	if err != nil {
+		s.log.ErrorContext(ctx, "add users to segment", "segment", segment, "users", len(users), "error", err)
+		return nil, err
 	}
-	return nil
+	s.log.InfoContext(ctx, "users added to segment", "segment", segment, "added", len(bulk.Changed))
+	return bulkReply(users, bulk, "added", "already exist"), nil
+}
+
+// DeleteSegmentUsers removes the users from the segment and returns the result for every user:
+// "removed", "not in segment" or "not created" if the user does not exist.
+func (s *Service) DeleteSegmentUsers(ctx context.Context, segment string, users []int) (map[int]string, error) {
+	ctx, span := tracer.Start(ctx, "Service.DeleteSegmentUsers")
+	defer span.End()
+
+	bulk, err := s.repo.DeleteUsersFromSegment(ctx, segment, users)
+	if err != nil {
+		s.log.ErrorContext(ctx, "delete users from segment", "segment", segment, "users", len(users), "error", err)
+		return nil, err
+	}
+	s.log.InfoContext(ctx, "users deleted from segment", "segment", segment, "deleted", len(bulk.Changed))
+	return bulkReply(users, bulk, "removed", "not in segment"), nil
+}
+
+func bulkReply(users []int, bulk models.BulkMembershipResult, changed, unchanged string) map[int]string {
+	reply := make(map[int]string, len(users))
+	for _, userID := range users {
+		reply[userID] = unchanged
+	}
+	for _, userID := range bulk.Changed {
+		reply[userID] = changed
+	}
+	for _, userID := range bulk.Missing {
+		reply[userID] = "not created"
+	}
+	return reply
+}
+
+func (s *Service) GetSegment(ctx context.Context, name string) (models.Segment, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetSegment")
+	defer span.End()
+
+	segment, err := s.repo.GetSegment(ctx, name)
+	if err != nil {
+		s.log.ErrorContext(ctx, "get segment", "segment", name, "error", err)
+		return models.Segment{}, err
+	}
+	return segment, nil
+}
+
+func (s *Service) UpdateSegment(ctx context.Context, params models.UpdateSegmentParams) (models.Segment, error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateSegment")
+	defer span.End()
+
+	segment, err := s.repo.UpdateSegment(ctx, params)
+	if err != nil {
+		s.log.ErrorContext(ctx, "update segment", "segment", params.Name, "error", err)
+		return models.Segment{}, err
+	}
+	s.log.InfoContext(ctx, "segment updated", "segment", params.Name)
+	return segment, nil
+}
+
+func (s *Service) RenameSegment(ctx context.Context, name, newName string) (models.Segment, error) {
+	ctx, span := tracer.Start(ctx, "Service.RenameSegment")
+	defer span.End()
+
+	segment, err := s.repo.RenameSegment(ctx, name, newName)
+	if err != nil {
+		s.log.ErrorContext(ctx, "rename segment", "segment", name, "new_name", newName, "error", err)
+		return models.Segment{}, err
+	}
+	s.log.InfoContext(ctx, "segment renamed", "segment", name, "new_name", newName)
+	return segment, nil
+}
+
+// MergeSegments moves members of the sources into the target and deletes the sources.
+// It returns the number of users added to the target.
+func (s *Service) MergeSegments(ctx context.Context, target string, sources []string) (int64, error) {
+	ctx, span := tracer.Start(ctx, "Service.MergeSegments")
+	defer span.End()
+
+	added, err := s.repo.MergeSegments(ctx, target, sources)
+	if err != nil {
+		s.log.ErrorContext(ctx, "merge segments", "target", target, "sources", sources, "error", err)
+		return 0, err
+	}
+	s.log.InfoContext(ctx, "segments merged", "target", target, "sources", sources, "added", len(added))
+	return int64(len(added)), nil
+}
+
+func (s *Service) ListSegments(ctx context.Context, params models.ListSegmentsParams) (models.SegmentsPage, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListSegments")
+	defer span.End()
+
+	segments, err := s.repo.ListSegments(ctx, params.Prefix, params.After, params.Limit+1)
+	if err != nil {
+		s.log.ErrorContext(ctx, "list segments", "error", err)
+		return models.SegmentsPage{}, err
+	}
+
+	page := models.SegmentsPage{Segments: segments}
+	if len(segments) > params.Limit {
+		page.Segments = segments[:params.Limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+func (s *Service) GetSegmentUsers(ctx context.Context, params models.SegmentUsersParams) (models.SegmentUsersPage, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetSegmentUsers")
+	defer span.End()
+
+	total, err := s.repo.CountSegmentUsers(ctx, params.Segment)
+	if err != nil {
+		s.log.ErrorContext(ctx, "count users of segment", "segment", params.Segment, "error", err)
+		return models.SegmentUsersPage{}, err
+	}
+
+	users, err := s.repo.GetSegmentUsers(ctx, params.Segment, params.After, params.Limit+1)
+	if err != nil {
+		s.log.ErrorContext(ctx, "get users of segment", "segment", params.Segment, "error", err)
+		return models.SegmentUsersPage{}, err
+	}
+
+	page := models.SegmentUsersPage{Users: users, Total: total}
+	if len(users) > params.Limit {
+		page.Users = users[:params.Limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// GetReport returns membership changes made during the month that starts at period.
+func (s *Service) GetReport(ctx context.Context, period time.Time) ([]models.HistoryRecord, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetReport")
+	defer span.End()
+
+	records, err := s.repo.GetHistory(ctx, period, period.AddDate(0, 1, 0))
+	if err != nil {
+		s.log.ErrorContext(ctx, "get report", "period", period.Format("2006-01"), "error", err)
+		return nil, err
+	}
+	return records, nil
 }
 
 func (s *Service) DeleteSegment(ctx context.Context, name string) error {
+	ctx, span := tracer.Start(ctx, "Service.DeleteSegment")
+	defer span.End()
+
 	err := s.repo.DeleteSegment(ctx, name)
 	if err != nil {
-		log.Printf("ERROR: delete segment '%v': %v", name, err)
+		s.log.ErrorContext(ctx, "delete segment", "segment", name, "error", err)
 		return err
 	}
 	return nil
 }
 
 func (s *Service) DeleteUser(ctx context.Context, id int) error {
+	ctx, span := tracer.Start(ctx, "Service.DeleteUser")
+	defer span.End()
+
 	err := s.repo.DeleteUser(ctx, id)
 	if err != nil {
-		log.Printf("ERROR: delete user '%d': %v", id, err)
+		s.log.ErrorContext(ctx, "delete user", "user_id", id, "error", err)
 		return err
 	}
 	return nil
 }
+
+// RunExpirationReaper removes expired memberships every interval until ctx is done,
+// so expiry is honored even for users nobody reads.
+func (s *Service) RunExpirationReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reapExpiredSegments(ctx)
+		}
+	}
+}
+
+func (s *Service) reapExpiredSegments(ctx context.Context) {
+	deleted, err := s.repo.DeleteExpiredSegments(ctx)
+	if err != nil {
+		s.log.ErrorContext(ctx, "delete expired memberships", "error", err)
+		return
+	}
+	if len(deleted) > 0 {
+		s.log.InfoContext(ctx, "expired memberships deleted", "deleted", len(deleted))
+	}
+}
+
+func (s *Service) RestoreSegment(ctx context.Context, name string) error {
+	ctx, span := tracer.Start(ctx, "Service.RestoreSegment")
+	defer span.End()
+
+	if err := s.repo.RestoreSegment(ctx, name); err != nil {
+		s.log.ErrorContext(ctx, "restore segment", "segment", name, "error", err)
+		return err
+	}
+	s.log.InfoContext(ctx, "segment restored", "segment", name)
+	return nil
+}
+
+func (s *Service) RestoreUser(ctx context.Context, id int) error {
+	ctx, span := tracer.Start(ctx, "Service.RestoreUser")
+	defer span.End()
+
+	if err := s.repo.RestoreUser(ctx, id); err != nil {
+		s.log.ErrorContext(ctx, "restore user", "user_id", id, "error", err)
+		return err
+	}
+	s.log.InfoContext(ctx, "user restored", "user_id", id)
+	return nil
+}
+
+// RunArchivePurger deletes for good segments and users archived longer than retention ago,
+// checking every interval until ctx is done.
+func (s *Service) RunArchivePurger(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.purgeArchived(ctx, retention)
+		}
+	}
+}
+
+func (s *Service) purgeArchived(ctx context.Context, retention time.Duration) {
+	purged, err := s.repo.PurgeArchived(ctx, time.Now().Add(-retention))
+	if err != nil {
+		s.log.ErrorContext(ctx, "purge archived segments and users", "error", err)
+		return
+	}
+	if purged > 0 {
+		s.log.InfoContext(ctx, "archived segments and users purged", "purged", purged)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"testing"
+	"time"
 
+	"github.com/iTcatt/segmenter/internal/logger"
 	"github.com/iTcatt/segmenter/internal/models"
 	"github.com/iTcatt/segmenter/internal/service/mocks"
 	"github.com/iTcatt/segmenter/internal/storage"
@@ -47,7 +49,7 @@ func TestService_GetUser(t *testing.T) {
 				Return(test.result, test.err).
 				Once()
 
-			service := NewService(mockStorage)
+			service := NewService(mockStorage, logger.Discard())
 			user, err := service.GetUser(ctx, test.id)
 			assert.Equal(t, test.result, user)
 			assert.Equal(t, test.err, err)
@@ -55,35 +57,79 @@ func TestService_GetUser(t *testing.T) {
 	}
 }
 
+func TestService_GetUsersSegments(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		result models.UsersSegments
+		err    error
+	}{
+		{
+			name: "success",
+			result: models.UsersSegments{
+				Segments: map[int][]string{1000: {"AVITO_VOICE_MESSAGES"}, 1002: {}},
+				Missing:  []int{1004},
+			},
+		},
+		{
+			name:   "storage error",
+			result: models.UsersSegments{},
+			err:    sql.ErrNoRows,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("GetUsersSegments", mock.Anything, []int{1000, 1002, 1004}).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			result, err := service.GetUsersSegments(ctx, []int{1000, 1002, 1004})
+			assert.Equal(t, test.result, result)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
 func TestService_CreateSegments(t *testing.T) {
 	ctx := context.Background()
 	tests := []struct {
 		name     string
-		segments []string
+		segments []models.Segment
 		results  []error
 		expected map[string]string
 	}{
 		{
 			name:     "no segments",
-			segments: []string{},
+			segments: []models.Segment{},
 			results:  []error{},
 			expected: map[string]string{},
 		},
 		{
 			name:     "success",
-			segments: []string{"a", "b"},
+			segments: []models.Segment{{Name: "a"}, {Name: "b"}},
 			results:  []error{nil, nil},
 			expected: map[string]string{"a": "created", "b": "created"},
 		},
 		{
 			name:     "two errors",
-			segments: []string{"a", "b"},
+			segments: []models.Segment{{Name: "a"}, {Name: "b"}},
 			results:  []error{storage.ErrAlreadyExist, sql.ErrTxDone},
 			expected: map[string]string{"a": "already exist", "b": "not created"},
 		},
+		{
+			name:     "auto percent",
+			segments: []models.Segment{{Name: "a", AutoPercent: 100}, {Name: "b", AutoPercent: 100}},
+			results:  []error{nil, storage.ErrAlreadyExist},
+			expected: map[string]string{"a": "created", "b": "already exist"},
+		},
 		{
 			name:     "one success, one error",
-			segments: []string{"a", "b"},
+			segments: []models.Segment{{Name: "a"}, {Name: "b"}},
 			results:  []error{storage.ErrAlreadyExist, nil},
 			expected: map[string]string{"a": "already exist", "b": "created"},
 		},
@@ -96,8 +142,24 @@ func TestService_CreateSegments(t *testing.T) {
 				mockStorage.
 					On("CreateSegment", mock.Anything, segment).
 					Return(test.results[i])
+				if segment.AutoPercent > 0 {
+					mockStorage.
+						On("InTx", mock.Anything, mock.Anything).
+						Return(runInTx).
+						Once()
+				}
+				if segment.AutoPercent > 0 && test.results[i] == nil {
+					mockStorage.
+						On("GetUserIDs", mock.Anything).
+						Return([]int{1, 2}, nil).
+						Once()
+					mockStorage.
+						On("AddUsersToSegment", mock.Anything, segment.Name, []int{1, 2}, (*time.Time)(nil)).
+						Return(models.BulkMembershipResult{Changed: []int{1, 2}, Missing: []int{}}, nil).
+						Once()
+				}
 			}
-			service := NewService(mockStorage)
+			service := NewService(mockStorage, logger.Discard())
 			result, err := service.CreateSegments(ctx, test.segments)
 			assert.Equal(t, test.expected, result)
 			assert.Nil(t, err)
@@ -105,6 +167,40 @@ func TestService_CreateSegments(t *testing.T) {
 	}
 }
 
+func TestService_CreateSegments_EnrollFails(t *testing.T) {
+	ctx := context.Background()
+	segment := models.Segment{Name: "a", AutoPercent: 100}
+	mockStorage := mocks.NewSegmentStorage(t)
+	var rolledBack bool
+	mockStorage.
+		On("InTx", mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, fn func(ctx context.Context) error) error {
+			err := fn(ctx)
+			rolledBack = err != nil
+			return err
+		}).
+		Once()
+	mockStorage.
+		On("CreateSegment", mock.Anything, segment).
+		Return(nil).
+		Once()
+	mockStorage.
+		On("GetUserIDs", mock.Anything).
+		Return([]int{1, 2}, nil).
+		Once()
+	mockStorage.
+		On("AddUsersToSegment", mock.Anything, "a", []int{1, 2}, (*time.Time)(nil)).
+		Return(models.BulkMembershipResult{}, sql.ErrConnDone).
+		Once()
+
+	// The segment is rolled back with the enrollment instead of being left partly enrolled.
+	service := NewService(mockStorage, logger.Discard())
+	result, err := service.CreateSegments(ctx, []models.Segment{segment})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "not created"}, result)
+	assert.True(t, rolledBack)
+}
+
 func TestService_CreateUsers(t *testing.T) {
 	ctx := context.Background()
 	tests := []struct {
@@ -142,12 +238,16 @@ func TestService_CreateUsers(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("GetAutoSegments", mock.Anything).
+				Return([]models.Segment{}, nil).
+				Once()
 			for i, userID := range test.users {
 				mockStorage.
 					On("CreateUser", mock.Anything, userID).
 					Return(test.results[i])
 			}
-			service := NewService(mockStorage)
+			service := NewService(mockStorage, logger.Discard())
 			result, err := service.CreateUsers(ctx, test.users)
 			assert.Equal(t, test.expected, result)
 			assert.Nil(t, err)
@@ -155,6 +255,81 @@ func TestService_CreateUsers(t *testing.T) {
 	}
 }
 
+func TestService_CreateUsers_AutoSegments(t *testing.T) {
+	ctx := context.Background()
+	autoSegments := []models.Segment{
+		{Name: "all", AutoPercent: 100},
+		{Name: "half", AutoPercent: 50},
+	}
+	users := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	// Members of "half" are pinned, so a change of the bucketing moves enrolled users and fails the test.
+	half := []int{1, 2, 3, 5, 6, 7}
+
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("GetAutoSegments", mock.Anything).
+		Return(autoSegments, nil).
+		Once()
+	for _, userID := range users {
+		mockStorage.
+			On("CreateUser", mock.Anything, userID).
+			Return(nil).
+			Once()
+		mockStorage.
+			On("AddUserToSegment", mock.Anything, userID, "all", (*time.Time)(nil)).
+			Return(nil).
+			Once()
+	}
+	for _, userID := range half {
+		mockStorage.
+			On("AddUserToSegment", mock.Anything, userID, "half", (*time.Time)(nil)).
+			Return(nil).
+			Once()
+	}
+
+	service := NewService(mockStorage, logger.Discard())
+	_, err := service.CreateUsers(ctx, users)
+	assert.Nil(t, err)
+	mockStorage.AssertNumberOfCalls(t, "AddUserToSegment", len(users)+len(half))
+}
+
+func TestService_CreateUsers_AutoSegmentsShare(t *testing.T) {
+	users := make([]int, 0, 2000)
+	for userID := 1; userID <= 2000; userID++ {
+		users = append(users, userID)
+	}
+
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("GetAutoSegments", mock.Anything).
+		Return([]models.Segment{{Name: "AVITO_EXPERIMENT", AutoPercent: 30}}, nil).
+		Once()
+	mockStorage.On("CreateUser", mock.Anything, mock.Anything).Return(nil)
+	enrolled := 0
+	mockStorage.
+		On("AddUserToSegment", mock.Anything, mock.Anything, "AVITO_EXPERIMENT", (*time.Time)(nil)).
+		Return(nil).
+		Run(func(mock.Arguments) { enrolled++ })
+
+	service := NewService(mockStorage, logger.Discard())
+	_, err := service.CreateUsers(context.Background(), users)
+	assert.Nil(t, err)
+	assert.InDelta(t, 600, enrolled, 60)
+}
+
+func TestService_CreateUsers_AutoSegmentsError(t *testing.T) {
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("GetAutoSegments", mock.Anything).
+		Return(nil, sql.ErrConnDone).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	result, err := service.CreateUsers(context.Background(), []int{1})
+	assert.Nil(t, result)
+	assert.Equal(t, sql.ErrConnDone, err)
+}
+
 func TestService_DeleteSegment(t *testing.T) {
 	ctx := context.Background()
 
@@ -185,7 +360,7 @@ func TestService_DeleteSegment(t *testing.T) {
 				On("DeleteSegment", mock.Anything, test.segment).
 				Return(test.result).
 				Once()
-			service := NewService(mockStorage)
+			service := NewService(mockStorage, logger.Discard())
 			err := service.DeleteSegment(ctx, test.segment)
 			assert.Equal(t, test.expected, err)
 		})
@@ -222,13 +397,18 @@ func TestService_DeleteUser(t *testing.T) {
 				On("DeleteUser", mock.Anything, test.id).
 				Return(test.result).
 				Once()
-			service := NewService(mockStorage)
+			service := NewService(mockStorage, logger.Discard())
 			err := service.DeleteUser(ctx, test.id)
 			assert.Equal(t, test.expected, err)
 		})
 	}
 }
 
+// runInTx makes the mocked InTx call its function the way a real transaction does.
+func runInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
 func TestService_UpdateUser(t *testing.T) {
 	ctx := context.Background()
 	type isUserCreatedResults struct {
@@ -240,6 +420,7 @@ func TestService_UpdateUser(t *testing.T) {
 		addUserToSegment    []error
 		deleteUserToSegment []error
 	}
+	expiresAt := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
 
 	var tests = []struct {
 		name     string
@@ -296,7 +477,7 @@ func TestService_UpdateUser(t *testing.T) {
 			name: "success add user to segments",
 			params: models.UpdateUserParams{
 				ID:             2,
-				AddSegments:    []string{"a", "b"},
+				AddSegments:    []models.SegmentMembership{{Segment: "a"}, {Segment: "b"}},
 				DeleteSegments: nil,
 			},
 			result: resultFromDB{
@@ -330,7 +511,7 @@ func TestService_UpdateUser(t *testing.T) {
 			name: "unexpected error add user to segments",
 			params: models.UpdateUserParams{
 				ID:             4,
-				AddSegments:    []string{"a"},
+				AddSegments:    []models.SegmentMembership{{Segment: "a"}},
 				DeleteSegments: nil,
 			},
 			result: resultFromDB{
@@ -347,7 +528,7 @@ func TestService_UpdateUser(t *testing.T) {
 			name: "unexpected error: delete user to segments",
 			params: models.UpdateUserParams{
 				ID:             5,
-				AddSegments:    []string{"c"},
+				AddSegments:    []models.SegmentMembership{{Segment: "c"}},
 				DeleteSegments: []string{"d"},
 			},
 			result: resultFromDB{
@@ -360,11 +541,28 @@ func TestService_UpdateUser(t *testing.T) {
 			},
 			expected: sql.ErrNoRows,
 		},
+		{
+			name: "success add user to segment with expiry",
+			params: models.UpdateUserParams{
+				ID:             7,
+				AddSegments:    []models.SegmentMembership{{Segment: "a", ExpiresAt: &expiresAt}, {Segment: "b"}},
+				DeleteSegments: nil,
+			},
+			result: resultFromDB{
+				isUserCreated: isUserCreatedResults{
+					created: true,
+					err:     nil,
+				},
+				addUserToSegment:    []error{nil, nil},
+				deleteUserToSegment: nil,
+			},
+			expected: nil,
+		},
 		{
 			name: "not exist segments",
 			params: models.UpdateUserParams{
 				ID:             6,
-				AddSegments:    []string{"a"},
+				AddSegments:    []models.SegmentMembership{{Segment: "a"}},
 				DeleteSegments: []string{"b"},
 			},
 			result: resultFromDB{
@@ -382,13 +580,17 @@ func TestService_UpdateUser(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("InTx", mock.Anything, mock.Anything).
+				Return(runInTx).
+				Once()
 			mockStorage.
 				On("IsUserCreated", mock.Anything, test.params.ID).
 				Return(test.result.isUserCreated.created, test.result.isUserCreated.err).
 				Once()
-			for i, segment := range test.params.AddSegments {
+			for i, membership := range test.params.AddSegments {
 				mockStorage.
-					On("AddUserToSegment", mock.Anything, test.params.ID, segment).
+					On("AddUserToSegment", mock.Anything, test.params.ID, membership.Segment, membership.ExpiresAt).
 					Return(test.result.addUserToSegment[i])
 			}
 			for i, segment := range test.params.DeleteSegments {
@@ -397,10 +599,508 @@ func TestService_UpdateUser(t *testing.T) {
 					Return(test.result.deleteUserToSegment[i])
 			}
 
-			service := NewService(mockStorage)
+			service := NewService(mockStorage, logger.Discard())
 			err := service.UpdateUser(ctx, test.params)
 			assert.Equal(t, test.expected, err)
 		})
 
 	}
 }
+
+func TestService_RunExpirationReaper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("DeleteExpiredSegments", mock.Anything).
+		Return([]models.HistoryRecord{}, nil).
+		Run(func(mock.Arguments) { cancel() })
+
+	service := NewService(mockStorage, logger.Discard())
+	done := make(chan struct{})
+	go func() {
+		service.RunExpirationReaper(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after context cancellation")
+	}
+}
+
+func TestService_RunArchivePurger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	retention := 24 * time.Hour
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("PurgeArchived", mock.Anything, mock.MatchedBy(func(before time.Time) bool {
+			return time.Since(before) >= retention && time.Since(before) < retention+time.Minute
+		})).
+		Return(int64(1), nil).
+		Run(func(mock.Arguments) { cancel() })
+
+	service := NewService(mockStorage, logger.Discard())
+	done := make(chan struct{})
+	go func() {
+		service.RunArchivePurger(ctx, time.Millisecond, retention)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purger did not stop after context cancellation")
+	}
+}
+
+func TestService_RestoreSegment(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "ok"},
+		{name: "not archived", err: storage.ErrNotExist},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("RestoreSegment", mock.Anything, "AVITO_VOICE_MESSAGES").
+				Return(test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			assert.Equal(t, test.err, service.RestoreSegment(ctx, "AVITO_VOICE_MESSAGES"))
+		})
+	}
+}
+
+func TestService_RestoreUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "ok"},
+		{name: "not archived", err: storage.ErrNotExist},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("RestoreUser", mock.Anything, 1000).
+				Return(test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			assert.Equal(t, test.err, service.RestoreUser(ctx, 1000))
+		})
+	}
+}
+
+func TestService_GetReport(t *testing.T) {
+	ctx := context.Background()
+	period := time.Date(2023, time.December, 1, 0, 0, 0, 0, time.UTC)
+	nextPeriod := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		result   []models.HistoryRecord
+		err      error
+		expected []models.HistoryRecord
+	}{
+		{
+			name: "success",
+			result: []models.HistoryRecord{
+				{UserID: 1, Segment: "a", Operation: models.OperationAdd, Timestamp: period},
+				{UserID: 1, Segment: "a", Operation: models.OperationExpire, Timestamp: period.Add(time.Hour)},
+			},
+			err: nil,
+			expected: []models.HistoryRecord{
+				{UserID: 1, Segment: "a", Operation: models.OperationAdd, Timestamp: period},
+				{UserID: 1, Segment: "a", Operation: models.OperationExpire, Timestamp: period.Add(time.Hour)},
+			},
+		},
+		{
+			name:     "error",
+			result:   nil,
+			err:      sql.ErrConnDone,
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("GetHistory", mock.Anything, period, nextPeriod).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			records, err := service.GetReport(ctx, period)
+			assert.Equal(t, test.expected, records)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_AddSegmentUsers(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		result   models.BulkMembershipResult
+		err      error
+		expected map[int]string
+	}{
+		{
+			name:   "ok",
+			result: models.BulkMembershipResult{Changed: []int{2}, Missing: []int{3}},
+			expected: map[int]string{
+				1: "already exist",
+				2: "added",
+				3: "not created",
+			},
+		},
+		{
+			name: "segment not exist",
+			err:  storage.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("AddUsersToSegment", mock.Anything, "AVITO_VOICE_MESSAGES", []int{1, 2, 3}, &expiresAt).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			reply, err := service.AddSegmentUsers(ctx, "AVITO_VOICE_MESSAGES", []int{1, 2, 3}, &expiresAt)
+			assert.Equal(t, test.expected, reply)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_DeleteSegmentUsers(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		result   models.BulkMembershipResult
+		err      error
+		expected map[int]string
+	}{
+		{
+			name:   "ok",
+			result: models.BulkMembershipResult{Changed: []int{1}, Missing: []int{3}},
+			expected: map[int]string{
+				1: "removed",
+				2: "not in segment",
+				3: "not created",
+			},
+		},
+		{
+			name: "segment not exist",
+			err:  storage.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("DeleteUsersFromSegment", mock.Anything, "AVITO_VOICE_MESSAGES", []int{1, 2, 3}).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			reply, err := service.DeleteSegmentUsers(ctx, "AVITO_VOICE_MESSAGES", []int{1, 2, 3})
+			assert.Equal(t, test.expected, reply)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_GetSegment(t *testing.T) {
+	ctx := context.Background()
+	segment := models.Segment{Name: "AVITO_VOICE_MESSAGES", Owner: "messenger", Tags: []string{"voice"}}
+
+	tests := []struct {
+		name     string
+		result   models.Segment
+		err      error
+		expected models.Segment
+	}{
+		{
+			name:     "ok",
+			result:   segment,
+			expected: segment,
+		},
+		{
+			name: "not exist",
+			err:  storage.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("GetSegment", mock.Anything, segment.Name).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			result, err := service.GetSegment(ctx, segment.Name)
+			assert.Equal(t, test.expected, result)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_UpdateSegment(t *testing.T) {
+	ctx := context.Background()
+	owner := "platform"
+	params := models.UpdateSegmentParams{Name: "AVITO_VOICE_MESSAGES", Owner: &owner}
+	updated := models.Segment{Name: "AVITO_VOICE_MESSAGES", Owner: owner}
+
+	tests := []struct {
+		name     string
+		result   models.Segment
+		err      error
+		expected models.Segment
+	}{
+		{
+			name:     "ok",
+			result:   updated,
+			expected: updated,
+		},
+		{
+			name: "not exist",
+			err:  storage.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("UpdateSegment", mock.Anything, params).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			result, err := service.UpdateSegment(ctx, params)
+			assert.Equal(t, test.expected, result)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_RenameSegment(t *testing.T) {
+	ctx := context.Background()
+	renamed := models.Segment{Name: "AVITO_VOICE_CHAT"}
+
+	tests := []struct {
+		name     string
+		result   models.Segment
+		err      error
+		expected models.Segment
+	}{
+		{
+			name:     "ok",
+			result:   renamed,
+			expected: renamed,
+		},
+		{
+			name: "not exist",
+			err:  storage.ErrNotExist,
+		},
+		{
+			name: "name taken",
+			err:  storage.ErrAlreadyExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("RenameSegment", mock.Anything, "AVITO_VOICE_MESSAGES", renamed.Name).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			result, err := service.RenameSegment(ctx, "AVITO_VOICE_MESSAGES", renamed.Name)
+			assert.Equal(t, test.expected, result)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_MergeSegments(t *testing.T) {
+	ctx := context.Background()
+	sources := []string{"AVITO_DISCOUNT_30", "AVITO_DISCOUNT_50"}
+
+	tests := []struct {
+		name     string
+		result   []int
+		err      error
+		expected int64
+	}{
+		{
+			name:     "ok",
+			result:   []int{1, 2, 3},
+			expected: 3,
+		},
+		{
+			name: "not exist",
+			err:  storage.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("MergeSegments", mock.Anything, "AVITO_DISCOUNT", sources).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			added, err := service.MergeSegments(ctx, "AVITO_DISCOUNT", sources)
+			assert.Equal(t, test.expected, added)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_ListSegments(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		params   models.ListSegmentsParams
+		result   []models.Segment
+		err      error
+		expected models.SegmentsPage
+	}{
+		{
+			name:     "empty",
+			params:   models.ListSegmentsParams{Limit: 2},
+			result:   []models.Segment{},
+			expected: models.SegmentsPage{Segments: []models.Segment{}},
+		},
+		{
+			name:   "last page",
+			params: models.ListSegmentsParams{Prefix: "AVITO", After: "AVITO_A", Limit: 2},
+			result: []models.Segment{{Name: "AVITO_B"}, {Name: "AVITO_C"}},
+			expected: models.SegmentsPage{
+				Segments: []models.Segment{{Name: "AVITO_B"}, {Name: "AVITO_C"}},
+			},
+		},
+		{
+			name:   "has more",
+			params: models.ListSegmentsParams{Limit: 2},
+			result: []models.Segment{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			expected: models.SegmentsPage{
+				Segments: []models.Segment{{Name: "a"}, {Name: "b"}},
+				HasMore:  true,
+			},
+		},
+		{
+			name:     "error",
+			params:   models.ListSegmentsParams{Limit: 2},
+			err:      sql.ErrConnDone,
+			expected: models.SegmentsPage{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("ListSegments", mock.Anything, test.params.Prefix, test.params.After, test.params.Limit+1).
+				Return(test.result, test.err).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			page, err := service.ListSegments(ctx, test.params)
+			assert.Equal(t, test.expected, page)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestService_GetSegmentUsers(t *testing.T) {
+	ctx := context.Background()
+	after := 10
+
+	tests := []struct {
+		name     string
+		params   models.SegmentUsersParams
+		total    int
+		countErr error
+		result   []int
+		expected models.SegmentUsersPage
+		err      error
+	}{
+		{
+			name:     "segment not exist",
+			params:   models.SegmentUsersParams{Segment: "a", Limit: 2},
+			countErr: storage.ErrNotExist,
+			expected: models.SegmentUsersPage{},
+			err:      storage.ErrNotExist,
+		},
+		{
+			name:     "last page",
+			params:   models.SegmentUsersParams{Segment: "a", After: &after, Limit: 2},
+			total:    3,
+			result:   []int{11},
+			expected: models.SegmentUsersPage{Users: []int{11}, Total: 3},
+		},
+		{
+			name:     "has more",
+			params:   models.SegmentUsersParams{Segment: "a", Limit: 2},
+			total:    3,
+			result:   []int{1, 5, 11},
+			expected: models.SegmentUsersPage{Users: []int{1, 5}, Total: 3, HasMore: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("CountSegmentUsers", mock.Anything, test.params.Segment).
+				Return(test.total, test.countErr).
+				Once()
+			if test.countErr == nil {
+				mockStorage.
+					On("GetSegmentUsers", mock.Anything, test.params.Segment, test.params.After, test.params.Limit+1).
+					Return(test.result, nil).
+					Once()
+			}
+
+			service := NewService(mockStorage, logger.Discard())
+			page, err := service.GetSegmentUsers(ctx, test.params)
+			assert.Equal(t, test.expected, page)
+			assert.Equal(t, test.err, err)
+		})
+	}
+}
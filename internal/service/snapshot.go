@@ -0,0 +1,500 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+const (
+	// snapshotPageSize is the number of segments or members read by one storage call during the export.
+	snapshotPageSize = 1000
+	// maxSnapshotLine bounds the length of a snapshot line.
+	maxSnapshotLine = 16 << 20
+)
+
+// ErrInvalidSnapshot is returned when a snapshot cannot be restored because it is malformed,
+// of an unsupported version or truncated.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshot record types. A snapshot is a JSON object per line: a header, segments, users,
+// memberships and an end record with the counts, which tells a complete snapshot from a truncated one.
+const (
+	recordHeader     = "header"
+	recordSegment    = "segment"
+	recordUser       = "user"
+	recordMembership = "membership"
+	recordEnd        = "end"
+)
+
+type snapshotRecord struct {
+	Type string `json:"type"`
+}
+
+type snapshotHeader struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type snapshotSegment struct {
+	Type string `json:"type"`
+	models.Segment
+}
+
+type snapshotUser struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+type snapshotMembership struct {
+	Type      string     `json:"type"`
+	UserID    int        `json:"user_id"`
+	Segment   string     `json:"segment"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type snapshotEnd struct {
+	Type string `json:"type"`
+	models.SnapshotStats
+}
+
+// ExportSnapshot writes all active segments with their metadata, users and memberships to w as JSON lines.
+// Records are read page by page in one read-only transaction, so the snapshot is consistent
+// and changes made during the export are left out of it.
+func (s *Service) ExportSnapshot(ctx context.Context, w io.Writer) (models.SnapshotStats, error) {
+	ctx, span := tracer.Start(ctx, "Service.ExportSnapshot")
+	defer span.End()
+
+	var stats models.SnapshotStats
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(snapshotHeader{Type: recordHeader, Version: models.SnapshotVersion, CreatedAt: time.Now()})
+	if err != nil {
+		return stats, err
+	}
+
+	err = s.repo.InTx(storage.ReadOnly(ctx), func(ctx context.Context) error {
+		return s.exportRecords(ctx, encoder, &stats)
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if err = encoder.Encode(snapshotEnd{Type: recordEnd, SnapshotStats: stats}); err != nil {
+		return stats, err
+	}
+	s.log.InfoContext(ctx, "snapshot exported",
+		"segments", stats.Segments, "users", stats.Users, "memberships", stats.Memberships)
+	return stats, nil
+}
+
+func (s *Service) exportRecords(ctx context.Context, encoder *json.Encoder, stats *models.SnapshotStats) error {
+	names := make([]string, 0)
+	for after := ""; ; {
+		segments, err := s.repo.ListSegments(ctx, "", after, snapshotPageSize)
+		if err != nil {
+			s.log.ErrorContext(ctx, "export segments", "error", err)
+			return err
+		}
+		for _, segment := range segments {
+			if err = encoder.Encode(snapshotSegment{Type: recordSegment, Segment: segment}); err != nil {
+				return err
+			}
+			names = append(names, segment.Name)
+			stats.Segments++
+		}
+		if len(segments) < snapshotPageSize {
+			break
+		}
+		after = segments[len(segments)-1].Name
+	}
+
+	userIDs, err := s.repo.GetUserIDs(ctx)
+	if err != nil {
+		s.log.ErrorContext(ctx, "export users", "error", err)
+		return err
+	}
+	for _, id := range userIDs {
+		if err = encoder.Encode(snapshotUser{Type: recordUser, ID: id}); err != nil {
+			return err
+		}
+		stats.Users++
+	}
+
+	for _, name := range names {
+		if err = s.exportMembers(ctx, encoder, name, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) exportMembers(
+	ctx context.Context, encoder *json.Encoder, segment string, stats *models.SnapshotStats,
+) error {
+	var after *int
+	for {
+		members, err := s.repo.GetSegmentMembers(ctx, segment, after, snapshotPageSize)
+		if err != nil {
+			s.log.ErrorContext(ctx, "export members", "segment", segment, "error", err)
+			return err
+		}
+		for _, member := range members {
+			record := snapshotMembership{
+				Type:      recordMembership,
+				UserID:    member.UserID,
+				Segment:   segment,
+				ExpiresAt: member.ExpiresAt,
+			}
+			if err = encoder.Encode(record); err != nil {
+				return err
+			}
+			stats.Memberships++
+		}
+		if len(members) < snapshotPageSize {
+			return nil
+		}
+		after = &members[len(members)-1].UserID
+	}
+}
+
+// RestoreSnapshot loads a snapshot written by ExportSnapshot in a single transaction.
+// In the replace mode all segments and users are archived first; in the merge mode existing data is kept.
+// Archived segments and users of the snapshot are restored from the archive: in the replace mode they take
+// the metadata and memberships of the snapshot, others stay archived and can still be restored.
+// The returned stats count segments, users and memberships that were created or restored.
+func (s *Service) RestoreSnapshot(
+	ctx context.Context, r io.Reader, mode models.RestoreMode,
+) (models.SnapshotStats, error) {
+	ctx, span := tracer.Start(ctx, "Service.RestoreSnapshot")
+	defer span.End()
+
+	if mode != models.RestoreReplace && mode != models.RestoreMerge {
+		return models.SnapshotStats{}, fmt.Errorf("unknown restore mode '%s'", mode)
+	}
+
+	var stats models.SnapshotStats
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		stats = models.SnapshotStats{}
+		if mode == models.RestoreReplace {
+			if err := s.archiveAll(ctx); err != nil {
+				return err
+			}
+		}
+		restorer := snapshotRestorer{service: s, mode: mode, stats: &stats, now: time.Now()}
+		return restorer.restore(ctx, r)
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "restore snapshot", "error", err)
+		return models.SnapshotStats{}, err
+	}
+	s.log.InfoContext(ctx, "snapshot restored",
+		"segments", stats.Segments, "users", stats.Users, "memberships", stats.Memberships,
+		"skipped", stats.SkippedMemberships)
+	return stats, nil
+}
+
+// archiveAll archives every active segment and user.
+func (s *Service) archiveAll(ctx context.Context) error {
+	for {
+		segments, err := s.repo.ListSegments(ctx, "", "", snapshotPageSize)
+		if err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			if err = s.repo.DeleteSegment(ctx, segment.Name); err != nil {
+				return err
+			}
+		}
+		if len(segments) < snapshotPageSize {
+			break
+		}
+	}
+
+	userIDs, err := s.repo.GetUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		if err = s.repo.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotRestorer applies snapshot records one by one.
+// Consecutive memberships of a segment with the same expiry are added in batches.
+type snapshotRestorer struct {
+	service *Service
+	mode    models.RestoreMode
+	stats   *models.SnapshotStats
+	now     time.Time
+
+	header bool
+	// read counts records of the snapshot to compare them with the end record.
+	read  models.SnapshotStats
+	end   *models.SnapshotStats
+	batch []snapshotMembership
+}
+
+func (r *snapshotRestorer) restore(ctx context.Context, reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSnapshotLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := r.apply(ctx, scanner.Bytes()); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := r.flush(ctx); err != nil {
+		return err
+	}
+
+	if r.end == nil {
+		return fmt.Errorf("%w: snapshot is truncated", ErrInvalidSnapshot)
+	}
+	if *r.end != r.read {
+		return fmt.Errorf("%w: read %d segments, %d users and %d memberships, the end record has %d, %d and %d",
+			ErrInvalidSnapshot, r.read.Segments, r.read.Users, r.read.Memberships,
+			r.end.Segments, r.end.Users, r.end.Memberships)
+	}
+	return nil
+}
+
+func (r *snapshotRestorer) apply(ctx context.Context, line []byte) error {
+	var record snapshotRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if !r.header && record.Type != recordHeader {
+		return fmt.Errorf("%w: snapshot must start with a header", ErrInvalidSnapshot)
+	}
+	if r.end != nil {
+		return fmt.Errorf("%w: record after the end of snapshot", ErrInvalidSnapshot)
+	}
+
+	switch record.Type {
+	case recordHeader:
+		var header snapshotHeader
+		if err := unmarshalRecord(line, &header); err != nil {
+			return err
+		}
+		if r.header {
+			return fmt.Errorf("%w: duplicate header", ErrInvalidSnapshot)
+		}
+		if header.Version != models.SnapshotVersion {
+			return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, header.Version)
+		}
+		r.header = true
+		return nil
+	case recordSegment:
+		var segment snapshotSegment
+		if err := unmarshalRecord(line, &segment); err != nil {
+			return err
+		}
+		r.read.Segments++
+		return r.createSegment(ctx, segment.Segment)
+	case recordUser:
+		var user snapshotUser
+		if err := unmarshalRecord(line, &user); err != nil {
+			return err
+		}
+		r.read.Users++
+		return r.createUser(ctx, user.ID)
+	case recordMembership:
+		var membership snapshotMembership
+		if err := unmarshalRecord(line, &membership); err != nil {
+			return err
+		}
+		r.read.Memberships++
+		return r.addMembership(ctx, membership)
+	case recordEnd:
+		var end snapshotEnd
+		if err := unmarshalRecord(line, &end); err != nil {
+			return err
+		}
+		end.SkippedMemberships = 0
+		r.end = &end.SnapshotStats
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown record type '%s'", ErrInvalidSnapshot, record.Type)
+	}
+}
+
+func unmarshalRecord(line []byte, record any) error {
+	if err := json.Unmarshal(line, record); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return nil
+}
+
+func (r *snapshotRestorer) createSegment(ctx context.Context, segment models.Segment) error {
+	if segment.Name == "" {
+		return fmt.Errorf("%w: empty segment name", ErrInvalidSnapshot)
+	}
+	err := r.service.repo.CreateSegment(ctx, models.Segment{
+		Name:        segment.Name,
+		AutoPercent: segment.AutoPercent,
+		Description: segment.Description,
+		Owner:       segment.Owner,
+		Tags:        segment.Tags,
+	})
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return r.restoreSegment(ctx, segment)
+	}
+	if err != nil {
+		return err
+	}
+	r.stats.Segments++
+	return nil
+}
+
+// restoreSegment restores the segment if it is archived, an active segment is kept as it is.
+// In the replace mode the restored segment takes the metadata of the snapshot and its members are removed,
+// the memberships of the snapshot are added instead.
+func (r *snapshotRestorer) restoreSegment(ctx context.Context, segment models.Segment) error {
+	repo := r.service.repo
+	err := repo.RestoreSegment(ctx, segment.Name)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.stats.Segments++
+	if r.mode != models.RestoreReplace {
+		return nil
+	}
+
+	restored, err := repo.GetSegment(ctx, segment.Name)
+	if err != nil {
+		return err
+	}
+	if restored.AutoPercent != segment.AutoPercent {
+		return fmt.Errorf("%w: segment '%s' is archived with auto percent %d, the snapshot has %d",
+			storage.ErrAlreadyExist, segment.Name, restored.AutoPercent, segment.AutoPercent)
+	}
+	_, err = repo.UpdateSegment(ctx, models.UpdateSegmentParams{
+		Name:        segment.Name,
+		Description: &segment.Description,
+		Owner:       &segment.Owner,
+		Tags:        append([]string{}, segment.Tags...),
+	})
+	if err != nil {
+		return err
+	}
+
+	var after *int
+	for {
+		userIDs, err := repo.GetSegmentUsers(ctx, segment.Name, after, snapshotPageSize)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+		if _, err = repo.DeleteUsersFromSegment(ctx, segment.Name, userIDs); err != nil {
+			return err
+		}
+		if len(userIDs) < snapshotPageSize {
+			return nil
+		}
+		after = &userIDs[len(userIDs)-1]
+	}
+}
+
+func (r *snapshotRestorer) createUser(ctx context.Context, id int) error {
+	err := r.service.repo.CreateUser(ctx, id)
+	if errors.Is(err, storage.ErrAlreadyExist) {
+		return r.restoreUser(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	r.stats.Users++
+	return nil
+}
+
+// restoreUser restores the user if it is archived, an active user is kept as it is.
+// In the replace mode the restored user is removed from its segments, the memberships of the snapshot
+// are added instead.
+func (r *snapshotRestorer) restoreUser(ctx context.Context, id int) error {
+	repo := r.service.repo
+	err := repo.RestoreUser(ctx, id)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.stats.Users++
+	if r.mode != models.RestoreReplace {
+		return nil
+	}
+
+	users, err := repo.GetUsersSegments(ctx, []int{id})
+	if err != nil {
+		return err
+	}
+	for _, segment := range users.Segments[id] {
+		if err = repo.DeleteUserFromSegment(ctx, id, segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *snapshotRestorer) addMembership(ctx context.Context, membership snapshotMembership) error {
+	if membership.ExpiresAt != nil && !membership.ExpiresAt.After(r.now) {
+		r.stats.SkippedMemberships++
+		return nil
+	}
+	if len(r.batch) > 0 {
+		first := r.batch[0]
+		if len(r.batch) == importBatchSize || first.Segment != membership.Segment ||
+			expiresBefore(first.ExpiresAt, membership.ExpiresAt) || expiresBefore(membership.ExpiresAt, first.ExpiresAt) {
+			if err := r.flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	r.batch = append(r.batch, membership)
+	return nil
+}
+
+// flush adds the batched memberships.
+func (r *snapshotRestorer) flush(ctx context.Context) error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	first := r.batch[0]
+	userIDs := make([]int, 0, len(r.batch))
+	for _, membership := range r.batch {
+		userIDs = append(userIDs, membership.UserID)
+	}
+	r.batch = r.batch[:0]
+
+	result, err := r.service.repo.AddUsersToSegment(ctx, first.Segment, userIDs, first.ExpiresAt)
+	if errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("%w: segment '%s' not exist", ErrInvalidSnapshot, first.Segment)
+	}
+	if err != nil {
+		return err
+	}
+	r.stats.Memberships += len(result.Changed)
+	r.stats.SkippedMemberships += len(result.Missing)
+	return nil
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service/mocks"
+	"github.com/iTcatt/segmenter/internal/storage"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+)
+
+func TestService_ExportSnapshot(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("InTx", mock.MatchedBy(storage.IsReadOnly), mock.Anything).
+		Return(runInTx).
+		Once()
+	mockStorage.
+		On("ListSegments", mock.Anything, "", "", snapshotPageSize).
+		Return([]models.Segment{
+			{Name: "a", AutoPercent: 10, Owner: "team", Tags: []string{"x"}},
+			{Name: "b"},
+		}, nil).
+		Once()
+	mockStorage.
+		On("GetUserIDs", mock.Anything).
+		Return([]int{1, 2}, nil).
+		Once()
+	mockStorage.
+		On("GetSegmentMembers", mock.Anything, "a", (*int)(nil), snapshotPageSize).
+		Return([]models.SegmentMember{{UserID: 1}, {UserID: 2, ExpiresAt: &expiresAt}}, nil).
+		Once()
+	mockStorage.
+		On("GetSegmentMembers", mock.Anything, "b", (*int)(nil), snapshotPageSize).
+		Return([]models.SegmentMember{}, nil).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	var out bytes.Buffer
+	stats, err := service.ExportSnapshot(ctx, &out)
+	require.NoError(t, err)
+	assert.Equal(t, models.SnapshotStats{Segments: 2, Users: 2, Memberships: 2}, stats)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 8)
+	assert.Contains(t, lines[0], `{"type":"header","version":1,`)
+	assert.Equal(t, []string{
+		`{"type":"segment","name":"a","auto_percent":10,"description":"","owner":"team","tags":["x"],` +
+			`"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
+		`{"type":"segment","name":"b","auto_percent":0,"description":"","owner":"","tags":null,` +
+			`"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
+		`{"type":"user","id":1}`,
+		`{"type":"user","id":2}`,
+		`{"type":"membership","user_id":1,"segment":"a"}`,
+		`{"type":"membership","user_id":2,"segment":"a","expires_at":"2030-01-01T00:00:00Z"}`,
+		`{"type":"end","segments":2,"users":2,"memberships":2}`,
+	}, lines[1:])
+}
+
+func TestService_RestoreSnapshot(t *testing.T) {
+	ctx := context.Background()
+	snapshot := strings.Join([]string{
+		`{"type":"header","version":1,"created_at":"2023-09-01T00:00:00Z"}`,
+		`{"type":"segment","name":"a","auto_percent":10,"owner":"team","tags":["x"]}`,
+		`{"type":"segment","name":"b"}`,
+		`{"type":"user","id":1}`,
+		`{"type":"user","id":2}`,
+		`{"type":"membership","user_id":1,"segment":"a"}`,
+		`{"type":"membership","user_id":2,"segment":"a"}`,
+		`{"type":"membership","user_id":3,"segment":"a"}`,
+		`{"type":"membership","user_id":1,"segment":"b","expires_at":"2030-01-01T00:00:00Z"}`,
+		`{"type":"membership","user_id":2,"segment":"b","expires_at":"2023-01-01T00:00:00Z"}`,
+		`{"type":"end","segments":2,"users":2,"memberships":5}`,
+	}, "\n")
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("InTx", mock.Anything, mock.Anything).
+		Return(runInTx).
+		Once()
+	mockStorage.
+		On("ListSegments", mock.Anything, "", "", snapshotPageSize).
+		Return([]models.Segment{{Name: "old"}}, nil).
+		Once()
+	mockStorage.
+		On("DeleteSegment", mock.Anything, "old").
+		Return(nil).
+		Once()
+	mockStorage.
+		On("GetUserIDs", mock.Anything).
+		Return([]int{7}, nil).
+		Once()
+	mockStorage.
+		On("DeleteUser", mock.Anything, 7).
+		Return(nil).
+		Once()
+	mockStorage.
+		On("CreateSegment", mock.Anything,
+			models.Segment{Name: "a", AutoPercent: 10, Owner: "team", Tags: []string{"x"}}).
+		Return(nil).
+		Once()
+	mockStorage.
+		On("CreateSegment", mock.Anything, models.Segment{Name: "b"}).
+		Return(storage.ErrAlreadyExist).
+		Once()
+	mockStorage.
+		On("RestoreSegment", mock.Anything, "b").
+		Return(storage.ErrNotExist).
+		Once()
+	mockStorage.
+		On("CreateUser", mock.Anything, 1).
+		Return(nil).
+		Once()
+	mockStorage.
+		On("CreateUser", mock.Anything, 2).
+		Return(nil).
+		Once()
+	mockStorage.
+		On("AddUsersToSegment", mock.Anything, "a", []int{1, 2, 3}, (*time.Time)(nil)).
+		Return(models.BulkMembershipResult{Changed: []int{1, 2}, Missing: []int{3}}, nil).
+		Once()
+	mockStorage.
+		On("AddUsersToSegment", mock.Anything, "b", []int{1}, &expiresAt).
+		Return(models.BulkMembershipResult{Changed: []int{1}, Missing: []int{}}, nil).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	stats, err := service.RestoreSnapshot(ctx, strings.NewReader(snapshot), models.RestoreReplace)
+	require.NoError(t, err)
+	assert.Equal(t, models.SnapshotStats{Segments: 1, Users: 2, Memberships: 3, SkippedMemberships: 2}, stats)
+}
+
+func TestService_RestoreSnapshot_ReplaceKeepsArchived(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "a", Owner: "old"}))
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "old"}))
+	for _, id := range []int{1, 2} {
+		require.NoError(t, repo.CreateUser(ctx, id))
+	}
+	_, err := repo.AddUsersToSegment(ctx, "a", []int{1, 2}, nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddUserToSegment(ctx, 1, "old", nil))
+
+	snapshot := strings.Join([]string{
+		`{"type":"header","version":1}`,
+		`{"type":"segment","name":"a","owner":"team"}`,
+		`{"type":"segment","name":"b"}`,
+		`{"type":"user","id":1}`,
+		`{"type":"user","id":3}`,
+		`{"type":"membership","user_id":3,"segment":"a"}`,
+		`{"type":"membership","user_id":1,"segment":"b"}`,
+		`{"type":"end","segments":2,"users":2,"memberships":2}`,
+	}, "\n")
+	service := NewService(repo, logger.Discard())
+	stats, err := service.RestoreSnapshot(ctx, strings.NewReader(snapshot), models.RestoreReplace)
+	require.NoError(t, err)
+	assert.Equal(t, models.SnapshotStats{Segments: 2, Users: 2, Memberships: 2}, stats)
+
+	// Segments and users of the snapshot take its metadata and memberships.
+	segment, err := repo.GetSegment(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "team", segment.Owner)
+	members, err := repo.GetSegmentUsers(ctx, "a", nil, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, members)
+	user, err := repo.GetUser(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, user.Segments)
+
+	// Others are archived and are restored with their memberships.
+	_, err = repo.GetSegment(ctx, "old")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	require.NoError(t, service.RestoreSegment(ctx, "old"))
+	require.NoError(t, service.RestoreUser(ctx, 2))
+	members, err = repo.GetSegmentUsers(ctx, "old", nil, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, members)
+}
+
+func TestService_RestoreSnapshot_Invalid(t *testing.T) {
+	ctx := context.Background()
+	header := `{"type":"header","version":1}`
+	tests := []struct {
+		name     string
+		snapshot string
+	}{
+		{
+			name:     "no header",
+			snapshot: `{"type":"user","id":1}`,
+		},
+		{
+			name:     "unsupported version",
+			snapshot: `{"type":"header","version":2}`,
+		},
+		{
+			name:     "unknown record",
+			snapshot: header + "\n" + `{"type":"group"}`,
+		},
+		{
+			name:     "malformed record",
+			snapshot: header + "\n" + `{"type":"user","id":"x"}`,
+		},
+		{
+			name:     "truncated",
+			snapshot: header,
+		},
+		{
+			name:     "counts mismatch",
+			snapshot: header + "\n" + `{"type":"end","segments":1,"users":0,"memberships":0}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockStorage := mocks.NewSegmentStorage(t)
+			mockStorage.
+				On("InTx", mock.Anything, mock.Anything).
+				Return(runInTx).
+				Once()
+
+			service := NewService(mockStorage, logger.Discard())
+			_, err := service.RestoreSnapshot(ctx, strings.NewReader(test.snapshot), models.RestoreMerge)
+			assert.ErrorIs(t, err, ErrInvalidSnapshot)
+		})
+	}
+
+	service := NewService(mocks.NewSegmentStorage(t), logger.Discard())
+	_, err := service.RestoreSnapshot(ctx, strings.NewReader(header), "overwrite")
+	assert.Error(t, err)
+}
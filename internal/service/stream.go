@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+const (
+	// subscriberBuffer bounds the events waiting for a slow subscriber before it is dropped.
+	subscriberBuffer = 64
+	// listenRetryInterval is the delay before listening again after the broadcast connection failed.
+	listenRetryInterval = time.Second
+)
+
+// Subscription is a live stream of events selected by a filter.
+type Subscription struct {
+	// Missed are buffered events that followed the last event seen by the subscriber.
+	Missed []models.Event
+	// Reset is set if the last seen event is no longer buffered, so some events were lost
+	// and the subscriber has to reload the state.
+	Reset bool
+	// Events is closed if the subscriber falls behind or the subscriptions are closed on shutdown;
+	// it resumes by subscribing again.
+	Events <-chan models.Event
+}
+
+type subscriber struct {
+	filter models.EventFilter
+	events chan models.Event
+}
+
+// eventStream keeps the latest events broadcast to this app instance and fans them out to subscribers.
+type eventStream struct {
+	mu          sync.Mutex
+	size        int
+	buffer      []models.Event
+	subscribers map[*subscriber]struct{}
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{subscribers: make(map[*subscriber]struct{})}
+}
+
+// streamSink broadcasts published events to the event streams of all app instances.
+type streamSink struct {
+	repo SegmentStorage
+}
+
+// StreamSink returns the sink that feeds SubscribeEvents through RunOutboxRelay.
+func (s *Service) StreamSink() EventSink {
+	return streamSink{repo: s.repo}
+}
+
+func (s streamSink) Name() string {
+	return "stream"
+}
+
+func (s streamSink) Publish(ctx context.Context, events []models.Event) error {
+	return s.repo.NotifyEvents(ctx, events)
+}
+
+// RunEventStream receives broadcast events for the subscribers until ctx is done,
+// keeping the latest bufferSize of them for resumption.
+func (s *Service) RunEventStream(ctx context.Context, bufferSize int) {
+	s.stream.mu.Lock()
+	s.stream.size = bufferSize
+	s.stream.mu.Unlock()
+
+	for {
+		if err := s.repo.ListenEvents(ctx, s.stream.publish); err != nil {
+			s.log.ErrorContext(ctx, "listen events", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryInterval):
+		}
+	}
+}
+
+// SubscribeEvents streams events selected by filter. If lastEventID is set, buffered events that followed it
+// are returned as missed. The returned function ends the subscription.
+func (s *Service) SubscribeEvents(filter models.EventFilter, lastEventID string) (Subscription, func()) {
+	return s.stream.subscribe(filter, lastEventID)
+}
+
+// CloseSubscriptions ends all subscriptions, so that subscribers reconnect to another app instance
+// instead of holding up the shutdown.
+func (s *Service) CloseSubscriptions() {
+	s.stream.closeAll()
+}
+
+func (e *eventStream) subscribe(filter models.EventFilter, lastEventID string) (Subscription, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sub := &subscriber{filter: filter, events: make(chan models.Event, subscriberBuffer)}
+	e.subscribers[sub] = struct{}{}
+	subscription := Subscription{Events: sub.events}
+
+	if lastEventID != "" {
+		next := -1
+		for i := len(e.buffer) - 1; i >= 0; i-- {
+			if e.buffer[i].ID == lastEventID {
+				next = i + 1
+				break
+			}
+		}
+		if next < 0 {
+			subscription.Reset = true
+			next = len(e.buffer)
+		}
+		for _, event := range e.buffer[next:] {
+			if filter.Match(event) {
+				subscription.Missed = append(subscription.Missed, event)
+			}
+		}
+	}
+
+	return subscription, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subscribers[sub]; ok {
+			delete(e.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (e *eventStream) closeAll() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for sub := range e.subscribers {
+		delete(e.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish buffers the event and hands it to the subscribers; subscribers that fell behind are dropped.
+func (e *eventStream) publish(event models.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.size > 0 {
+		if len(e.buffer) == e.size {
+			e.buffer = e.buffer[1:]
+		}
+		e.buffer = append(e.buffer, event)
+	}
+
+	for sub := range e.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(e.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
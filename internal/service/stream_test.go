@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service/mocks"
+)
+
+func streamEvent(id int, userID int, segment string) models.Event {
+	return models.Event{
+		ID:      strconv.Itoa(id),
+		Type:    models.EventMembershipAdded,
+		UserID:  &userID,
+		Segment: segment,
+	}
+}
+
+func eventIDs(events []models.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestEventStream_Subscribe(t *testing.T) {
+	stream := newEventStream()
+	stream.size = 3
+	for i := 1; i <= 5; i++ {
+		stream.publish(streamEvent(i, i%2, "a"))
+	}
+	userID := 1
+
+	tests := []struct {
+		name        string
+		filter      models.EventFilter
+		lastEventID string
+		missed      []string
+		reset       bool
+	}{
+		{
+			name: "new subscriber",
+		},
+		{
+			name:        "resumed",
+			lastEventID: "3",
+			missed:      []string{"4", "5"},
+		},
+		{
+			name:        "resumed with filter",
+			filter:      models.EventFilter{UserID: &userID},
+			lastEventID: "3",
+			missed:      []string{"5"},
+		},
+		{
+			name:        "up to date",
+			lastEventID: "5",
+		},
+		{
+			name:        "last event is not buffered",
+			lastEventID: "1",
+			reset:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription, unsubscribe := stream.subscribe(test.filter, test.lastEventID)
+			defer unsubscribe()
+			if test.missed == nil {
+				assert.Empty(t, subscription.Missed)
+			} else {
+				assert.Equal(t, test.missed, eventIDs(subscription.Missed))
+			}
+			assert.Equal(t, test.reset, subscription.Reset)
+		})
+	}
+}
+
+func TestEventStream_Publish(t *testing.T) {
+	stream := newEventStream()
+	stream.size = 10
+
+	subscription, unsubscribe := stream.subscribe(models.EventFilter{Segments: []string{"a", "b"}}, "")
+	defer unsubscribe()
+	stream.publish(streamEvent(1, 1, "a"))
+	stream.publish(streamEvent(2, 1, "c"))
+	stream.publish(streamEvent(3, 2, "b"))
+
+	assert.Equal(t, "1", (<-subscription.Events).ID)
+	assert.Equal(t, "3", (<-subscription.Events).ID)
+
+	// A subscriber that falls behind is dropped.
+	for i := 0; i <= subscriberBuffer; i++ {
+		stream.publish(streamEvent(10+i, 1, "a"))
+	}
+	received := 0
+	for range subscription.Events {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+	assert.Len(t, stream.buffer, 10)
+}
+
+func TestEventStream_CloseAll(t *testing.T) {
+	stream := newEventStream()
+	subscription, unsubscribe := stream.subscribe(models.EventFilter{}, "")
+	stream.closeAll()
+	_, ok := <-subscription.Events
+	assert.False(t, ok)
+	assert.Empty(t, stream.subscribers)
+
+	// Unsubscribing after the subscription was closed is a no-op.
+	unsubscribe()
+}
+
+func TestService_RunEventStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("ListenEvents", mock.Anything, mock.Anything).
+		Return(nil).
+		Run(func(args mock.Arguments) {
+			handle := args.Get(1).(func(models.Event))
+			handle(streamEvent(1, 1, "a"))
+			cancel()
+		}).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	subscription, unsubscribe := service.SubscribeEvents(models.EventFilter{}, "")
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		service.RunEventStream(ctx, 10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event stream did not stop")
+	}
+
+	event := <-subscription.Events
+	assert.Equal(t, "1", event.ID)
+	resumed, unsubscribeResumed := service.SubscribeEvents(models.EventFilter{UserID: event.UserID}, "0")
+	defer unsubscribeResumed()
+	assert.True(t, resumed.Reset)
+	assert.Empty(t, resumed.Missed)
+}
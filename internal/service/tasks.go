@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errShuttingDown is returned for background tasks started after the service began to shut down.
+var errShuttingDown = errors.New("service is shutting down")
+
+// tasks runs background tasks that outlive the request starting them, such as imports,
+// so they can be cancelled and waited for before the storage they write to is closed.
+type tasks struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newTasks() *tasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &tasks{ctx: ctx, cancel: cancel}
+}
+
+// run runs the task in a goroutine with a context cancelled on shutdown.
+// It fails with errShuttingDown once stop was called.
+func (t *tasks) run(task func(ctx context.Context)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errShuttingDown
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		task(t.ctx)
+	}()
+	return nil
+}
+
+// stop cancels the running tasks and waits for them to return.
+func (t *tasks) stop() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.cancel()
+	t.wg.Wait()
+}
+
+// RunTasks blocks until ctx is done, then cancels imports still running and waits for them,
+// so they are stopped before the storage is closed.
+func (s *Service) RunTasks(ctx context.Context) {
+	<-ctx.Done()
+	s.tasks.stop()
+}
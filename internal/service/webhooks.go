@@ -0,0 +1,532 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// Headers of webhook deliveries. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed by the webhook secret, the timestamp is in Unix seconds.
+const (
+	EventHeader     = "X-Segmenter-Event"
+	DeliveryHeader  = "X-Segmenter-Delivery"
+	TimestampHeader = "X-Segmenter-Timestamp"
+	SignatureHeader = "X-Segmenter-Signature"
+)
+
+// WebhookOptions configures delivery of events to webhooks.
+type WebhookOptions struct {
+	// MaxAttempts is the number of attempts before an event is dead-lettered.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, it doubles with every attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds a single delivery request.
+	Timeout time.Duration
+	// RefreshInterval is how often webhooks changed by other instances are reloaded.
+	RefreshInterval time.Duration
+	// PollInterval is how often the delivery queue is read while it has no more due deliveries than BatchSize.
+	PollInterval time.Duration
+	// BatchSize bounds the number of deliveries taken from the queue at once.
+	BatchSize int
+}
+
+// webhookSink queues events for the subscribed webhooks, RunWebhookDelivery sends them.
+// Every round makes one attempt per due delivery, a failed one is retried in a later round after a backoff,
+// so an unreachable webhook holds back neither the outbox relay nor other webhooks.
+// An event that is not delivered after all attempts is dead-lettered.
+type webhookSink struct {
+	repo SegmentStorage
+	log  *slog.Logger
+	// queued wakes RunWebhookDelivery up when deliveries are queued.
+	queued chan struct{}
+
+	mu       sync.RWMutex
+	opts     WebhookOptions
+	client   *http.Client
+	webhooks []models.Webhook
+	loadedAt time.Time
+}
+
+func newWebhookSink(repo SegmentStorage, log *slog.Logger) *webhookSink {
+	return &webhookSink{repo: repo, log: log, queued: make(chan struct{}, 1), client: &http.Client{}}
+}
+
+// WebhookSink configures delivery of events to webhooks and returns the sink for RunOutboxRelay.
+func (s *Service) WebhookSink(opts WebhookOptions) EventSink {
+	s.webhooks.mu.Lock()
+	defer s.webhooks.mu.Unlock()
+	s.webhooks.opts = opts
+	s.webhooks.client = &http.Client{Timeout: opts.Timeout}
+	return s.webhooks
+}
+
+// CreateWebhook subscribes the URL to the events, all events if none are given.
+// A secret is generated if it is empty; the returned webhook is the only place it is shown.
+func (s *Service) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateWebhook")
+	defer span.End()
+
+	if webhook.Secret == "" {
+		secret, err := randomHex(32)
+		if err != nil {
+			return models.Webhook{}, err
+		}
+		webhook.Secret = secret
+	}
+
+	created, err := s.repo.CreateWebhook(ctx, webhook)
+	if err != nil {
+		s.log.ErrorContext(ctx, "create webhook", "url", webhook.URL, "error", err)
+		return models.Webhook{}, err
+	}
+	s.log.InfoContext(ctx, "webhook created", "webhook_id", created.ID, "url", created.URL)
+	s.webhooks.refresh()
+	return created, nil
+}
+
+// ListWebhooks returns the webhooks without their secrets.
+func (s *Service) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListWebhooks")
+	defer span.End()
+
+	webhooks, err := s.repo.ListWebhooks(ctx)
+	if err != nil {
+		s.log.ErrorContext(ctx, "list webhooks", "error", err)
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, id int) error {
+	ctx, span := tracer.Start(ctx, "Service.DeleteWebhook")
+	defer span.End()
+
+	if err := s.repo.DeleteWebhook(ctx, id); err != nil {
+		s.log.ErrorContext(ctx, "delete webhook", "webhook_id", id, "error", err)
+		return err
+	}
+	s.log.InfoContext(ctx, "webhook deleted", "webhook_id", id)
+	s.webhooks.refresh()
+	return nil
+}
+
+// ListDeadLetters returns events that were not delivered to the webhook.
+func (s *Service) ListDeadLetters(ctx context.Context, webhookID int) ([]models.DeadLetter, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListDeadLetters")
+	defer span.End()
+
+	letters, err := s.repo.ListDeadLetters(ctx, webhookID)
+	if err != nil {
+		s.log.ErrorContext(ctx, "list dead letters", "webhook_id", webhookID, "error", err)
+		return nil, err
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters queues events of the dead letters of the webhook for delivery again
+// with a fresh number of attempts and deletes the dead letters. It returns the number of replayed events.
+func (s *Service) ReplayDeadLetters(ctx context.Context, webhookID int) (int, error) {
+	ctx, span := tracer.Start(ctx, "Service.ReplayDeadLetters")
+	defer span.End()
+
+	var replayed int
+	err := s.repo.InTx(ctx, func(ctx context.Context) error {
+		letters, err := s.repo.ListDeadLetters(ctx, webhookID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, letter := range letters {
+			err = s.repo.AddWebhookDelivery(ctx, models.WebhookDelivery{
+				WebhookID:     webhookID,
+				Event:         letter.Event,
+				NextAttemptAt: now,
+			})
+			if err != nil {
+				return err
+			}
+			if err = s.repo.DeleteDeadLetter(ctx, letter.ID); err != nil {
+				return err
+			}
+		}
+		replayed = len(letters)
+		return nil
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "replay dead letters", "webhook_id", webhookID, "error", err)
+		return 0, err
+	}
+	if replayed > 0 {
+		s.webhooks.wake()
+		s.log.InfoContext(ctx, "dead letters replayed", "webhook_id", webhookID, "events", replayed)
+	}
+	return replayed, nil
+}
+
+// Name implements EventSink.
+func (d *webhookSink) Name() string {
+	return "webhook"
+}
+
+// Publish queues the events for the subscribed webhooks in a single transaction without sending them.
+func (d *webhookSink) Publish(ctx context.Context, events []models.Event) error {
+	webhooks, err := d.load(ctx)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	queued := false
+	now := time.Now()
+	err = d.repo.InTx(ctx, func(ctx context.Context) error {
+		for _, event := range events {
+			for _, webhook := range webhooks {
+				if !webhook.Subscribed(event.Type) {
+					continue
+				}
+				err := d.repo.AddWebhookDelivery(ctx, models.WebhookDelivery{
+					WebhookID:     webhook.ID,
+					Event:         event,
+					NextAttemptAt: now,
+				})
+				// A webhook deleted meanwhile needs no delivery.
+				if errors.Is(err, storage.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("queue event %s for webhook %d: %w", event.ID, webhook.ID, err)
+				}
+				queued = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if queued {
+		d.wake()
+	}
+	return nil
+}
+
+// wake makes RunWebhookDelivery read the queue without waiting for the poll interval.
+func (d *webhookSink) wake() {
+	select {
+	case d.queued <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhookDelivery sends events queued for webhooks until ctx is done.
+// Deliveries of a webhook are sent in the order they were queued. Once one fails, the rest of the round's
+// deliveries to the webhook wait for its retry, so an unreachable webhook costs one request a round.
+// Deliveries are shared between app instances: a taken delivery is hidden from others until its attempt
+// is recorded, or for BatchSize request timeouts if the instance stops before that.
+func (s *Service) RunWebhookDelivery(ctx context.Context) {
+	d := s.webhooks
+	failures := 0
+	for {
+		d.mu.RLock()
+		opts := d.opts
+		d.mu.RUnlock()
+
+		delay := opts.PollInterval
+		taken, err := d.deliverBatch(ctx, opts)
+		switch {
+		case err != nil:
+			failures++
+			delay = backoff(opts.InitialBackoff, opts.MaxBackoff, failures)
+			s.log.ErrorContext(ctx, "deliver webhooks", "error", err)
+		case taken == opts.BatchSize:
+			// More deliveries are likely due.
+			failures = 0
+			delay = 0
+		default:
+			failures = 0
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.queued:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverBatch takes due deliveries from the queue and makes one attempt for each, webhooks concurrently.
+// It returns the number of deliveries taken.
+func (d *webhookSink) deliverBatch(ctx context.Context, opts WebhookOptions) (int, error) {
+	now := time.Now()
+	lockedUntil := now.Add(time.Duration(opts.BatchSize) * opts.Timeout)
+	deliveries, err := d.repo.ClaimWebhookDeliveries(ctx, now, lockedUntil, opts.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("claim deliveries: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	webhooks, err := d.webhooksByID(ctx, deliveries)
+	if err != nil {
+		// Outcomes are recorded even if ctx is done, so deliveries are not held until the lock expires.
+		return len(deliveries), errors.Join(err, d.postpone(context.WithoutCancel(ctx), deliveries, now))
+	}
+
+	order := make([]int, 0)
+	byWebhook := make(map[int][]models.WebhookDelivery)
+	for _, delivery := range deliveries {
+		if _, ok := byWebhook[delivery.WebhookID]; !ok {
+			order = append(order, delivery.WebhookID)
+		}
+		byWebhook[delivery.WebhookID] = append(byWebhook[delivery.WebhookID], delivery)
+	}
+
+	errs := make([]error, len(order))
+	var wg sync.WaitGroup
+	for i, webhookID := range order {
+		i, webhookID := i, webhookID
+		webhook, ok := webhooks[webhookID]
+		if !ok {
+			// The webhook was deleted with its deliveries meanwhile.
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = d.deliverWebhook(ctx, opts, webhook, byWebhook[webhookID])
+		}()
+	}
+	wg.Wait()
+	return len(deliveries), errors.Join(errs...)
+}
+
+// webhooksByID returns the webhooks of the deliveries, reloading them if some were created after the last load.
+func (d *webhookSink) webhooksByID(
+	ctx context.Context, deliveries []models.WebhookDelivery,
+) (map[int]models.Webhook, error) {
+	for reloaded := false; ; reloaded = true {
+		webhooks, err := d.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[int]models.Webhook, len(webhooks))
+		for _, webhook := range webhooks {
+			byID[webhook.ID] = webhook
+		}
+		missing := false
+		for _, delivery := range deliveries {
+			if _, ok := byID[delivery.WebhookID]; !ok {
+				missing = true
+			}
+		}
+		if !missing || reloaded {
+			return byID, nil
+		}
+		d.refresh()
+	}
+}
+
+// deliverWebhook sends the deliveries of the webhook one by one until one fails.
+// The deliveries left are postponed to the retry of the failed one without using their attempts.
+func (d *webhookSink) deliverWebhook(
+	ctx context.Context, opts WebhookOptions, webhook models.Webhook, deliveries []models.WebhookDelivery,
+) error {
+	d.mu.RLock()
+	client := d.client
+	d.mu.RUnlock()
+
+	// Outcomes are recorded even if ctx is done, so deliveries are not held until the lock expires.
+	// A started request is completed on shutdown, it is bounded by the client timeout.
+	record := context.WithoutCancel(ctx)
+	for i, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return d.postpone(record, deliveries[i:], time.Now())
+		}
+		err := send(record, client, webhook, delivery.Event)
+		if err == nil {
+			err = d.repo.DeleteWebhookDelivery(record, delivery.ID)
+			if err != nil && !errors.Is(err, storage.ErrNotExist) {
+				return fmt.Errorf("delete delivery %d: %w", delivery.ID, err)
+			}
+			continue
+		}
+
+		retryAt, err := d.fail(record, opts, webhook, delivery, err)
+		if err != nil {
+			return err
+		}
+		return d.postpone(record, deliveries[i+1:], retryAt)
+	}
+	return nil
+}
+
+// fail records the failed attempt of the delivery. The delivery is retried after a backoff,
+// or dead-lettered once it used all attempts. It returns the time the webhook is attempted again.
+func (d *webhookSink) fail(
+	ctx context.Context, opts WebhookOptions, webhook models.Webhook, delivery models.WebhookDelivery, reason error,
+) (time.Time, error) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastError = reason.Error()
+	if delivery.Attempts >= opts.MaxAttempts {
+		err := d.repo.InTx(ctx, func(ctx context.Context) error {
+			if err := d.deadLetter(ctx, webhook, delivery.Event, delivery.Attempts, reason); err != nil {
+				return err
+			}
+			return d.repo.DeleteWebhookDelivery(ctx, delivery.ID)
+		})
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return now, err
+		}
+		return now, nil
+	}
+
+	d.log.WarnContext(ctx, "deliver event",
+		"event_id", delivery.Event.ID, "webhook_id", webhook.ID, "attempt", delivery.Attempts, "error", reason)
+	delivery.NextAttemptAt = now.Add(backoff(opts.InitialBackoff, opts.MaxBackoff, delivery.Attempts))
+	err := d.repo.UpdateWebhookDelivery(ctx, delivery)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return now, fmt.Errorf("update delivery %d: %w", delivery.ID, err)
+	}
+	return delivery.NextAttemptAt, nil
+}
+
+// postpone makes the deliveries due at the given time keeping their attempts.
+func (d *webhookSink) postpone(ctx context.Context, deliveries []models.WebhookDelivery, at time.Time) error {
+	for _, delivery := range deliveries {
+		delivery.NextAttemptAt = at
+		err := d.repo.UpdateWebhookDelivery(ctx, delivery)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return fmt.Errorf("update delivery %d: %w", delivery.ID, err)
+		}
+	}
+	return nil
+}
+
+// refresh makes the next delivery reload the webhooks.
+func (d *webhookSink) refresh() {
+	d.mu.Lock()
+	d.loadedAt = time.Time{}
+	d.mu.Unlock()
+}
+
+// load returns the webhooks, reloading them once they are older than RefreshInterval.
+func (d *webhookSink) load(ctx context.Context) ([]models.Webhook, error) {
+	d.mu.RLock()
+	webhooks, loadedAt, interval := d.webhooks, d.loadedAt, d.opts.RefreshInterval
+	d.mu.RUnlock()
+	if !loadedAt.IsZero() && time.Since(loadedAt) < interval {
+		return webhooks, nil
+	}
+
+	webhooks, err := d.repo.ListWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load webhooks: %w", err)
+	}
+	d.mu.Lock()
+	d.webhooks = webhooks
+	d.loadedAt = time.Now()
+	d.mu.Unlock()
+	return webhooks, nil
+}
+
+// deadLetter saves the undelivered event. A webhook deleted meanwhile needs no dead letter.
+func (d *webhookSink) deadLetter(
+	ctx context.Context, webhook models.Webhook, event models.Event, attempts int, reason error,
+) error {
+	err := d.repo.AddDeadLetter(ctx, models.DeadLetter{
+		WebhookID: webhook.ID,
+		Event:     event,
+		Attempts:  attempts,
+		LastError: reason.Error(),
+		FailedAt:  time.Now(),
+	})
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("dead-letter event %s of webhook %d: %w", event.ID, webhook.ID, err)
+	}
+	d.log.ErrorContext(ctx, "event not delivered", "event_id", event.ID, "webhook_id", webhook.ID, "error", reason)
+	return nil
+}
+
+// send posts the signed event to the webhook. Any status other than 2xx is an error.
+func send(ctx context.Context, client *http.Client, webhook models.Webhook, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(DeliveryHeader, event.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of a delivery body sent at timestamp, receivers compute it to verify deliveries.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before the retry that follows the attempt.
+func backoff(initial, maxDelay time.Duration, attempt int) time.Duration {
+	delay := initial
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
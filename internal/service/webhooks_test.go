@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service/mocks"
+	"github.com/iTcatt/segmenter/internal/storage"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+)
+
+var testWebhookOptions = WebhookOptions{
+	MaxAttempts:     3,
+	InitialBackoff:  time.Millisecond,
+	MaxBackoff:      5 * time.Millisecond,
+	Timeout:         time.Second,
+	RefreshInterval: time.Hour,
+	PollInterval:    time.Hour,
+	BatchSize:       10,
+}
+
+// queued returns the deliveries waiting in the queue without changing them.
+func queued(t *testing.T, repo *memory.Storage) []models.WebhookDelivery {
+	deliveries := make([]models.WebhookDelivery, 0)
+	err := repo.InTx(context.Background(), func(ctx context.Context) error {
+		var err error
+		deliveries, err = repo.ClaimWebhookDeliveries(ctx, time.Now().Add(time.Hour), time.Now(), 100)
+		if err == nil {
+			err = errors.New("rollback")
+		}
+		return err
+	})
+	require.EqualError(t, err, "rollback")
+	return deliveries
+}
+
+func TestWebhookSink_Publish(t *testing.T) {
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("ListWebhooks", mock.Anything).
+		Return([]models.Webhook{
+			{ID: 1, URL: "http://a", Events: []models.EventType{models.EventUserDeleted}},
+			{ID: 2, URL: "http://b", Events: []models.EventType{models.EventUserCreated}},
+			{ID: 3, URL: "http://c"},
+		}, nil).
+		Once()
+	mockStorage.
+		On("InTx", mock.Anything, mock.Anything).
+		Return(runInTx).
+		Once()
+	for _, webhookID := range []int{1, 3} {
+		webhookID := webhookID
+		mockStorage.
+			On("AddWebhookDelivery", mock.Anything, mock.MatchedBy(func(delivery models.WebhookDelivery) bool {
+				return delivery.WebhookID == webhookID && delivery.Event.ID == "1" &&
+					delivery.Attempts == 0 && !delivery.NextAttemptAt.IsZero()
+			})).
+			Return(nil).
+			Once()
+	}
+
+	userID := 1000
+	sink := NewService(mockStorage, logger.Discard()).WebhookSink(testWebhookOptions)
+	err := sink.Publish(context.Background(), []models.Event{
+		{ID: "1", Type: models.EventUserDeleted, UserID: &userID},
+	})
+	require.NoError(t, err)
+}
+
+func TestWebhookSink_DeliverBatch(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	delivered := make([]delivery, 0)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, delivery{header: r.Header, body: body})
+	}))
+	defer up.Close()
+	var requests atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	service := NewService(repo, logger.Discard())
+	sink := service.WebhookSink(testWebhookOptions)
+	upHook, err := service.CreateWebhook(ctx, models.Webhook{URL: up.URL, Secret: "secret"})
+	require.NoError(t, err)
+	downHook, err := service.CreateWebhook(ctx, models.Webhook{URL: down.URL})
+	require.NoError(t, err)
+
+	userID := 1000
+	events := []models.Event{
+		{ID: "1", Type: models.EventUserCreated, UserID: &userID},
+		{ID: "2", Type: models.EventMembershipAdded, UserID: &userID, Segment: "AVITO_VOICE_MESSAGES"},
+		{ID: "3", Type: models.EventUserDeleted, UserID: &userID},
+	}
+	require.NoError(t, sink.Publish(ctx, events))
+	assert.Len(t, queued(t, repo), 6, "events are queued, not sent")
+	assert.Empty(t, delivered)
+
+	taken, err := service.webhooks.deliverBatch(ctx, testWebhookOptions)
+	require.NoError(t, err)
+	assert.Equal(t, 6, taken)
+
+	// The webhook that is up gets all events in order.
+	require.Len(t, delivered, 3)
+	for i, d := range delivered {
+		assert.Equal(t, events[i].ID, d.header.Get(DeliveryHeader))
+		assert.Equal(t, string(events[i].Type), d.header.Get(EventHeader))
+		assert.Equal(t, "application/json", d.header.Get("Content-Type"))
+		assert.Equal(t, Sign("secret", d.header.Get(TimestampHeader), d.body), d.header.Get(SignatureHeader))
+		var event models.Event
+		require.NoError(t, json.Unmarshal(d.body, &event))
+		assert.Equal(t, events[i].ID, event.ID)
+	}
+
+	// The webhook that is down gets one request a round, the rest wait for the retry.
+	assert.Equal(t, int32(1), requests.Load())
+	left := queued(t, repo)
+	require.Len(t, left, 3)
+	assert.Equal(t, 1, left[0].Attempts)
+	assert.Equal(t, "unexpected status 503", left[0].LastError)
+	for _, d := range left {
+		assert.Equal(t, downHook.ID, d.WebhookID)
+		assert.Equal(t, left[0].NextAttemptAt, d.NextAttemptAt)
+	}
+	assert.Equal(t, 0, left[1].Attempts)
+
+	// Every event is dead-lettered after all attempts.
+	require.Eventually(t, func() bool {
+		_, err := service.webhooks.deliverBatch(ctx, testWebhookOptions)
+		require.NoError(t, err)
+		return len(queued(t, repo)) == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(3*testWebhookOptions.MaxAttempts), requests.Load())
+	letters, err := service.ListDeadLetters(ctx, downHook.ID)
+	require.NoError(t, err)
+	require.Len(t, letters, 3)
+	for i, letter := range letters {
+		assert.Equal(t, events[i].ID, letter.Event.ID)
+		assert.Equal(t, testWebhookOptions.MaxAttempts, letter.Attempts)
+		assert.Equal(t, "unexpected status 503", letter.LastError)
+	}
+	letters, err = service.ListDeadLetters(ctx, upHook.ID)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestWebhookSink_DeliverBatch_StorageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	mockStorage := mocks.NewSegmentStorage(t)
+	mockStorage.
+		On("ClaimWebhookDeliveries", mock.Anything, mock.Anything, mock.Anything, testWebhookOptions.BatchSize).
+		Return([]models.WebhookDelivery{{ID: 1, WebhookID: 1, Event: models.Event{ID: "1"}}}, nil).
+		Once()
+	mockStorage.
+		On("ListWebhooks", mock.Anything).
+		Return([]models.Webhook{{ID: 1, URL: server.URL}}, nil).
+		Once()
+	mockStorage.
+		On("UpdateWebhookDelivery", mock.Anything, mock.Anything).
+		Return(errors.New("unexpected error")).
+		Once()
+
+	service := NewService(mockStorage, logger.Discard())
+	service.WebhookSink(testWebhookOptions)
+	_, err := service.webhooks.deliverBatch(context.Background(), testWebhookOptions)
+	assert.Error(t, err)
+}
+
+func TestService_RunWebhookDelivery(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(DeliveryHeader)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := memory.NewStorage()
+	service := NewService(repo, logger.Discard())
+	sink := service.WebhookSink(testWebhookOptions)
+	_, err := service.CreateWebhook(ctx, models.Webhook{URL: server.URL})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.RunWebhookDelivery(ctx)
+	}()
+
+	// Queued events are sent at once rather than after the poll interval.
+	require.NoError(t, sink.Publish(ctx, []models.Event{{ID: "1", Type: models.EventSegmentCreated, Segment: "a"}}))
+	select {
+	case id := <-received:
+		assert.Equal(t, "1", id)
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestService_ReplayDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	service := NewService(repo, logger.Discard())
+	service.WebhookSink(testWebhookOptions)
+	webhook, err := service.CreateWebhook(ctx, models.Webhook{URL: "http://localhost"})
+	require.NoError(t, err)
+
+	userID := 1000
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, repo.AddDeadLetter(ctx, models.DeadLetter{
+			WebhookID: webhook.ID,
+			Event:     models.Event{ID: id, Type: models.EventUserCreated, UserID: &userID},
+			Attempts:  3,
+			LastError: "timeout",
+		}))
+	}
+
+	replayed, err := service.ReplayDeadLetters(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+
+	letters, err := service.ListDeadLetters(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+	deliveries := queued(t, repo)
+	require.Len(t, deliveries, 2)
+	for i, id := range []string{"a", "b"} {
+		assert.Equal(t, id, deliveries[i].Event.ID)
+		assert.Equal(t, 0, deliveries[i].Attempts, "replayed events get a fresh number of attempts")
+	}
+
+	_, err = service.ReplayDeadLetters(ctx, webhook.ID+1)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func TestBackoff(t *testing.T) {
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}
+	for i, delay := range expected {
+		assert.Equal(t, delay, backoff(time.Second, 10*time.Second, i+1), "attempt %d", i+1)
+	}
+}
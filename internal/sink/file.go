@@ -0,0 +1,59 @@
+// Package sink has the event sinks the outbox relay publishes to besides webhooks.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// File writes events as JSON lines to a file or to stdout.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFile opens the file at path for appending, "-" writes to stdout.
+func NewFile(path string) (*File, error) {
+	file := os.Stdout
+	if path != "-" {
+		var err error
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &File{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+// Publish writes the events and syncs the file, so they are on disk once it returns.
+func (f *File) Publish(_ context.Context, events []models.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, event := range events {
+		if err := f.enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	if f.file == os.Stdout {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file, stdout is left open.
+func (f *File) Close() error {
+	if f.file == os.Stdout {
+		return nil
+	}
+	return f.file.Close()
+}
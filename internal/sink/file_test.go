@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+func TestFile_Publish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	userID := 1000
+	events := []models.Event{
+		{ID: "1", Type: models.EventUserCreated, UserID: &userID},
+		{ID: "2", Type: models.EventMembershipAdded, UserID: &userID, Segment: "AVITO_VOICE_MESSAGES"},
+	}
+
+	// Events are appended to what the file already has.
+	for i := range events {
+		file, err := NewFile(path)
+		require.NoError(t, err)
+		require.NoError(t, file.Publish(context.Background(), events[i:i+1]))
+		require.NoError(t, file.Close())
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	written := make([]models.Event, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event models.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		written = append(written, event)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, events, written)
+}
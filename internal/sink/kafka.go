@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// Kafka headers of published events.
+const (
+	EventHeader = "event"
+	IDHeader    = "id"
+)
+
+// Kafka writes events to a topic of a broker speaking the Kafka protocol.
+// Messages are keyed by the event key, so events of a user land in one partition in order.
+type Kafka struct {
+	writer *kafka.Writer
+}
+
+func NewKafka(brokers []string, topic string) *Kafka {
+	return &Kafka{writer: &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: true,
+	}}
+}
+
+func (k *Kafka) Name() string {
+	return "kafka"
+}
+
+// Publish writes the events and waits until the broker acknowledges them.
+func (k *Kafka) Publish(ctx context.Context, events []models.Event) error {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(event.Key()),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: EventHeader, Value: []byte(event.Type)},
+				{Key: IDHeader, Value: []byte(event.ID)},
+			},
+		})
+	}
+	return k.writer.WriteMessages(ctx, messages...)
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,349 @@
+// Package cache wraps a storage with a read-through cache of users and their segments.
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+)
+
+// Cache stores users with their segments by user ID. Implementations are safe for concurrent use
+// and expire entries themselves, no later than the NextExpiry of the user; a Redis backend serializes users on its own.
+type Cache interface {
+	Get(ctx context.Context, id int) (models.User, bool, error)
+	Set(ctx context.Context, user models.User) error
+	Delete(ctx context.Context, ids ...int) error
+	Clear(ctx context.Context) error
+}
+
+// Stats counts lookups of users by their outcome.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// Storage serves user lookups from the cache and invalidates it on every change of users
+// and their memberships. Changes of a single user drop its entry, changes of a whole segment
+// drop all entries. Entries expire with the first membership of the user, so expired memberships are not served.
+// Changes made by other app instances are dropped by RunInvalidation once their events are broadcast.
+type Storage struct {
+	service.SegmentStorage
+
+	cache       Cache
+	log         *slog.Logger
+	generations generations
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+}
+
+// generationStripes bounds the memory taken by generations: users share a stripe,
+// so a change of one user keeps loads of the others in the stripe from being cached.
+const generationStripes = 1024
+
+// generations counts invalidations by user stripe and of the whole cache. A user loaded from the storage
+// is cached only if its generation did not change during the load, the loaded state may predate the change.
+type generations struct {
+	users [generationStripes]atomic.Uint64
+	all   atomic.Uint64
+}
+
+// get returns the generation of the user, it changes with every invalidation of the user or of all users.
+func (g *generations) get(id int) uint64 {
+	return g.users[uint(id)%generationStripes].Load() + g.all.Load()
+}
+
+func (g *generations) next(ids ...int) {
+	for _, id := range ids {
+		g.users[uint(id)%generationStripes].Add(1)
+	}
+}
+
+// listenRetryInterval is the delay before listening again after the broadcast connection failed.
+const listenRetryInterval = time.Second
+
+func NewStorage(repo service.SegmentStorage, cache Cache, log *slog.Logger) *Storage {
+	return &Storage{
+		SegmentStorage: repo,
+		cache:          cache,
+		log:            log,
+	}
+}
+
+// Stats returns the number of cache hits and misses since the start.
+func (s *Storage) Stats() Stats {
+	return Stats{Hits: s.hits.Load(), Misses: s.misses.Load()}
+}
+
+// RunInvalidation drops entries changed by any app instance as their events are broadcast, until ctx is done.
+// Events of a user drop its entry, events of a segment drop all entries. Events broadcast while the connection
+// is down are lost, so the cache is cleared before listening again. Renames broadcast no events,
+// other instances see them once the entries expire.
+func (s *Storage) RunInvalidation(ctx context.Context) {
+	for {
+		if err := s.SegmentStorage.ListenEvents(ctx, func(event models.Event) {
+			if event.UserID != nil {
+				s.delete(ctx, *event.UserID)
+			} else {
+				s.clear(ctx)
+			}
+		}); err != nil {
+			s.log.ErrorContext(ctx, "listen events", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryInterval):
+		}
+		s.clear(ctx)
+	}
+}
+
+type txKey struct{}
+
+// invalidation collects entries dropped within a transaction to drop them again after it ends.
+type invalidation struct {
+	mu    sync.Mutex
+	ids   []int
+	clear bool
+}
+
+// InTx runs fn in a transaction of the wrapped storage. Reads within it bypass the cache,
+// and entries dropped within it are dropped again once it ends: a concurrent read may have cached
+// the state before the commit.
+func (s *Storage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*invalidation); ok {
+		return s.SegmentStorage.InTx(ctx, fn)
+	}
+
+	tx := &invalidation{}
+	err := s.SegmentStorage.InTx(context.WithValue(ctx, txKey{}, tx), fn)
+	if tx.clear {
+		s.clear(ctx)
+	} else if len(tx.ids) > 0 {
+		s.delete(ctx, tx.ids...)
+	}
+	return err
+}
+
+func (s *Storage) GetUser(ctx context.Context, id int) (models.User, error) {
+	if inTx(ctx) {
+		return s.SegmentStorage.GetUser(ctx, id)
+	}
+
+	user, ok, err := s.cache.Get(ctx, id)
+	if err != nil {
+		s.log.ErrorContext(ctx, "get user from cache", "user_id", id, "error", err)
+	}
+	if ok {
+		s.hits.Add(1)
+		return user, nil
+	}
+	s.misses.Add(1)
+
+	generation := s.generations.get(id)
+	user, err = s.SegmentStorage.GetUser(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+	s.fill(ctx, user, generation)
+	return user, nil
+}
+
+// GetUsersSegments serves cached users from the cache and looks up the rest in the storage at once.
+func (s *Storage) GetUsersSegments(ctx context.Context, userIDs []int) (models.UsersSegments, error) {
+	if inTx(ctx) {
+		return s.SegmentStorage.GetUsersSegments(ctx, userIDs)
+	}
+
+	segments := make(map[int][]string, len(userIDs))
+	nextExpiry := make(map[int]time.Time)
+	uncached := make([]int, 0, len(userIDs))
+	for _, id := range distinct(userIDs) {
+		user, ok, err := s.cache.Get(ctx, id)
+		if err != nil {
+			s.log.ErrorContext(ctx, "get user from cache", "user_id", id, "error", err)
+		}
+		if !ok {
+			s.misses.Add(1)
+			uncached = append(uncached, id)
+			continue
+		}
+		s.hits.Add(1)
+		segments[id] = user.Segments
+		if user.NextExpiry != nil {
+			nextExpiry[id] = *user.NextExpiry
+		}
+	}
+	if len(uncached) == 0 {
+		return models.UsersSegments{Segments: segments, Missing: []int{}, NextExpiry: nextExpiry}, nil
+	}
+
+	generations := make(map[int]uint64, len(uncached))
+	for _, id := range uncached {
+		generations[id] = s.generations.get(id)
+	}
+	result, err := s.SegmentStorage.GetUsersSegments(ctx, uncached)
+	if err != nil {
+		return models.UsersSegments{}, err
+	}
+	for id, userSegments := range result.Segments {
+		user := models.User{ID: id, Segments: userSegments}
+		if expiresAt, ok := result.NextExpiry[id]; ok {
+			user.NextExpiry = &expiresAt
+			nextExpiry[id] = expiresAt
+		}
+		s.fill(ctx, user, generations[id])
+		segments[id] = userSegments
+	}
+	result.Segments = segments
+	result.NextExpiry = nextExpiry
+	return result, nil
+}
+
+func (s *Storage) CreateUser(ctx context.Context, id int) error {
+	defer s.invalidate(ctx, id)
+	return s.SegmentStorage.CreateUser(ctx, id)
+}
+
+func (s *Storage) AddUserToSegment(ctx context.Context, userID int, segment string, expiresAt *time.Time) error {
+	defer s.invalidate(ctx, userID)
+	return s.SegmentStorage.AddUserToSegment(ctx, userID, segment, expiresAt)
+}
+
+func (s *Storage) AddUsersToSegment(
+	ctx context.Context, segment string, userIDs []int, expiresAt *time.Time,
+) (models.BulkMembershipResult, error) {
+	defer s.invalidate(ctx, userIDs...)
+	return s.SegmentStorage.AddUsersToSegment(ctx, segment, userIDs, expiresAt)
+}
+
+func (s *Storage) DeleteUsersFromSegment(
+	ctx context.Context, segment string, userIDs []int,
+) (models.BulkMembershipResult, error) {
+	defer s.invalidate(ctx, userIDs...)
+	return s.SegmentStorage.DeleteUsersFromSegment(ctx, segment, userIDs)
+}
+
+func (s *Storage) DeleteUserFromSegment(ctx context.Context, userID int, segment string) error {
+	defer s.invalidate(ctx, userID)
+	return s.SegmentStorage.DeleteUserFromSegment(ctx, userID, segment)
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id int) error {
+	defer s.invalidate(ctx, id)
+	return s.SegmentStorage.DeleteUser(ctx, id)
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id int) error {
+	defer s.invalidate(ctx, id)
+	return s.SegmentStorage.RestoreUser(ctx, id)
+}
+
+func (s *Storage) DeleteExpiredSegments(ctx context.Context) ([]models.HistoryRecord, error) {
+	records, err := s.SegmentStorage.DeleteExpiredSegments(ctx)
+	ids := make([]int, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.UserID)
+	}
+	s.invalidate(ctx, ids...)
+	return records, err
+}
+
+func (s *Storage) RenameSegment(ctx context.Context, name, newName string) (models.Segment, error) {
+	defer s.invalidateAll(ctx)
+	return s.SegmentStorage.RenameSegment(ctx, name, newName)
+}
+
+func (s *Storage) MergeSegments(ctx context.Context, target string, sources []string) ([]int, error) {
+	defer s.invalidateAll(ctx)
+	return s.SegmentStorage.MergeSegments(ctx, target, sources)
+}
+
+func (s *Storage) DeleteSegment(ctx context.Context, name string) error {
+	defer s.invalidateAll(ctx)
+	return s.SegmentStorage.DeleteSegment(ctx, name)
+}
+
+func (s *Storage) RestoreSegment(ctx context.Context, name string) error {
+	defer s.invalidateAll(ctx)
+	return s.SegmentStorage.RestoreSegment(ctx, name)
+}
+
+// invalidate drops the users from the cache, and once more after the transaction of ctx ends.
+func (s *Storage) invalidate(ctx context.Context, ids ...int) {
+	if len(ids) == 0 {
+		return
+	}
+	if tx, ok := ctx.Value(txKey{}).(*invalidation); ok {
+		tx.mu.Lock()
+		tx.ids = append(tx.ids, ids...)
+		tx.mu.Unlock()
+	}
+	s.delete(ctx, ids...)
+}
+
+// invalidateAll drops all users from the cache, and once more after the transaction of ctx ends.
+func (s *Storage) invalidateAll(ctx context.Context) {
+	if tx, ok := ctx.Value(txKey{}).(*invalidation); ok {
+		tx.mu.Lock()
+		tx.clear = true
+		tx.mu.Unlock()
+	}
+	s.clear(ctx)
+}
+
+// fill caches the user loaded from the storage at the generation unless the user was invalidated since.
+// An invalidation between the check and Set is caught by the check after it, the entry is dropped then.
+func (s *Storage) fill(ctx context.Context, user models.User, generation uint64) {
+	if s.generations.get(user.ID) != generation {
+		return
+	}
+	if err := s.cache.Set(ctx, user); err != nil {
+		s.log.ErrorContext(ctx, "cache user", "user_id", user.ID, "error", err)
+		return
+	}
+	if s.generations.get(user.ID) != generation {
+		if err := s.cache.Delete(context.WithoutCancel(ctx), user.ID); err != nil {
+			s.log.ErrorContext(ctx, "drop users from cache", "users", 1, "error", err)
+		}
+	}
+}
+
+// delete drops the users from the cache. The generation is advanced first, so a load that read
+// the old state does not cache it. Cache failures are logged: the storage stays available while entries expire on their own.
+func (s *Storage) delete(ctx context.Context, ids ...int) {
+	s.generations.next(ids...)
+	if err := s.cache.Delete(context.WithoutCancel(ctx), ids...); err != nil {
+		s.log.ErrorContext(ctx, "drop users from cache", "users", len(ids), "error", err)
+	}
+}
+
+// clear drops all users from the cache like delete.
+func (s *Storage) clear(ctx context.Context) {
+	s.generations.all.Add(1)
+	if err := s.cache.Clear(context.WithoutCancel(ctx)); err != nil {
+		s.log.ErrorContext(ctx, "clear cache", "error", err)
+	}
+}
+
+func distinct(ids []int) []int {
+	seen := make(map[int]struct{}, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func inTx(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*invalidation)
+	return ok
+}
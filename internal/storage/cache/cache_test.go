@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+	"github.com/iTcatt/segmenter/internal/storage/storagetest"
+)
+
+// The conformance suite reads users right after changing them, so a stale cache fails it.
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) service.SegmentStorage {
+		return NewStorage(memory.NewStorage(), NewLRU(100, time.Hour), logger.Discard())
+	})
+}
+
+func TestStorage_GetUser(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_VOICE_MESSAGES"}))
+	require.NoError(t, repo.CreateUser(ctx, 1000))
+	s := NewStorage(repo, NewLRU(100, time.Hour), logger.Discard())
+
+	for i := 0; i < 3; i++ {
+		user, err := s.GetUser(ctx, 1000)
+		require.NoError(t, err)
+		assert.Empty(t, user.Segments)
+	}
+	assert.Equal(t, Stats{Hits: 2, Misses: 1}, s.Stats())
+
+	// A change behind the cache is not seen until the entry is dropped.
+	require.NoError(t, repo.AddUserToSegment(ctx, 1000, "AVITO_VOICE_MESSAGES", nil))
+	user, err := s.GetUser(ctx, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, user.Segments)
+
+	_, err = s.RenameSegment(ctx, "AVITO_VOICE_MESSAGES", "AVITO_VOICE_CALLS")
+	require.NoError(t, err)
+	user, err = s.GetUser(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_VOICE_CALLS"}, user.Segments)
+	assert.Equal(t, Stats{Hits: 3, Misses: 2}, s.Stats())
+}
+
+func TestStorage_GetUsersSegments(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_VOICE_MESSAGES"}))
+	require.NoError(t, repo.CreateUser(ctx, 1000))
+	require.NoError(t, repo.CreateUser(ctx, 1002))
+	require.NoError(t, repo.AddUserToSegment(ctx, 1002, "AVITO_VOICE_MESSAGES", nil))
+	s := NewStorage(repo, NewLRU(100, time.Hour), logger.Discard())
+
+	_, err := s.GetUser(ctx, 1000)
+	require.NoError(t, err)
+
+	result, err := s.GetUsersSegments(ctx, []int{1000, 1002, 1004, 1002})
+	require.NoError(t, err)
+	assert.Equal(t, map[int][]string{1000: {}, 1002: {"AVITO_VOICE_MESSAGES"}}, result.Segments)
+	assert.Equal(t, []int{1004}, result.Missing)
+	assert.Equal(t, Stats{Hits: 1, Misses: 3}, s.Stats())
+
+	result, err = s.GetUsersSegments(ctx, []int{1000, 1002})
+	require.NoError(t, err)
+	assert.Equal(t, map[int][]string{1000: {}, 1002: {"AVITO_VOICE_MESSAGES"}}, result.Segments)
+	assert.Empty(t, result.Missing)
+	assert.Equal(t, Stats{Hits: 3, Misses: 3}, s.Stats())
+}
+
+func TestStorage_GetUser_Expiring(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_VOICE_MESSAGES"}))
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_PERFORMANCE_VAS"}))
+	require.NoError(t, repo.CreateUser(ctx, 1000))
+	require.NoError(t, repo.CreateUser(ctx, 1002))
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	for _, id := range []int{1000, 1002} {
+		require.NoError(t, repo.AddUserToSegment(ctx, id, "AVITO_VOICE_MESSAGES", &expiresAt))
+		require.NoError(t, repo.AddUserToSegment(ctx, id, "AVITO_PERFORMANCE_VAS", nil))
+	}
+	s := NewStorage(repo, NewLRU(100, time.Hour), logger.Discard())
+
+	user, err := s.GetUser(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_PERFORMANCE_VAS", "AVITO_VOICE_MESSAGES"}, user.Segments)
+	result, err := s.GetUsersSegments(ctx, []int{1000, 1002})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_PERFORMANCE_VAS", "AVITO_VOICE_MESSAGES"}, result.Segments[1002])
+	assert.Equal(t, Stats{Hits: 1, Misses: 2}, s.Stats())
+
+	// The entries end with the membership, before the reaper removes it and the TTL passes.
+	time.Sleep(time.Until(expiresAt) + 10*time.Millisecond)
+	user, err = s.GetUser(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_PERFORMANCE_VAS"}, user.Segments)
+	result, err = s.GetUsersSegments(ctx, []int{1002})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_PERFORMANCE_VAS"}, result.Segments[1002])
+	assert.Equal(t, Stats{Hits: 1, Misses: 4}, s.Stats())
+}
+
+func TestStorage_RunInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := memory.NewStorage()
+	for _, id := range []int{1000, 1002} {
+		require.NoError(t, repo.CreateUser(ctx, id))
+	}
+	cache := NewLRU(100, time.Hour)
+	s := NewStorage(repo, cache, logger.Discard())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.RunInvalidation(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Events are broadcast by the relay of any instance, a change made elsewhere drops the user here.
+	userID := 1000
+	userEvent := models.Event{ID: "1", Type: models.EventMembershipAdded, UserID: &userID, Segment: "AVITO_VOICE_MESSAGES"}
+	require.Eventually(t, func() bool {
+		_, err := s.GetUsersSegments(ctx, []int{1000, 1002})
+		require.NoError(t, err)
+		require.NoError(t, repo.NotifyEvents(ctx, []models.Event{userEvent}))
+		return cache.Len() == 1
+	}, time.Second, 10*time.Millisecond)
+	_, ok, err := cache.Get(ctx, 1002)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	segmentEvent := models.Event{ID: "2", Type: models.EventSegmentDeleted, Segment: "AVITO_VOICE_MESSAGES"}
+	require.NoError(t, repo.NotifyEvents(ctx, []models.Event{segmentEvent}))
+	assert.Zero(t, cache.Len())
+}
+
+// slowStorage holds loaded users until released, so an invalidation can happen during the load.
+type slowStorage struct {
+	*memory.Storage
+
+	loaded  chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) GetUser(ctx context.Context, id int) (models.User, error) {
+	user, err := s.Storage.GetUser(ctx, id)
+	s.loaded <- struct{}{}
+	<-s.release
+	return user, err
+}
+
+func (s *slowStorage) GetUsersSegments(ctx context.Context, ids []int) (models.UsersSegments, error) {
+	result, err := s.Storage.GetUsersSegments(ctx, ids)
+	s.loaded <- struct{}{}
+	<-s.release
+	return result, err
+}
+
+func TestStorage_InvalidatedDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	loads := map[string]func(s *Storage) ([]string, error){
+		"GetUser": func(s *Storage) ([]string, error) {
+			user, err := s.GetUser(ctx, 1000)
+			return user.Segments, err
+		},
+		"GetUsersSegments": func(s *Storage) ([]string, error) {
+			result, err := s.GetUsersSegments(ctx, []int{1000})
+			return result.Segments[1000], err
+		},
+	}
+	for name, load := range loads {
+		load := load
+		t.Run(name, func(t *testing.T) {
+			repo := &slowStorage{Storage: memory.NewStorage(), loaded: make(chan struct{}), release: make(chan struct{})}
+			require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_VOICE_MESSAGES"}))
+			require.NoError(t, repo.CreateUser(ctx, 1000))
+			cache := NewLRU(100, time.Hour)
+			s := NewStorage(repo, cache, logger.Discard())
+
+			stale := make(chan []string)
+			go func() {
+				segments, err := load(s)
+				assert.NoError(t, err)
+				stale <- segments
+			}()
+			<-repo.loaded
+			// The user changes after the load read it, the loaded state must not be cached.
+			require.NoError(t, s.AddUserToSegment(ctx, 1000, "AVITO_VOICE_MESSAGES", nil))
+			close(repo.release)
+			assert.Empty(t, <-stale)
+			assert.Zero(t, cache.Len())
+
+			go func() { <-repo.loaded }()
+			segments, err := load(s)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"AVITO_VOICE_MESSAGES"}, segments)
+		})
+	}
+}
+
+func TestStorage_InTx(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_VOICE_MESSAGES"}))
+	require.NoError(t, repo.CreateUser(ctx, 1000))
+	cache := NewLRU(100, time.Hour)
+	s := NewStorage(repo, cache, logger.Discard())
+
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		if err := s.AddUserToSegment(ctx, 1000, "AVITO_VOICE_MESSAGES", nil); err != nil {
+			return err
+		}
+		// A read within the transaction caches nothing.
+		_, err := s.GetUser(ctx, 1000)
+		require.NoError(t, err)
+		require.Zero(t, cache.Len())
+		// A concurrent read caches the state before the commit.
+		return cache.Set(ctx, models.User{ID: 1000, Segments: []string{}})
+	})
+	require.NoError(t, err)
+
+	user, err := s.GetUser(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_VOICE_MESSAGES"}, user.Segments)
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// LRU is an in-process Cache of at most size users. An entry expires ttl after it is set
+// or once the first segment of the user expires, the least recently used entry is evicted when the cache is full.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[int]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	user      models.User
+	expiresAt time.Time
+}
+
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  max(size, 1),
+		ttl:   ttl,
+		items: make(map[int]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *LRU) Get(ctx context.Context, id int) (models.User, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[id]
+	if !ok {
+		return models.User{}, false, nil
+	}
+	entry := item.Value.(*lruEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(item)
+		return models.User{}, false, nil
+	}
+	c.order.MoveToFront(item)
+	return copyUser(entry.user), true, nil
+}
+
+func (c *LRU) Set(ctx context.Context, user models.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{user: copyUser(user), expiresAt: time.Now().Add(c.ttl)}
+	if user.NextExpiry != nil && user.NextExpiry.Before(entry.expiresAt) {
+		entry.expiresAt = *user.NextExpiry
+	}
+	if item, ok := c.items[user.ID]; ok {
+		item.Value = entry
+		c.order.MoveToFront(item)
+		return nil
+	}
+	c.items[user.ID] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *LRU) Delete(ctx context.Context, ids ...int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if item, ok := c.items[id]; ok {
+			c.remove(item)
+		}
+	}
+	return nil
+}
+
+func (c *LRU) Clear(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[int]*list.Element)
+	c.order.Init()
+	return nil
+}
+
+// Len returns the number of entries, expired ones included.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) remove(item *list.Element) {
+	c.order.Remove(item)
+	delete(c.items, item.Value.(*lruEntry).user.ID)
+}
+
+func copyUser(user models.User) models.User {
+	user.Segments = append([]string{}, user.Segments...)
+	if user.NextExpiry != nil {
+		nextExpiry := *user.NextExpiry
+		user.NextExpiry = &nextExpiry
+	}
+	return user
+}
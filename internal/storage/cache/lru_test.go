@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2, time.Hour)
+
+	require.NoError(t, c.Set(ctx, models.User{ID: 1, Segments: []string{"a"}}))
+	require.NoError(t, c.Set(ctx, models.User{ID: 2, Segments: []string{"b"}}))
+	user, ok, err := c.Get(ctx, 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	user.Segments[0] = "changed"
+
+	// 2 is the least recently used.
+	require.NoError(t, c.Set(ctx, models.User{ID: 3}))
+	_, ok, _ = c.Get(ctx, 2)
+	assert.False(t, ok)
+	user, ok, _ = c.Get(ctx, 1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a"}, user.Segments, "cached users are copied")
+
+	require.NoError(t, c.Delete(ctx, 1, 4))
+	_, ok, _ = c.Get(ctx, 1)
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	require.NoError(t, c.Clear(ctx))
+	assert.Zero(t, c.Len())
+}
+
+func TestLRU_Expiration(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2, 10*time.Millisecond)
+
+	require.NoError(t, c.Set(ctx, models.User{ID: 1}))
+	_, ok, _ := c.Get(ctx, 1)
+	assert.True(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok, _ = c.Get(ctx, 1)
+	assert.False(t, ok)
+	assert.Zero(t, c.Len())
+
+	// An entry does not outlive the first membership of the user.
+	c = NewLRU(2, time.Hour)
+	nextExpiry := time.Now().Add(10 * time.Millisecond)
+	require.NoError(t, c.Set(ctx, models.User{ID: 1, Segments: []string{"a"}, NextExpiry: &nextExpiry}))
+	user, ok, _ := c.Get(ctx, 1)
+	require.True(t, ok)
+	require.NotNil(t, user.NextExpiry)
+	assert.True(t, nextExpiry.Equal(*user.NextExpiry))
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok, _ = c.Get(ctx, 1)
+	assert.False(t, ok)
+}
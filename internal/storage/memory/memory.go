@@ -0,0 +1,803 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// Storage keeps users and segments in process memory.
+// It has the same semantics as the postgres storage and is meant for tests and local runs.
+type Storage struct {
+	mu sync.Mutex
+
+	users    map[int]struct{}
+	segments map[string]models.Segment
+	// members maps a user to the expiry of each of its memberships, nil for permanent ones.
+	// Memberships of archived users and segments are kept for restore but hidden from reads.
+	members map[int]map[string]*time.Time
+	history []models.HistoryRecord
+
+	// archivedUsers and archivedSegments hold the archive time of soft deleted entities.
+	archivedUsers    map[int]time.Time
+	archivedSegments map[string]time.Time
+
+	webhooks         map[int]models.Webhook
+	lastWebhookID    int
+	deadLetters      []models.DeadLetter
+	lastDeadLetterID int64
+	deliveries       map[int64]models.WebhookDelivery
+	lastDeliveryID   int64
+
+	outbox        []models.OutboxEvent
+	lastOutboxSeq int64
+
+	// undo logs how to undo the map changes of the running transaction, it is nil outside of transactions.
+	undo []func()
+
+	// relayMu is held by the relay of the outbox, it is independent of the storage lock.
+	relayMu sync.Mutex
+
+	// listeners receive broadcast events, they are not part of transactions.
+	listenersMu    sync.Mutex
+	listeners      map[int]func(models.Event)
+	lastListenerID int
+}
+
+func NewStorage() *Storage {
+	return &Storage{
+		users:            make(map[int]struct{}),
+		segments:         make(map[string]models.Segment),
+		members:          make(map[int]map[string]*time.Time),
+		history:          make([]models.HistoryRecord, 0),
+		archivedUsers:    make(map[int]time.Time),
+		archivedSegments: make(map[string]time.Time),
+		webhooks:         make(map[int]models.Webhook),
+		deadLetters:      make([]models.DeadLetter, 0),
+		deliveries:       make(map[int64]models.WebhookDelivery),
+		outbox:           make([]models.OutboxEvent, 0),
+		listeners:        make(map[int]func(models.Event)),
+	}
+}
+
+// CreateSegment creates the segment. It returns storage.ErrAlreadyExist if a segment with the name exists,
+// archived segments included: the name stays taken until the archived segment is restored or purged.
+func (s *Storage) CreateSegment(ctx context.Context, segment models.Segment) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.segments[segment.Name]; ok {
+		return storage.ErrAlreadyExist
+	}
+
+	now := time.Now()
+	segment.Tags = copyTags(segment.Tags)
+	segment.CreatedAt = now
+	segment.UpdatedAt = now
+	setKey(s, s.segments, segment.Name, segment)
+	s.addEvent(models.Event{Type: models.EventSegmentCreated, Segment: segment.Name}, now)
+	return nil
+}
+
+func (s *Storage) GetSegment(ctx context.Context, name string) (models.Segment, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(name) {
+		return models.Segment{}, storage.ErrNotExist
+	}
+	return copySegment(s.segments[name]), nil
+}
+
+// UpdateSegment changes the segment metadata and returns the updated segment.
+func (s *Storage) UpdateSegment(ctx context.Context, params models.UpdateSegmentParams) (models.Segment, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(params.Name) {
+		return models.Segment{}, storage.ErrNotExist
+	}
+	segment := s.segments[params.Name]
+	if params.Description != nil {
+		segment.Description = *params.Description
+	}
+	if params.Owner != nil {
+		segment.Owner = *params.Owner
+	}
+	if params.Tags != nil {
+		segment.Tags = copyTags(params.Tags)
+	}
+	segment.UpdatedAt = time.Now()
+	setKey(s, s.segments, params.Name, segment)
+	return copySegment(segment), nil
+}
+
+// RenameSegment renames the segment keeping its members and returns the renamed segment.
+// History records made before the rename keep the old name. It returns storage.ErrAlreadyExist
+// if another segment named newName exists, archived or not; renaming the segment to its own name changes nothing.
+func (s *Storage) RenameSegment(ctx context.Context, name, newName string) (models.Segment, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(name) {
+		return models.Segment{}, storage.ErrNotExist
+	}
+	if newName == name {
+		return copySegment(s.segments[name]), nil
+	}
+	if _, ok := s.segments[newName]; ok {
+		return models.Segment{}, storage.ErrAlreadyExist
+	}
+	segment := s.segments[name]
+
+	for _, memberships := range s.members {
+		if expiresAt, ok := memberships[name]; ok {
+			deleteKey(s, memberships, name)
+			setKey(s, memberships, newName, expiresAt)
+		}
+	}
+	deleteKey(s, s.segments, name)
+	segment.Name = newName
+	segment.UpdatedAt = time.Now()
+	setKey(s, s.segments, newName, segment)
+	return copySegment(segment), nil
+}
+
+// MergeSegments adds active members of the sources to the target and deletes the sources.
+// A user in several sources gets the latest of their expiry times; existing target memberships are kept.
+// It returns IDs of the users added to the target in ascending order.
+func (s *Storage) MergeSegments(ctx context.Context, target string, sources []string) ([]int, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(target) {
+		return nil, storage.ErrNotExist
+	}
+	for _, source := range sources {
+		if !s.isSegmentActive(source) {
+			return nil, storage.ErrNotExist
+		}
+	}
+
+	added := make([]int, 0)
+	now := time.Now()
+	for _, userID := range s.sortedUserIDs() {
+		memberships := s.members[userID]
+		var expiresAt *time.Time
+		found := false
+		for _, source := range sources {
+			sourceExpiresAt, ok := memberships[source]
+			if !ok || !isActive(sourceExpiresAt, now) {
+				continue
+			}
+			if !found || expiresAt != nil && (sourceExpiresAt == nil || sourceExpiresAt.After(*expiresAt)) {
+				expiresAt = sourceExpiresAt
+			}
+			found = true
+		}
+		if !found {
+			continue
+		}
+
+		if current, ok := memberships[target]; ok {
+			if isActive(current, now) {
+				continue
+			}
+			s.removeMembership(userID, target, current, now)
+		}
+		setKey(s, memberships, target, copyTime(expiresAt))
+		s.addHistory(userID, target, models.OperationAdd, now)
+		added = append(added, userID)
+	}
+
+	for _, source := range sortedKeys(stringSet(sources)) {
+		s.archiveSegment(source, now)
+	}
+	return added, nil
+}
+
+// DeleteSegment archives the segment and logs the removal of all its members to the history.
+// Active memberships are kept, so RestoreSegment brings them back.
+func (s *Storage) DeleteSegment(ctx context.Context, name string) error {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(name) {
+		return storage.ErrNotExist
+	}
+	s.archiveSegment(name, time.Now())
+	return nil
+}
+
+// RestoreSegment brings the archived segment back with its memberships that have not expired since
+// and logs them to the history as added.
+func (s *Storage) RestoreSegment(ctx context.Context, name string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.archivedSegments[name]; !ok {
+		return storage.ErrNotExist
+	}
+
+	now := time.Now()
+	for _, userID := range sortedIntKeys(s.members) {
+		expiresAt, ok := s.members[userID][name]
+		switch {
+		case !ok:
+		case !isActive(expiresAt, now):
+			// Removal was already logged when the segment was archived.
+			deleteKey(s, s.members[userID], name)
+		case s.isUserActive(userID):
+			s.addHistory(userID, name, models.OperationAdd, now)
+		}
+	}
+	deleteKey(s, s.archivedSegments, name)
+	s.addEvent(models.Event{Type: models.EventSegmentCreated, Segment: name}, now)
+	return nil
+}
+
+// CreateUser creates the user. It returns storage.ErrAlreadyExist if a user with the ID exists,
+// archived users included: the ID stays taken until the archived user is restored or purged.
+func (s *Storage) CreateUser(ctx context.Context, id int) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.users[id]; ok {
+		return storage.ErrAlreadyExist
+	}
+	setKey(s, s.users, id, struct{}{})
+	setKey(s, s.members, id, make(map[string]*time.Time))
+	s.addEvent(models.Event{Type: models.EventUserCreated, UserID: &id}, time.Now())
+	return nil
+}
+
+// DeleteUser archives the user and logs the removal from all its segments to the history.
+// Active memberships are kept, so RestoreUser brings them back.
+func (s *Storage) DeleteUser(ctx context.Context, id int) error {
+	defer s.lock(ctx)()
+
+	if !s.isUserActive(id) {
+		return storage.ErrNotExist
+	}
+
+	now := time.Now()
+	for _, segment := range sortedKeys(s.members[id]) {
+		expiresAt := s.members[id][segment]
+		if s.isSegmentActive(segment) {
+			s.logRemoval(id, segment, expiresAt, now)
+		}
+		if !isActive(expiresAt, now) {
+			deleteKey(s, s.members[id], segment)
+		}
+	}
+	setKey(s, s.archivedUsers, id, now)
+	s.addEvent(models.Event{Type: models.EventUserDeleted, UserID: &id}, now)
+	return nil
+}
+
+// RestoreUser brings the archived user back with its memberships that have not expired since
+// and logs them to the history as added.
+func (s *Storage) RestoreUser(ctx context.Context, id int) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.archivedUsers[id]; !ok {
+		return storage.ErrNotExist
+	}
+
+	now := time.Now()
+	for _, segment := range sortedKeys(s.members[id]) {
+		switch {
+		case !isActive(s.members[id][segment], now):
+			// Removal was already logged when the user was archived.
+			deleteKey(s, s.members[id], segment)
+		case s.isSegmentActive(segment):
+			s.addHistory(id, segment, models.OperationAdd, now)
+		}
+	}
+	deleteKey(s, s.archivedUsers, id)
+	s.addEvent(models.Event{Type: models.EventUserCreated, UserID: &id}, now)
+	return nil
+}
+
+// PurgeArchived deletes for good segments and users archived before the given time, together with their memberships.
+// It returns the number of purged segments and users.
+func (s *Storage) PurgeArchived(ctx context.Context, before time.Time) (int64, error) {
+	defer s.lock(ctx)()
+
+	var purged int64
+	for name, archivedAt := range s.archivedSegments {
+		if archivedAt.Before(before) {
+			s.purgeSegment(name)
+			purged++
+		}
+	}
+	for id, archivedAt := range s.archivedUsers {
+		if archivedAt.Before(before) {
+			s.purgeUser(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// AddUserToSegment adds the user to the segment until expiresAt, or permanently if expiresAt is nil.
+// An expired membership that was not reaped yet is replaced.
+// It returns storage.ErrNotExist if either the user or the segment does not exist.
+func (s *Storage) AddUserToSegment(ctx context.Context, userID int, segment string, expiresAt *time.Time) error {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) || !s.isUserActive(userID) {
+		return storage.ErrNotExist
+	}
+
+	now := time.Now()
+	if current, ok := s.members[userID][segment]; ok {
+		if isActive(current, now) {
+			return storage.ErrAlreadyExist
+		}
+		s.removeMembership(userID, segment, current, now)
+	}
+
+	setKey(s, s.members[userID], segment, copyTime(expiresAt))
+	s.addHistory(userID, segment, models.OperationAdd, now)
+	return nil
+}
+
+func (s *Storage) DeleteUserFromSegment(ctx context.Context, userID int, segment string) error {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) {
+		return storage.ErrNotExist
+	}
+	if expiresAt, ok := s.members[userID][segment]; ok && s.isUserActive(userID) {
+		s.removeMembership(userID, segment, expiresAt, time.Now())
+	}
+	return nil
+}
+
+// AddUsersToSegment adds the users to the segment until expiresAt, or permanently if expiresAt is nil.
+// Expired memberships that were not reaped yet are replaced.
+// It returns storage.ErrNotExist if the segment does not exist.
+func (s *Storage) AddUsersToSegment(
+	ctx context.Context, segment string, userIDs []int, expiresAt *time.Time,
+) (models.BulkMembershipResult, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) {
+		return models.BulkMembershipResult{}, storage.ErrNotExist
+	}
+
+	result := models.BulkMembershipResult{Changed: []int{}, Missing: []int{}}
+	now := time.Now()
+	for _, userID := range distinct(userIDs) {
+		if !s.isUserActive(userID) {
+			result.Missing = append(result.Missing, userID)
+			continue
+		}
+		if current, ok := s.members[userID][segment]; ok {
+			if isActive(current, now) {
+				continue
+			}
+			s.removeMembership(userID, segment, current, now)
+		}
+		setKey(s, s.members[userID], segment, copyTime(expiresAt))
+		s.addHistory(userID, segment, models.OperationAdd, now)
+		result.Changed = append(result.Changed, userID)
+	}
+	sort.Ints(result.Changed)
+	return result, nil
+}
+
+// DeleteUsersFromSegment removes the users from the segment.
+// Expired memberships that were not reaped yet are removed too, but are not reported as changed.
+// It returns storage.ErrNotExist if the segment does not exist.
+func (s *Storage) DeleteUsersFromSegment(
+	ctx context.Context, segment string, userIDs []int,
+) (models.BulkMembershipResult, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) {
+		return models.BulkMembershipResult{}, storage.ErrNotExist
+	}
+
+	result := models.BulkMembershipResult{Changed: []int{}, Missing: []int{}}
+	now := time.Now()
+	for _, userID := range distinct(userIDs) {
+		if !s.isUserActive(userID) {
+			result.Missing = append(result.Missing, userID)
+			continue
+		}
+		expiresAt, ok := s.members[userID][segment]
+		if !ok {
+			continue
+		}
+		s.removeMembership(userID, segment, expiresAt, now)
+		if isActive(expiresAt, now) {
+			result.Changed = append(result.Changed, userID)
+		}
+	}
+	sort.Ints(result.Changed)
+	return result, nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, id int) (models.User, error) {
+	defer s.lock(ctx)()
+
+	if !s.isUserActive(id) {
+		return models.User{}, storage.ErrNotExist
+	}
+
+	user := models.User{
+		ID:       id,
+		Segments: []string{},
+	}
+	now := time.Now()
+	for _, segment := range sortedKeys(s.members[id]) {
+		if isActive(s.members[id][segment], now) && s.isSegmentActive(segment) {
+			user.Segments = append(user.Segments, segment)
+			user.NextExpiry = earliest(user.NextExpiry, s.members[id][segment])
+		}
+	}
+	return user, nil
+}
+
+// GetUsersSegments returns segments of many users. Users that do not exist are reported as missing.
+func (s *Storage) GetUsersSegments(ctx context.Context, userIDs []int) (models.UsersSegments, error) {
+	defer s.lock(ctx)()
+
+	result := models.UsersSegments{
+		Segments:   make(map[int][]string),
+		Missing:    []int{},
+		NextExpiry: make(map[int]time.Time),
+	}
+	now := time.Now()
+	for _, userID := range distinct(userIDs) {
+		if !s.isUserActive(userID) {
+			result.Missing = append(result.Missing, userID)
+			continue
+		}
+		segments := []string{}
+		var nextExpiry *time.Time
+		for _, segment := range sortedKeys(s.members[userID]) {
+			if isActive(s.members[userID][segment], now) && s.isSegmentActive(segment) {
+				segments = append(segments, segment)
+				nextExpiry = earliest(nextExpiry, s.members[userID][segment])
+			}
+		}
+		result.Segments[userID] = segments
+		if nextExpiry != nil {
+			result.NextExpiry[userID] = *nextExpiry
+		}
+	}
+	return result, nil
+}
+
+// earliest returns the earlier of two expiry times, nil stands for a permanent membership.
+func earliest(a, b *time.Time) *time.Time {
+	if a == nil || b != nil && b.Before(*a) {
+		return b
+	}
+	return a
+}
+
+// DeleteExpiredSegments removes all expired memberships and returns the history records of the removals.
+// Every removal is logged to the history as expired at the membership expiry time.
+// Memberships of archived users and segments are left to RestoreUser, RestoreSegment and PurgeArchived.
+func (s *Storage) DeleteExpiredSegments(ctx context.Context) ([]models.HistoryRecord, error) {
+	defer s.lock(ctx)()
+
+	deleted := make([]models.HistoryRecord, 0)
+	now := time.Now()
+	for _, userID := range s.sortedUserIDs() {
+		for _, segment := range sortedKeys(s.members[userID]) {
+			expiresAt := s.members[userID][segment]
+			if isActive(expiresAt, now) || !s.isSegmentActive(segment) {
+				continue
+			}
+			s.removeMembership(userID, segment, expiresAt, now)
+			deleted = append(deleted, s.history[len(s.history)-1])
+		}
+	}
+	return deleted, nil
+}
+
+// GetHistory returns membership changes made in [from, to) ordered by time.
+func (s *Storage) GetHistory(ctx context.Context, from, to time.Time) ([]models.HistoryRecord, error) {
+	defer s.lock(ctx)()
+
+	records := make([]models.HistoryRecord, 0)
+	for _, record := range s.history {
+		if !record.Timestamp.Before(from) && record.Timestamp.Before(to) {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records, nil
+}
+
+func (s *Storage) GetUserIDs(ctx context.Context) ([]int, error) {
+	defer s.lock(ctx)()
+
+	return s.sortedUserIDs(), nil
+}
+
+// GetAutoSegments returns segments that automatically enroll a share of users.
+func (s *Storage) GetAutoSegments(ctx context.Context) ([]models.Segment, error) {
+	defer s.lock(ctx)()
+
+	segments := make([]models.Segment, 0)
+	for _, name := range sortedKeys(s.segments) {
+		if segment := s.segments[name]; segment.AutoPercent > 0 && s.isSegmentActive(name) {
+			segments = append(segments, copySegment(segment))
+		}
+	}
+	return segments, nil
+}
+
+// ListSegments returns up to limit segments whose names start with prefix and follow after, ordered by name.
+func (s *Storage) ListSegments(ctx context.Context, prefix, after string, limit int) ([]models.Segment, error) {
+	defer s.lock(ctx)()
+
+	segments := make([]models.Segment, 0)
+	for _, name := range sortedKeys(s.segments) {
+		if len(segments) == limit {
+			break
+		}
+		if name > after && strings.HasPrefix(name, prefix) && s.isSegmentActive(name) {
+			segments = append(segments, copySegment(s.segments[name]))
+		}
+	}
+	return segments, nil
+}
+
+// GetSegmentMembers returns up to limit members of the segment with ID greater than after, ordered by ID.
+func (s *Storage) GetSegmentMembers(
+	ctx context.Context, segment string, after *int, limit int,
+) ([]models.SegmentMember, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) {
+		return nil, storage.ErrNotExist
+	}
+
+	members := make([]models.SegmentMember, 0)
+	now := time.Now()
+	for _, userID := range s.sortedUserIDs() {
+		if len(members) == limit {
+			break
+		}
+		if after != nil && userID <= *after {
+			continue
+		}
+		if expiresAt, ok := s.members[userID][segment]; ok && isActive(expiresAt, now) {
+			members = append(members, models.SegmentMember{UserID: userID, ExpiresAt: copyTime(expiresAt)})
+		}
+	}
+	return members, nil
+}
+
+// GetSegmentUsers returns up to limit IDs of the segment members greater than after, ordered by ID.
+func (s *Storage) GetSegmentUsers(ctx context.Context, segment string, after *int, limit int) ([]int, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) {
+		return nil, storage.ErrNotExist
+	}
+
+	users := make([]int, 0)
+	now := time.Now()
+	for _, userID := range s.sortedUserIDs() {
+		if len(users) == limit {
+			break
+		}
+		if after != nil && userID <= *after {
+			continue
+		}
+		if expiresAt, ok := s.members[userID][segment]; ok && isActive(expiresAt, now) {
+			users = append(users, userID)
+		}
+	}
+	return users, nil
+}
+
+func (s *Storage) CountSegmentUsers(ctx context.Context, segment string) (int, error) {
+	defer s.lock(ctx)()
+
+	if !s.isSegmentActive(segment) {
+		return 0, storage.ErrNotExist
+	}
+
+	count := 0
+	now := time.Now()
+	for userID, memberships := range s.members {
+		if expiresAt, ok := memberships[segment]; ok && isActive(expiresAt, now) && s.isUserActive(userID) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountMembers returns the number of members of every segment.
+func (s *Storage) CountMembers(ctx context.Context) (map[string]int, error) {
+	defer s.lock(ctx)()
+
+	counts := make(map[string]int, len(s.segments))
+	for segment := range s.segments {
+		if s.isSegmentActive(segment) {
+			counts[segment] = 0
+		}
+	}
+	now := time.Now()
+	for userID, memberships := range s.members {
+		if !s.isUserActive(userID) {
+			continue
+		}
+		for segment, expiresAt := range memberships {
+			if _, ok := counts[segment]; ok && isActive(expiresAt, now) {
+				counts[segment]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (s *Storage) IsUserCreated(ctx context.Context, userID int) (bool, error) {
+	defer s.lock(ctx)()
+
+	return s.isUserActive(userID), nil
+}
+
+func (s *Storage) isSegmentActive(name string) bool {
+	_, ok := s.segments[name]
+	_, archived := s.archivedSegments[name]
+	return ok && !archived
+}
+
+func (s *Storage) isUserActive(id int) bool {
+	_, ok := s.users[id]
+	_, archived := s.archivedUsers[id]
+	return ok && !archived
+}
+
+// archiveSegment logs the removal of the segment members and keeps only active memberships for restore.
+func (s *Storage) archiveSegment(name string, now time.Time) {
+	for _, userID := range sortedIntKeys(s.members) {
+		expiresAt, ok := s.members[userID][name]
+		if !ok {
+			continue
+		}
+		if s.isUserActive(userID) {
+			s.logRemoval(userID, name, expiresAt, now)
+		}
+		if !isActive(expiresAt, now) {
+			deleteKey(s, s.members[userID], name)
+		}
+	}
+	setKey(s, s.archivedSegments, name, now)
+	s.addEvent(models.Event{Type: models.EventSegmentDeleted, Segment: name}, now)
+}
+
+func (s *Storage) purgeSegment(name string) {
+	for _, memberships := range s.members {
+		deleteKey(s, memberships, name)
+	}
+	deleteKey(s, s.segments, name)
+	deleteKey(s, s.archivedSegments, name)
+}
+
+func (s *Storage) purgeUser(id int) {
+	deleteKey(s, s.users, id)
+	deleteKey(s, s.members, id)
+	deleteKey(s, s.archivedUsers, id)
+}
+
+// removeMembership removes the user from the segment and logs it to the history.
+func (s *Storage) removeMembership(userID int, segment string, expiresAt *time.Time, now time.Time) {
+	deleteKey(s, s.members[userID], segment)
+	s.logRemoval(userID, segment, expiresAt, now)
+}
+
+// logRemoval logs the removal of the membership: one that already expired is logged as expired at its expiry time.
+func (s *Storage) logRemoval(userID int, segment string, expiresAt *time.Time, now time.Time) {
+	if isActive(expiresAt, now) {
+		s.addHistory(userID, segment, models.OperationRemove, now)
+	} else {
+		s.addHistory(userID, segment, models.OperationExpire, *expiresAt)
+	}
+}
+
+// addHistory logs the membership change to the history and writes its event to the outbox.
+func (s *Storage) addHistory(userID int, segment string, operation models.Operation, timestamp time.Time) {
+	s.history = append(s.history, models.HistoryRecord{
+		UserID:    userID,
+		Segment:   segment,
+		Operation: operation,
+		Timestamp: timestamp,
+	})
+
+	event := models.Event{UserID: &userID, Segment: segment}
+	switch operation {
+	case models.OperationAdd:
+		event.Type = models.EventMembershipAdded
+		event.ExpiresAt = copyTime(s.members[userID][segment])
+	case models.OperationRemove:
+		event.Type = models.EventMembershipRemoved
+	default:
+		event.Type = models.EventMembershipExpired
+		event.ExpiresAt = copyTime(&timestamp)
+	}
+	s.addEvent(event, timestamp)
+}
+
+// sortedUserIDs returns IDs of users that are not archived.
+func (s *Storage) sortedUserIDs() []int {
+	ids := make([]int, 0, len(s.users))
+	for id := range s.users {
+		if s.isUserActive(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func sortedIntKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func distinct(ids []int) []int {
+	seen := make(map[int]struct{}, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func stringSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+// copySegment keeps callers from changing tags stored in the storage.
+func copySegment(segment models.Segment) models.Segment {
+	segment.Tags = copyTags(segment.Tags)
+	return segment
+}
+
+func copyTags(tags []string) []string {
+	c := make([]string, len(tags))
+	copy(c, tags)
+	return c
+}
+
+func isActive(expiresAt *time.Time, now time.Time) bool {
+	return expiresAt == nil || expiresAt.After(now)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) service.SegmentStorage {
+		return NewStorage()
+	})
+}
+
+func TestStorage_InTx_Rollback(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage()
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "a", Tags: []string{"x"}}))
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "b"}))
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "archived"}))
+	for _, id := range []int{1, 2, 3} {
+		require.NoError(t, s.CreateUser(ctx, id))
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "a", nil))
+	require.NoError(t, s.AddUserToSegment(ctx, 2, "b", &expiresAt))
+	require.NoError(t, s.AddUserToSegment(ctx, 3, "archived", nil))
+	require.NoError(t, s.DeleteSegment(ctx, "archived"))
+	require.NoError(t, s.DeleteUser(ctx, 3))
+	webhook, err := s.CreateWebhook(ctx, models.Webhook{URL: "http://localhost"})
+	require.NoError(t, err)
+	require.NoError(t, s.AddWebhookDelivery(ctx, models.WebhookDelivery{WebhookID: webhook.ID}))
+	require.NoError(t, s.AddDeadLetter(ctx, models.DeadLetter{WebhookID: webhook.ID}))
+	before := copyState(s)
+
+	errRollback := errors.New("rollback")
+	err = s.InTx(ctx, func(ctx context.Context) error {
+		description := "changed"
+		_, err := s.UpdateSegment(ctx, models.UpdateSegmentParams{Name: "a", Description: &description})
+		require.NoError(t, err)
+		_, err = s.RenameSegment(ctx, "a", "c")
+		require.NoError(t, err)
+		_, err = s.MergeSegments(ctx, "c", []string{"b"})
+		require.NoError(t, err)
+		require.NoError(t, s.RestoreSegment(ctx, "archived"))
+		require.NoError(t, s.RestoreUser(ctx, 3))
+		require.NoError(t, s.DeleteUser(ctx, 1))
+		require.NoError(t, s.CreateUser(ctx, 4))
+		require.NoError(t, s.AddUserToSegment(ctx, 4, "c", nil))
+		_, err = s.PurgeArchived(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		_, err = s.CreateWebhook(ctx, models.Webhook{URL: "http://localhost"})
+		require.NoError(t, err)
+		_, err = s.ClaimWebhookDeliveries(ctx, time.Now(), time.Now().Add(time.Minute), 10)
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteWebhook(ctx, webhook.ID))
+		require.NoError(t, s.DeleteOutbox(ctx, []int64{1, 2}))
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+	assert.Equal(t, before, copyState(s))
+	assert.Nil(t, s.undo, "the undo log is dropped with the transaction")
+
+	require.NoError(t, s.CreateUser(ctx, 4))
+	assert.Empty(t, s.undo, "changes outside of transactions are not logged")
+}
+
+// state is a deep copy of the storage state that transactions change.
+type state struct {
+	users            map[int]struct{}
+	segments         map[string]models.Segment
+	members          map[int]map[string]*time.Time
+	history          []models.HistoryRecord
+	archivedUsers    map[int]time.Time
+	archivedSegments map[string]time.Time
+	webhooks         map[int]models.Webhook
+	lastWebhookID    int
+	deadLetters      []models.DeadLetter
+	lastDeadLetterID int64
+	deliveries       map[int64]models.WebhookDelivery
+	lastDeliveryID   int64
+	outbox           []models.OutboxEvent
+	lastOutboxSeq    int64
+}
+
+func copyState(s *Storage) state {
+	snap := state{
+		users:            make(map[int]struct{}),
+		segments:         make(map[string]models.Segment),
+		members:          make(map[int]map[string]*time.Time),
+		history:          append([]models.HistoryRecord(nil), s.history...),
+		archivedUsers:    make(map[int]time.Time),
+		archivedSegments: make(map[string]time.Time),
+		webhooks:         make(map[int]models.Webhook),
+		lastWebhookID:    s.lastWebhookID,
+		deadLetters:      append([]models.DeadLetter(nil), s.deadLetters...),
+		lastDeadLetterID: s.lastDeadLetterID,
+		deliveries:       make(map[int64]models.WebhookDelivery),
+		lastDeliveryID:   s.lastDeliveryID,
+		outbox:           append([]models.OutboxEvent(nil), s.outbox...),
+		lastOutboxSeq:    s.lastOutboxSeq,
+	}
+	for id := range s.users {
+		snap.users[id] = struct{}{}
+	}
+	for name, segment := range s.segments {
+		snap.segments[name] = copySegment(segment)
+	}
+	for id, memberships := range s.members {
+		snap.members[id] = make(map[string]*time.Time)
+		for segment, expiresAt := range memberships {
+			snap.members[id][segment] = copyTime(expiresAt)
+		}
+	}
+	for id, archivedAt := range s.archivedUsers {
+		snap.archivedUsers[id] = archivedAt
+	}
+	for name, archivedAt := range s.archivedSegments {
+		snap.archivedSegments[name] = archivedAt
+	}
+	for id, webhook := range s.webhooks {
+		snap.webhooks[id] = copyWebhook(webhook)
+	}
+	for id, delivery := range s.deliveries {
+		snap.deliveries[id] = delivery
+	}
+	return snap
+}
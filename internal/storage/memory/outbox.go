@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// LockOutbox makes the caller the only relay of the outbox until unlock is called.
+// It returns false without waiting if another relay holds the lock.
+func (s *Storage) LockOutbox(ctx context.Context) (func(), bool, error) {
+	if !s.relayMu.TryLock() {
+		return nil, false, nil
+	}
+	return s.relayMu.Unlock, true, nil
+}
+
+// FetchOutbox returns up to limit events of the outbox in the order they were written.
+func (s *Storage) FetchOutbox(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	defer s.lock(ctx)()
+
+	events := make([]models.OutboxEvent, 0, min(limit, len(s.outbox)))
+	for _, event := range s.outbox {
+		if len(events) == limit {
+			break
+		}
+		events = append(events, copyOutboxEvent(event))
+	}
+	return events, nil
+}
+
+// DeleteOutbox deletes published events from the outbox.
+func (s *Storage) DeleteOutbox(ctx context.Context, seqs []int64) error {
+	defer s.lock(ctx)()
+
+	deleted := make(map[int64]struct{}, len(seqs))
+	for _, seq := range seqs {
+		deleted[seq] = struct{}{}
+	}
+	// A new slice keeps the outbox saved by a running transaction unchanged.
+	outbox := make([]models.OutboxEvent, 0, len(s.outbox))
+	for _, event := range s.outbox {
+		if _, ok := deleted[event.Seq]; !ok {
+			outbox = append(outbox, event)
+		}
+	}
+	s.outbox = outbox
+	return nil
+}
+
+// addEvent writes the event to the outbox.
+func (s *Storage) addEvent(event models.Event, timestamp time.Time) {
+	s.lastOutboxSeq++
+	event.ID = strconv.FormatInt(s.lastOutboxSeq, 10)
+	event.Timestamp = timestamp
+	s.outbox = append(s.outbox, models.OutboxEvent{Seq: s.lastOutboxSeq, Event: event})
+}
+
+func copyOutboxEvent(event models.OutboxEvent) models.OutboxEvent {
+	event.Event = copyEvent(event.Event)
+	return event
+}
+
+func copyEvent(event models.Event) models.Event {
+	if event.UserID != nil {
+		userID := *event.UserID
+		event.UserID = &userID
+	}
+	event.ExpiresAt = copyTime(event.ExpiresAt)
+	return event
+}
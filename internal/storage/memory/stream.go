@@ -0,0 +1,37 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// NotifyEvents broadcasts the events to the listeners in their order.
+func (s *Storage) NotifyEvents(_ context.Context, events []models.Event) error {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	for _, event := range events {
+		for _, handle := range s.listeners {
+			handle(copyEvent(event))
+		}
+	}
+	return nil
+}
+
+// ListenEvents calls handle for every broadcast event until ctx is done.
+// Events broadcast before the listener is registered are not received.
+func (s *Storage) ListenEvents(ctx context.Context, handle func(models.Event)) error {
+	s.listenersMu.Lock()
+	s.lastListenerID++
+	id := s.lastListenerID
+	s.listeners[id] = handle
+	s.listenersMu.Unlock()
+
+	<-ctx.Done()
+
+	s.listenersMu.Lock()
+	delete(s.listeners, id)
+	s.listenersMu.Unlock()
+	return nil
+}
@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+type txKey struct{}
+
+// InTx runs fn holding the storage lock, so transactions are serializable.
+// Changes made by fn are logged, and if fn returns an error they are undone in reverse order.
+// Storage methods called with the ctx passed to fn take part in the transaction.
+// A nested InTx joins the outer transaction.
+func (s *Storage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	savepoint := s.savepoint()
+	s.undo = make([]func(), 0)
+	defer func() { s.undo = nil }()
+	if err := fn(context.WithValue(ctx, txKey{}, s)); err != nil {
+		s.rollback(savepoint)
+		return err
+	}
+	return nil
+}
+
+// lock acquires the storage lock unless ctx belongs to a transaction that already holds it.
+// It returns the function that releases the lock.
+func (s *Storage) lock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *Storage) inTx(ctx context.Context) bool {
+	tx, ok := ctx.Value(txKey{}).(*Storage)
+	return ok && tx == s
+}
+
+// savepoint holds the state that is cheap to save as a whole: the counters and the slices.
+// History is only appended to, so its length is enough to restore it. Dead letters and the outbox
+// are replaced rather than changed in place, so the slices themselves are kept.
+type savepoint struct {
+	history          int
+	lastWebhookID    int
+	deadLetters      []models.DeadLetter
+	lastDeadLetterID int64
+	lastDeliveryID   int64
+	outbox           []models.OutboxEvent
+	lastOutboxSeq    int64
+}
+
+func (s *Storage) savepoint() savepoint {
+	return savepoint{
+		history:          len(s.history),
+		lastWebhookID:    s.lastWebhookID,
+		deadLetters:      s.deadLetters[:len(s.deadLetters):len(s.deadLetters)],
+		lastDeadLetterID: s.lastDeadLetterID,
+		lastDeliveryID:   s.lastDeliveryID,
+		outbox:           s.outbox[:len(s.outbox):len(s.outbox)],
+		lastOutboxSeq:    s.lastOutboxSeq,
+	}
+}
+
+// rollback undoes the map changes logged by the transaction and restores the rest of the state from the savepoint.
+func (s *Storage) rollback(savepoint savepoint) {
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		s.undo[i]()
+	}
+	s.history = s.history[:savepoint.history]
+	s.lastWebhookID = savepoint.lastWebhookID
+	s.deadLetters = savepoint.deadLetters
+	s.lastDeadLetterID = savepoint.lastDeadLetterID
+	s.lastDeliveryID = savepoint.lastDeliveryID
+	s.outbox = savepoint.outbox
+	s.lastOutboxSeq = savepoint.lastOutboxSeq
+}
+
+// setKey sets the key of m, the storage maps are changed only through setKey and deleteKey
+// so that a running transaction logs how to undo the change.
+func setKey[K comparable, V any](s *Storage, m map[K]V, key K, value V) {
+	if s.undo != nil {
+		old, ok := m[key]
+		s.undo = append(s.undo, func() {
+			if ok {
+				m[key] = old
+			} else {
+				delete(m, key)
+			}
+		})
+	}
+	m[key] = value
+}
+
+// deleteKey deletes the key from m, see setKey.
+func deleteKey[K comparable, V any](s *Storage, m map[K]V, key K) {
+	if old, ok := m[key]; ok && s.undo != nil {
+		s.undo = append(s.undo, func() { m[key] = old })
+	}
+	delete(m, key)
+}
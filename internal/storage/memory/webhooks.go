@@ -0,0 +1,186 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// CreateWebhook saves the webhook and returns it with the assigned ID and creation time.
+func (s *Storage) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	defer s.lock(ctx)()
+
+	s.lastWebhookID++
+	webhook.ID = s.lastWebhookID
+	webhook.CreatedAt = time.Now()
+	webhook.Events = copyEvents(webhook.Events)
+	setKey(s, s.webhooks, webhook.ID, webhook)
+	return copyWebhook(webhook), nil
+}
+
+// ListWebhooks returns all webhooks with their secrets ordered by ID.
+func (s *Storage) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	defer s.lock(ctx)()
+
+	webhooks := make([]models.Webhook, 0, len(s.webhooks))
+	for _, id := range sortedIntKeys(s.webhooks) {
+		webhooks = append(webhooks, copyWebhook(s.webhooks[id]))
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes the webhook with its dead letters and queued deliveries.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return storage.ErrNotExist
+	}
+	deleteKey(s, s.webhooks, id)
+	s.deadLetters = s.filterDeadLetters(func(letter models.DeadLetter) bool {
+		return letter.WebhookID != id
+	})
+	for deliveryID, delivery := range s.deliveries {
+		if delivery.WebhookID == id {
+			deleteKey(s, s.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+// AddDeadLetter saves an undelivered event. It returns storage.ErrNotExist if the webhook was deleted.
+func (s *Storage) AddDeadLetter(ctx context.Context, letter models.DeadLetter) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.webhooks[letter.WebhookID]; !ok {
+		return storage.ErrNotExist
+	}
+	s.lastDeadLetterID++
+	letter.ID = s.lastDeadLetterID
+	s.deadLetters = append(s.deadLetters, letter)
+	return nil
+}
+
+// ListDeadLetters returns dead letters of the webhook in the order they were added.
+func (s *Storage) ListDeadLetters(ctx context.Context, webhookID int) ([]models.DeadLetter, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.webhooks[webhookID]; !ok {
+		return nil, storage.ErrNotExist
+	}
+	letters := make([]models.DeadLetter, 0)
+	for _, letter := range s.deadLetters {
+		if letter.WebhookID == webhookID {
+			letters = append(letters, letter)
+		}
+	}
+	return letters, nil
+}
+
+// DeleteDeadLetter deletes the dead letter. It returns storage.ErrNotExist if there is no dead letter with the ID.
+func (s *Storage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	defer s.lock(ctx)()
+
+	letters := s.filterDeadLetters(func(letter models.DeadLetter) bool {
+		return letter.ID != id
+	})
+	if len(letters) == len(s.deadLetters) {
+		return storage.ErrNotExist
+	}
+	s.deadLetters = letters
+	return nil
+}
+
+// AddWebhookDelivery queues the delivery. It returns storage.ErrNotExist if the webhook was deleted.
+func (s *Storage) AddWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.webhooks[delivery.WebhookID]; !ok {
+		return storage.ErrNotExist
+	}
+	s.lastDeliveryID++
+	delivery.ID = s.lastDeliveryID
+	setKey(s, s.deliveries, delivery.ID, delivery)
+	return nil
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries due at now in the order they were queued
+// and postpones them until lockedUntil.
+func (s *Storage) ClaimWebhookDeliveries(
+	ctx context.Context, now, lockedUntil time.Time, limit int,
+) ([]models.WebhookDelivery, error) {
+	defer s.lock(ctx)()
+
+	ids := make([]int64, 0)
+	for id, delivery := range s.deliveries {
+		if !delivery.NextAttemptAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(ids))
+	for _, id := range ids {
+		delivery := s.deliveries[id]
+		delivery.NextAttemptAt = lockedUntil
+		setKey(s, s.deliveries, id, delivery)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery saves the attempts, the last error and the next attempt time of the delivery.
+// It returns storage.ErrNotExist if the delivery was deleted.
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	defer s.lock(ctx)()
+
+	queued, ok := s.deliveries[delivery.ID]
+	if !ok {
+		return storage.ErrNotExist
+	}
+	queued.Attempts = delivery.Attempts
+	queued.LastError = delivery.LastError
+	queued.NextAttemptAt = delivery.NextAttemptAt
+	setKey(s, s.deliveries, delivery.ID, queued)
+	return nil
+}
+
+// DeleteWebhookDelivery deletes the delivery. It returns storage.ErrNotExist if there is no delivery with the ID.
+func (s *Storage) DeleteWebhookDelivery(ctx context.Context, id int64) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.deliveries[id]; !ok {
+		return storage.ErrNotExist
+	}
+	deleteKey(s, s.deliveries, id)
+	return nil
+}
+
+// filterDeadLetters returns a new slice of the dead letters for which keep returns true,
+// so the dead letters saved by a running transaction are not changed.
+func (s *Storage) filterDeadLetters(keep func(models.DeadLetter) bool) []models.DeadLetter {
+	letters := make([]models.DeadLetter, 0, len(s.deadLetters))
+	for _, letter := range s.deadLetters {
+		if keep(letter) {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+func copyWebhook(webhook models.Webhook) models.Webhook {
+	webhook.Events = copyEvents(webhook.Events)
+	return webhook
+}
+
+func copyEvents(events []models.EventType) []models.EventType {
+	c := make([]models.EventType, len(events))
+	copy(c, events)
+	return c
+}
@@ -0,0 +1,296 @@
+// Package metrics wraps a storage with Prometheus metrics of its operations and segment memberships.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "segmenter_storage_operation_duration_seconds",
+		Help:    "Duration of storage operations by method and result.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	segmentMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "segmenter_segment_members",
+		Help: "Number of active members of a segment.",
+	}, []string{"segment"})
+)
+
+// Storage records the duration of every storage call. Expected errors, see storage.IsExpected,
+// are counted as "ok", any other error as "error".
+// InTx and ListenEvents run for as long as their callers and are not timed.
+type Storage struct {
+	service.SegmentStorage
+
+	log *slog.Logger
+}
+
+func NewStorage(repo service.SegmentStorage, log *slog.Logger) *Storage {
+	return &Storage{SegmentStorage: repo, log: log}
+}
+
+// RunMembersGauge refreshes the number of members of every segment each interval until ctx is done.
+func (s *Storage) RunMembersGauge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.refreshMembers(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Storage) refreshMembers(ctx context.Context) {
+	counts, err := s.CountMembers(ctx)
+	if err != nil {
+		s.log.ErrorContext(ctx, "count segment members", "error", err)
+		return
+	}
+	// Deleted segments are dropped along with the stale counts.
+	segmentMembers.Reset()
+	for segment, count := range counts {
+		segmentMembers.WithLabelValues(segment).Set(float64(count))
+	}
+}
+
+func observe(operation string, start time.Time, err *error) {
+	result := "ok"
+	if *err != nil && !storage.IsExpected(*err) {
+		result = "error"
+	}
+	operationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *Storage) CreateSegment(ctx context.Context, segment models.Segment) (err error) {
+	defer observe("CreateSegment", time.Now(), &err)
+	return s.SegmentStorage.CreateSegment(ctx, segment)
+}
+
+func (s *Storage) GetSegment(ctx context.Context, name string) (_ models.Segment, err error) {
+	defer observe("GetSegment", time.Now(), &err)
+	return s.SegmentStorage.GetSegment(ctx, name)
+}
+
+func (s *Storage) UpdateSegment(
+	ctx context.Context, params models.UpdateSegmentParams,
+) (_ models.Segment, err error) {
+	defer observe("UpdateSegment", time.Now(), &err)
+	return s.SegmentStorage.UpdateSegment(ctx, params)
+}
+
+func (s *Storage) RenameSegment(ctx context.Context, name, newName string) (_ models.Segment, err error) {
+	defer observe("RenameSegment", time.Now(), &err)
+	return s.SegmentStorage.RenameSegment(ctx, name, newName)
+}
+
+func (s *Storage) MergeSegments(ctx context.Context, target string, sources []string) (_ []int, err error) {
+	defer observe("MergeSegments", time.Now(), &err)
+	return s.SegmentStorage.MergeSegments(ctx, target, sources)
+}
+
+func (s *Storage) CreateUser(ctx context.Context, id int) (err error) {
+	defer observe("CreateUser", time.Now(), &err)
+	return s.SegmentStorage.CreateUser(ctx, id)
+}
+
+func (s *Storage) AddUserToSegment(
+	ctx context.Context, userID int, segment string, expiresAt *time.Time,
+) (err error) {
+	defer observe("AddUserToSegment", time.Now(), &err)
+	return s.SegmentStorage.AddUserToSegment(ctx, userID, segment, expiresAt)
+}
+
+func (s *Storage) AddUsersToSegment(
+	ctx context.Context, segment string, userIDs []int, expiresAt *time.Time,
+) (_ models.BulkMembershipResult, err error) {
+	defer observe("AddUsersToSegment", time.Now(), &err)
+	return s.SegmentStorage.AddUsersToSegment(ctx, segment, userIDs, expiresAt)
+}
+
+func (s *Storage) DeleteUsersFromSegment(
+	ctx context.Context, segment string, userIDs []int,
+) (_ models.BulkMembershipResult, err error) {
+	defer observe("DeleteUsersFromSegment", time.Now(), &err)
+	return s.SegmentStorage.DeleteUsersFromSegment(ctx, segment, userIDs)
+}
+
+func (s *Storage) IsUserCreated(ctx context.Context, userID int) (_ bool, err error) {
+	defer observe("IsUserCreated", time.Now(), &err)
+	return s.SegmentStorage.IsUserCreated(ctx, userID)
+}
+
+func (s *Storage) GetUser(ctx context.Context, id int) (_ models.User, err error) {
+	defer observe("GetUser", time.Now(), &err)
+	return s.SegmentStorage.GetUser(ctx, id)
+}
+
+func (s *Storage) GetUsersSegments(ctx context.Context, userIDs []int) (_ models.UsersSegments, err error) {
+	defer observe("GetUsersSegments", time.Now(), &err)
+	return s.SegmentStorage.GetUsersSegments(ctx, userIDs)
+}
+
+func (s *Storage) GetUserIDs(ctx context.Context) (_ []int, err error) {
+	defer observe("GetUserIDs", time.Now(), &err)
+	return s.SegmentStorage.GetUserIDs(ctx)
+}
+
+func (s *Storage) GetAutoSegments(ctx context.Context) (_ []models.Segment, err error) {
+	defer observe("GetAutoSegments", time.Now(), &err)
+	return s.SegmentStorage.GetAutoSegments(ctx)
+}
+
+func (s *Storage) ListSegments(
+	ctx context.Context, prefix, after string, limit int,
+) (_ []models.Segment, err error) {
+	defer observe("ListSegments", time.Now(), &err)
+	return s.SegmentStorage.ListSegments(ctx, prefix, after, limit)
+}
+
+func (s *Storage) GetSegmentUsers(ctx context.Context, segment string, after *int, limit int) (_ []int, err error) {
+	defer observe("GetSegmentUsers", time.Now(), &err)
+	return s.SegmentStorage.GetSegmentUsers(ctx, segment, after, limit)
+}
+
+func (s *Storage) GetSegmentMembers(
+	ctx context.Context, segment string, after *int, limit int,
+) (_ []models.SegmentMember, err error) {
+	defer observe("GetSegmentMembers", time.Now(), &err)
+	return s.SegmentStorage.GetSegmentMembers(ctx, segment, after, limit)
+}
+
+func (s *Storage) CountSegmentUsers(ctx context.Context, segment string) (_ int, err error) {
+	defer observe("CountSegmentUsers", time.Now(), &err)
+	return s.SegmentStorage.CountSegmentUsers(ctx, segment)
+}
+
+func (s *Storage) CountMembers(ctx context.Context) (_ map[string]int, err error) {
+	defer observe("CountMembers", time.Now(), &err)
+	return s.SegmentStorage.CountMembers(ctx)
+}
+
+func (s *Storage) DeleteSegment(ctx context.Context, name string) (err error) {
+	defer observe("DeleteSegment", time.Now(), &err)
+	return s.SegmentStorage.DeleteSegment(ctx, name)
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id int) (err error) {
+	defer observe("DeleteUser", time.Now(), &err)
+	return s.SegmentStorage.DeleteUser(ctx, id)
+}
+
+func (s *Storage) DeleteUserFromSegment(ctx context.Context, userID int, segment string) (err error) {
+	defer observe("DeleteUserFromSegment", time.Now(), &err)
+	return s.SegmentStorage.DeleteUserFromSegment(ctx, userID, segment)
+}
+
+func (s *Storage) DeleteExpiredSegments(ctx context.Context) (_ []models.HistoryRecord, err error) {
+	defer observe("DeleteExpiredSegments", time.Now(), &err)
+	return s.SegmentStorage.DeleteExpiredSegments(ctx)
+}
+
+func (s *Storage) RestoreSegment(ctx context.Context, name string) (err error) {
+	defer observe("RestoreSegment", time.Now(), &err)
+	return s.SegmentStorage.RestoreSegment(ctx, name)
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id int) (err error) {
+	defer observe("RestoreUser", time.Now(), &err)
+	return s.SegmentStorage.RestoreUser(ctx, id)
+}
+
+func (s *Storage) PurgeArchived(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer observe("PurgeArchived", time.Now(), &err)
+	return s.SegmentStorage.PurgeArchived(ctx, before)
+}
+
+func (s *Storage) GetHistory(ctx context.Context, from, to time.Time) (_ []models.HistoryRecord, err error) {
+	defer observe("GetHistory", time.Now(), &err)
+	return s.SegmentStorage.GetHistory(ctx, from, to)
+}
+
+func (s *Storage) CreateWebhook(ctx context.Context, webhook models.Webhook) (_ models.Webhook, err error) {
+	defer observe("CreateWebhook", time.Now(), &err)
+	return s.SegmentStorage.CreateWebhook(ctx, webhook)
+}
+
+func (s *Storage) ListWebhooks(ctx context.Context) (_ []models.Webhook, err error) {
+	defer observe("ListWebhooks", time.Now(), &err)
+	return s.SegmentStorage.ListWebhooks(ctx)
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, id int) (err error) {
+	defer observe("DeleteWebhook", time.Now(), &err)
+	return s.SegmentStorage.DeleteWebhook(ctx, id)
+}
+
+func (s *Storage) AddDeadLetter(ctx context.Context, letter models.DeadLetter) (err error) {
+	defer observe("AddDeadLetter", time.Now(), &err)
+	return s.SegmentStorage.AddDeadLetter(ctx, letter)
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context, webhookID int) (_ []models.DeadLetter, err error) {
+	defer observe("ListDeadLetters", time.Now(), &err)
+	return s.SegmentStorage.ListDeadLetters(ctx, webhookID)
+}
+
+func (s *Storage) DeleteDeadLetter(ctx context.Context, id int64) (err error) {
+	defer observe("DeleteDeadLetter", time.Now(), &err)
+	return s.SegmentStorage.DeleteDeadLetter(ctx, id)
+}
+
+func (s *Storage) AddWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (err error) {
+	defer observe("AddWebhookDelivery", time.Now(), &err)
+	return s.SegmentStorage.AddWebhookDelivery(ctx, delivery)
+}
+
+func (s *Storage) ClaimWebhookDeliveries(
+	ctx context.Context, now, lockedUntil time.Time, limit int,
+) (_ []models.WebhookDelivery, err error) {
+	defer observe("ClaimWebhookDeliveries", time.Now(), &err)
+	return s.SegmentStorage.ClaimWebhookDeliveries(ctx, now, lockedUntil, limit)
+}
+
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (err error) {
+	defer observe("UpdateWebhookDelivery", time.Now(), &err)
+	return s.SegmentStorage.UpdateWebhookDelivery(ctx, delivery)
+}
+
+func (s *Storage) DeleteWebhookDelivery(ctx context.Context, id int64) (err error) {
+	defer observe("DeleteWebhookDelivery", time.Now(), &err)
+	return s.SegmentStorage.DeleteWebhookDelivery(ctx, id)
+}
+
+func (s *Storage) LockOutbox(ctx context.Context) (_ func(), _ bool, err error) {
+	defer observe("LockOutbox", time.Now(), &err)
+	return s.SegmentStorage.LockOutbox(ctx)
+}
+
+func (s *Storage) FetchOutbox(ctx context.Context, limit int) (_ []models.OutboxEvent, err error) {
+	defer observe("FetchOutbox", time.Now(), &err)
+	return s.SegmentStorage.FetchOutbox(ctx, limit)
+}
+
+func (s *Storage) DeleteOutbox(ctx context.Context, seqs []int64) (err error) {
+	defer observe("DeleteOutbox", time.Now(), &err)
+	return s.SegmentStorage.DeleteOutbox(ctx, seqs)
+}
+
+func (s *Storage) NotifyEvents(ctx context.Context, events []models.Event) (err error) {
+	defer observe("NotifyEvents", time.Now(), &err)
+	return s.SegmentStorage.NotifyEvents(ctx, events)
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+	"github.com/iTcatt/segmenter/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) service.SegmentStorage {
+		return NewStorage(memory.NewStorage(), logger.Discard())
+	})
+}
+
+func TestStorage_Operations(t *testing.T) {
+	ctx := context.Background()
+	s := NewStorage(memory.NewStorage(), logger.Discard())
+	created, found := observations(t, "CreateUser", "ok"), observations(t, "GetUser", "ok")
+
+	require.NoError(t, s.CreateUser(ctx, 1000))
+	_, err := s.GetUser(ctx, 1002)
+	require.Error(t, err)
+
+	// A missing user is an expected outcome.
+	assert.Equal(t, created+1, observations(t, "CreateUser", "ok"))
+	assert.Equal(t, found+1, observations(t, "GetUser", "ok"))
+	assert.Zero(t, observations(t, "GetUser", "error"))
+}
+
+func TestStorage_RunMembersGauge(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewStorage()
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_VOICE_MESSAGES"}))
+	require.NoError(t, repo.CreateSegment(ctx, models.Segment{Name: "AVITO_DISCOUNT_30"}))
+	require.NoError(t, repo.CreateUser(ctx, 1000))
+	require.NoError(t, repo.AddUserToSegment(ctx, 1000, "AVITO_VOICE_MESSAGES", nil))
+	s := NewStorage(repo, logger.Discard())
+
+	s.refreshMembers(ctx)
+	expected := `
+		# HELP segmenter_segment_members Number of active members of a segment.
+		# TYPE segmenter_segment_members gauge
+		segmenter_segment_members{segment="AVITO_DISCOUNT_30"} 0
+		segmenter_segment_members{segment="AVITO_VOICE_MESSAGES"} 1
+	`
+	require.NoError(t, testutil.CollectAndCompare(segmentMembers, strings.NewReader(expected)))
+
+	require.NoError(t, repo.DeleteSegment(ctx, "AVITO_DISCOUNT_30"))
+	s.refreshMembers(ctx)
+	assert.Equal(t, 1, testutil.CollectAndCount(segmentMembers))
+}
+
+func observations(t *testing.T, operation, result string) uint64 {
+	var metric dto.Metric
+	histogram := operationDuration.WithLabelValues(operation, result).(prometheus.Histogram)
+	require.NoError(t, histogram.Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolAcquiredConns = prometheus.NewDesc("segmenter_db_pool_acquired_conns",
+		"Number of connections currently in use.", nil, nil)
+	poolIdleConns = prometheus.NewDesc("segmenter_db_pool_idle_conns",
+		"Number of idle connections.", nil, nil)
+	poolTotalConns = prometheus.NewDesc("segmenter_db_pool_total_conns",
+		"Number of open connections.", nil, nil)
+	poolMaxConns = prometheus.NewDesc("segmenter_db_pool_max_conns",
+		"Maximum size of the pool.", nil, nil)
+	poolAcquires = prometheus.NewDesc("segmenter_db_pool_acquires_total",
+		"Number of successful connection acquires.", nil, nil)
+	poolEmptyAcquires = prometheus.NewDesc("segmenter_db_pool_empty_acquires_total",
+		"Number of acquires that waited for a connection.", nil, nil)
+	poolAcquireDuration = prometheus.NewDesc("segmenter_db_pool_acquire_duration_seconds_total",
+		"Total time spent acquiring connections.", nil, nil)
+)
+
+// PoolCollector exposes the connection pool stats of the storage.
+type PoolCollector struct {
+	storage *Storage
+}
+
+func NewPoolCollector(s *Storage) *PoolCollector {
+	return &PoolCollector{storage: s}
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolAcquireDuration
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.storage.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,263 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationLockKey identifies the advisory lock that serializes migrations of concurrent app instances.
+const migrationLockKey = 5_347_728_461
+
+const createSchemaMigrationsSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version BIGINT PRIMARY KEY,
+		name text NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrNoMigrations = errors.New("no applied migrations")
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus describes a known migration and whether it is applied.
+// AppliedAt is nil for pending migrations.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads embedded migrations ordered by version.
+// Each migration is a pair of <version>_<name>.up.sql and <version>_<name>.down.sql files.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file '%s'", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file '%s': %w", entry.Name(), err)
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: '%s' and '%s'", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down scripts", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// MigrateUp applies all pending migrations.
+func (s *Storage) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.up); err != nil {
+					return err
+				}
+				insertSQL := "INSERT INTO schema_migrations(version, name) VALUES($1, $2);"
+				_, err := tx.Exec(ctx, insertSQL, m.version, m.name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("apply migration %d_%s: %w", m.version, m.name, err)
+			}
+			s.log.InfoContext(ctx, "migration applied", "version", m.version, "name", m.name)
+		}
+		return nil
+	})
+}
+
+// MigrateDown rolls back the given number of the latest applied migrations.
+func (s *Storage) MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return s.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return ErrNoMigrations
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1;", m.version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("roll back migration %d_%s: %w", m.version, m.name, err)
+			}
+			s.log.InfoContext(ctx, "migration rolled back", "version", m.version, "name", m.name)
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus returns all known migrations ordered by version.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = s.pool.Exec(ctx, createSchemaMigrationsSQL); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, s.pool)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// CheckMigrations returns an error if any known migration is not applied.
+func (s *Storage) CheckMigrations(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, s.pool)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations are pending", pending)
+	}
+	return nil
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the session advisory lock,
+// so only one app instance changes the schema at a time.
+func (s *Storage) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1);", migrationLockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", migrationLockKey); err != nil {
+			s.log.Error("release migration lock", "error", err)
+		}
+	}()
+
+	if _, err = conn.Exec(ctx, createSchemaMigrationsSQL); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, db querier) (map[int64]time.Time, error) {
+	rows, err := db.Query(ctx, "SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
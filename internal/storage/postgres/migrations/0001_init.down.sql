@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_segment_history;
+DROP TABLE IF EXISTS user_segment;
+DROP TABLE IF EXISTS segment;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,32 @@
+-- Schema previously created by Storage.StartUp. Every statement is idempotent,
+-- so databases created before migrations were introduced are adopted as is.
+CREATE TABLE IF NOT EXISTS users(
+    user_id INT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS segment(
+    segment_id serial PRIMARY KEY,
+    segment_name text NOT NULL
+);
+ALTER TABLE segment ADD COLUMN IF NOT EXISTS auto_percent INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_segment(
+    user_id INT,
+    segment_id INT,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (segment_id) REFERENCES segment (segment_id) ON DELETE CASCADE
+);
+ALTER TABLE user_segment ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS segment_name_idx ON segment (segment_name COLLATE "C");
+CREATE INDEX IF NOT EXISTS user_segment_segment_id_user_id_idx ON user_segment (segment_id, user_id);
+CREATE INDEX IF NOT EXISTS user_segment_user_id_idx ON user_segment (user_id);
+
+CREATE TABLE IF NOT EXISTS user_segment_history(
+    history_id bigserial PRIMARY KEY,
+    user_id INT NOT NULL,
+    segment_name text NOT NULL,
+    operation text NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS user_segment_history_created_at_idx ON user_segment_history (created_at);
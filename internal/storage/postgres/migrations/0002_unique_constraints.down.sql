@@ -0,0 +1,6 @@
+CREATE INDEX IF NOT EXISTS user_segment_user_id_idx ON user_segment (user_id);
+
+ALTER TABLE user_segment DROP CONSTRAINT IF EXISTS user_segment_pkey;
+ALTER TABLE user_segment ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE user_segment ALTER COLUMN segment_id DROP NOT NULL;
+ALTER TABLE segment DROP CONSTRAINT IF EXISTS segment_name_key;
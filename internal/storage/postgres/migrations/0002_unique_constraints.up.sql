@@ -0,0 +1,32 @@
+-- Merge segments with duplicate names into the oldest one.
+UPDATE user_segment us
+SET segment_id = d.keep_id
+FROM (
+    SELECT segment_id, min(segment_id) OVER (PARTITION BY segment_name) AS keep_id
+    FROM segment
+) d
+WHERE us.segment_id = d.segment_id AND d.segment_id <> d.keep_id;
+
+DELETE FROM segment s
+USING segment k
+WHERE s.segment_name = k.segment_name AND s.segment_id > k.segment_id;
+
+-- Keep one membership per user and segment, preferring the one that lasts longer.
+DELETE FROM user_segment
+WHERE ctid IN (
+    SELECT ctid
+    FROM (
+        SELECT ctid, row_number() OVER (
+            PARTITION BY user_id, segment_id
+            ORDER BY expires_at DESC NULLS FIRST
+        ) AS n
+        FROM user_segment
+    ) ranked
+    WHERE ranked.n > 1
+);
+
+ALTER TABLE segment ADD CONSTRAINT segment_name_key UNIQUE (segment_name);
+ALTER TABLE user_segment ADD CONSTRAINT user_segment_pkey PRIMARY KEY (user_id, segment_id);
+
+-- The primary key index covers lookups by user_id.
+DROP INDEX IF EXISTS user_segment_user_id_idx;
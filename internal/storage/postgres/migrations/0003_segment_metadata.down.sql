@@ -0,0 +1,5 @@
+ALTER TABLE segment DROP COLUMN updated_at;
+ALTER TABLE segment DROP COLUMN created_at;
+ALTER TABLE segment DROP COLUMN tags;
+ALTER TABLE segment DROP COLUMN owner;
+ALTER TABLE segment DROP COLUMN description;
@@ -0,0 +1,5 @@
+ALTER TABLE segment ADD COLUMN description text NOT NULL DEFAULT '';
+ALTER TABLE segment ADD COLUMN owner text NOT NULL DEFAULT '';
+ALTER TABLE segment ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+ALTER TABLE segment ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE segment ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,9 @@
+-- Archived entities cannot be represented without deleted_at, so they are deleted for good.
+DELETE FROM segment WHERE deleted_at IS NOT NULL;
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS segment_deleted_at_idx;
+
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE segment DROP COLUMN deleted_at;
@@ -0,0 +1,5 @@
+ALTER TABLE segment ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX segment_deleted_at_idx ON segment (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,18 @@
+CREATE TABLE webhook(
+    webhook_id serial PRIMARY KEY,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE webhook_dead_letter(
+    dead_letter_id bigserial PRIMARY KEY,
+    webhook_id INT NOT NULL,
+    event jsonb NOT NULL,
+    attempts INT NOT NULL,
+    last_error text NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (webhook_id) REFERENCES webhook (webhook_id) ON DELETE CASCADE
+);
+CREATE INDEX webhook_dead_letter_webhook_id_idx ON webhook_dead_letter (webhook_id, dead_letter_id);
@@ -0,0 +1,7 @@
+DROP TRIGGER segment_outbox ON segment;
+DROP TRIGGER users_outbox ON users;
+DROP TRIGGER user_segment_history_outbox ON user_segment_history;
+DROP FUNCTION outbox_segment_event();
+DROP FUNCTION outbox_user_event();
+DROP FUNCTION outbox_membership_event();
+DROP TABLE outbox;
@@ -0,0 +1,68 @@
+-- Events are written by triggers in the transaction of the change they describe,
+-- so a change is never committed without its events.
+CREATE TABLE outbox(
+    seq bigserial PRIMARY KEY,
+    event_type text NOT NULL,
+    user_id INT,
+    segment_name text,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Every membership change is logged to the history, so its events mirror the history records.
+CREATE FUNCTION outbox_membership_event() RETURNS trigger AS $$
+BEGIN
+    IF NEW.operation = 'add' THEN
+        INSERT INTO outbox(event_type, user_id, segment_name, expires_at)
+        SELECT 'membership.added', NEW.user_id, NEW.segment_name, (
+            SELECT us.expires_at
+            FROM user_segment us
+            JOIN segment s ON s.segment_id = us.segment_id
+            WHERE us.user_id = NEW.user_id AND s.segment_name = NEW.segment_name
+        );
+    ELSIF NEW.operation = 'remove' THEN
+        INSERT INTO outbox(event_type, user_id, segment_name)
+        VALUES ('membership.removed', NEW.user_id, NEW.segment_name);
+    ELSE
+        INSERT INTO outbox(event_type, user_id, segment_name, expires_at, created_at)
+        VALUES ('membership.expired', NEW.user_id, NEW.segment_name, NEW.created_at, NEW.created_at);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_segment_history_outbox AFTER INSERT ON user_segment_history
+    FOR EACH ROW EXECUTE FUNCTION outbox_membership_event();
+
+-- Restoring an archived user or segment creates it again.
+CREATE FUNCTION outbox_user_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO outbox(event_type, user_id) VALUES ('user.created', NEW.user_id);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO outbox(event_type, user_id) VALUES ('user.deleted', NEW.user_id);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO outbox(event_type, user_id) VALUES ('user.created', NEW.user_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_outbox AFTER INSERT OR UPDATE OF deleted_at ON users
+    FOR EACH ROW EXECUTE FUNCTION outbox_user_event();
+
+CREATE FUNCTION outbox_segment_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO outbox(event_type, segment_name) VALUES ('segment.created', NEW.segment_name);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO outbox(event_type, segment_name) VALUES ('segment.deleted', NEW.segment_name);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO outbox(event_type, segment_name) VALUES ('segment.created', NEW.segment_name);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER segment_outbox AFTER INSERT OR UPDATE OF deleted_at ON segment
+    FOR EACH ROW EXECUTE FUNCTION outbox_segment_event();
@@ -0,0 +1,12 @@
+-- Events wait here until they are delivered to the webhook or dead-lettered.
+-- A delivery being attempted has next_attempt_at moved ahead, so other app instances skip it.
+CREATE TABLE webhook_delivery(
+    delivery_id bigserial PRIMARY KEY,
+    webhook_id INT NOT NULL,
+    event jsonb NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (webhook_id) REFERENCES webhook (webhook_id) ON DELETE CASCADE
+);
+CREATE INDEX webhook_delivery_next_attempt_at_idx ON webhook_delivery (next_attempt_at);
@@ -0,0 +1,64 @@
+-- Events of a key are relayed in the order of their seq, which is taken when the event is written,
+-- not when its transaction commits. Writers of the same user or segment are serialized until they commit,
+-- so a later seq of a key is never committed, relayed and deleted before an earlier one.
+CREATE FUNCTION outbox_lock_user(user_id INT) RETURNS void AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(534772846, user_id);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION outbox_lock_segment(segment_name text) RETURNS void AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(534772847, hashtext(segment_name));
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION outbox_membership_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM outbox_lock_user(NEW.user_id);
+    IF NEW.operation = 'add' THEN
+        INSERT INTO outbox(event_type, user_id, segment_name, expires_at)
+        SELECT 'membership.added', NEW.user_id, NEW.segment_name, (
+            SELECT us.expires_at
+            FROM user_segment us
+            JOIN segment s ON s.segment_id = us.segment_id
+            WHERE us.user_id = NEW.user_id AND s.segment_name = NEW.segment_name
+        );
+    ELSIF NEW.operation = 'remove' THEN
+        INSERT INTO outbox(event_type, user_id, segment_name)
+        VALUES ('membership.removed', NEW.user_id, NEW.segment_name);
+    ELSE
+        INSERT INTO outbox(event_type, user_id, segment_name, expires_at, created_at)
+        VALUES ('membership.expired', NEW.user_id, NEW.segment_name, NEW.created_at, NEW.created_at);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION outbox_user_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM outbox_lock_user(NEW.user_id);
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO outbox(event_type, user_id) VALUES ('user.created', NEW.user_id);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO outbox(event_type, user_id) VALUES ('user.deleted', NEW.user_id);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO outbox(event_type, user_id) VALUES ('user.created', NEW.user_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION outbox_segment_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM outbox_lock_segment(NEW.segment_name);
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO outbox(event_type, segment_name) VALUES ('segment.created', NEW.segment_name);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO outbox(event_type, segment_name) VALUES ('segment.deleted', NEW.segment_name);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO outbox(event_type, segment_name) VALUES ('segment.created', NEW.segment_name);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
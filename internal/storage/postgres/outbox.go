@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// outboxLockKey identifies the advisory lock that lets one app instance at a time relay the outbox.
+const outboxLockKey = 5_347_728_462
+
+// LockOutbox makes the caller the only relay of the outbox until unlock is called.
+// It returns false without waiting if another relay holds the lock.
+func (s *Storage) LockOutbox(ctx context.Context) (func(), bool, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var locked bool
+	if err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1);", outboxLockKey).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, false, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, false, nil
+	}
+	return func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", outboxLockKey); err != nil {
+			s.log.Error("release outbox lock", "error", err)
+		}
+		conn.Release()
+	}, true, nil
+}
+
+// FetchOutbox returns up to limit events of the outbox in the order they were written.
+func (s *Storage) FetchOutbox(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	selectSQL := `
+		SELECT seq, event_type, user_id, segment_name, expires_at, created_at
+		FROM outbox
+		ORDER BY seq
+		LIMIT $1;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.OutboxEvent, error) {
+		var (
+			event     models.OutboxEvent
+			eventType string
+			segment   *string
+		)
+		err := row.Scan(
+			&event.Seq,
+			&eventType,
+			&event.Event.UserID,
+			&segment,
+			&event.Event.ExpiresAt,
+			&event.Event.Timestamp,
+		)
+		event.Event.ID = strconv.FormatInt(event.Seq, 10)
+		event.Event.Type = models.EventType(eventType)
+		if segment != nil {
+			event.Event.Segment = *segment
+		}
+		return event, err
+	})
+}
+
+// DeleteOutbox deletes published events from the outbox.
+func (s *Storage) DeleteOutbox(ctx context.Context, seqs []int64) error {
+	_, err := s.db(ctx).Exec(ctx, "DELETE FROM outbox WHERE seq = ANY($1);", seqs)
+	return err
+}
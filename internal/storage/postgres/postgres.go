@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/iTcatt/segmenter/internal/config"
@@ -12,38 +14,54 @@ import (
 	"github.com/iTcatt/segmenter/internal/storage"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
-	createUsersSQL   = `CREATE TABLE if NOT EXISTS users(user_id INT PRIMARY KEY);`
-	createSegmentSQL = `
-		CREATE TABLE if NOT EXISTS segment(
-			segment_id serial PRIMARY KEY,
-			segment_name text NOT NULL
-		);`
-	createUserSegmentSQL = `
-		CREATE TABLE if NOT EXISTS user_segment(
-			user_id INT,
-			segment_id INT,
-			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE ,
-			FOREIGN KEY (segment_id) REFERENCES segment (segment_id) ON DELETE CASCADE
-		);`
-
-	joinUsersAndSegmentSQL = `
-		SELECT s.segment_id 
-		FROM user_segment us
-		JOIN segment s on us.segment_id = s.segment_id
-		WHERE us.user_id = $1 AND s.segment_name = $2;`
+	// removedMembershipColumns are the operation and created_at history columns of a deleted membership:
+	// one that already expired but was not reaped yet is logged as expired at its expiry time.
+	removedMembershipColumns = `
+		CASE WHEN us.expires_at <= now() THEN 'expire' ELSE 'remove' END,
+		LEAST(us.expires_at, now())`
+
+	activeMembershipCondition = `(us.expires_at IS NULL OR us.expires_at > now())`
+
+	// Memberships of archived users and segments are kept for restore but hidden from reads.
+	activeUserCondition    = `EXISTS (SELECT 1 FROM users u WHERE u.user_id = us.user_id AND u.deleted_at IS NULL)`
+	activeSegmentCondition = `EXISTS (SELECT 1 FROM segment s WHERE s.segment_id = us.segment_id AND s.deleted_at IS NULL)`
+
+	segmentColumns = `segment_name, auto_percent, description, owner, tags, created_at, updated_at`
+
+	uniqueViolationCode = "23505"
+
+	// bulkBatchSize bounds the number of users a single bulk membership statement works on.
+	bulkBatchSize = 5000
 )
 
 type Storage struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
+	log  *slog.Logger
 }
 
-func NewStorage(cfg config.DatabaseConfig) (*Storage, error) {
+func NewStorage(cfg config.DatabaseConfig, log *slog.Logger) (*Storage, error) {
 	dbPath := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName)
 
+	poolConfig, err := pgxpool.ParseConfig(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = cfg.MaxConns
+	poolConfig.MinConns = cfg.MinConns
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	ticker := time.NewTicker(1 * time.Second)
 	deadline := time.After(cfg.Timeout)
 	defer ticker.Stop()
@@ -51,119 +69,377 @@ func NewStorage(cfg config.DatabaseConfig) (*Storage, error) {
 	for {
 		select {
 		case <-ticker.C:
-			conn, err := pgx.Connect(context.Background(), dbPath)
-			if err != nil {
+			if err = pool.Ping(context.Background()); err != nil {
 				continue
 			}
 
-			if err = conn.Ping(context.Background()); err != nil {
-				continue
-			}
-
-			log.Println("Successful database connection")
-			return &Storage{conn: conn}, nil
+			log.Info("connected to database", "host", cfg.Host, "name", cfg.DBName)
+			return &Storage{pool: pool, log: log}, nil
 		case <-deadline:
+			pool.Close()
 			return nil, fmt.Errorf("timed out waiting for postgres connection")
 		}
 	}
 }
 
-// StartUp create tables: users, segment, user_segment
+func (s *Storage) Close() {
+	s.pool.Close()
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+// StartUp applies pending schema migrations
 func (s *Storage) StartUp() error {
-	_, err := s.conn.Exec(context.Background(), createUsersSQL)
+	return s.MigrateUp(context.Background())
+}
+
+// CreateSegment creates the segment. It returns storage.ErrAlreadyExist if a segment with the name exists,
+// archived segments included: the name stays taken until the archived segment is restored or purged.
+func (s *Storage) CreateSegment(ctx context.Context, segment models.Segment) error {
+	insertSQL := `
+		INSERT INTO segment(segment_name, auto_percent, description, owner, tags) VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (segment_name) DO NOTHING;`
+	tag, err := s.db(ctx).Exec(ctx, insertSQL,
+		segment.Name, segment.AutoPercent, segment.Description, segment.Owner, nonNilTags(segment.Tags))
 	if err != nil {
 		return err
 	}
-	log.Println("Table users created successfully!")
+	if tag.RowsAffected() == 0 {
+		return storage.ErrAlreadyExist
+	}
+	return nil
+}
 
-	_, err = s.conn.Exec(context.Background(), createSegmentSQL)
+func (s *Storage) GetSegment(ctx context.Context, name string) (models.Segment, error) {
+	selectSQL := `SELECT ` + segmentColumns + ` FROM segment WHERE segment_name = $1 AND deleted_at IS NULL;`
+	segment, err := scanSegment(s.db(ctx).QueryRow(ctx, selectSQL, name))
 	if err != nil {
-		return err
+		return models.Segment{}, notExistIfNoRows(err)
 	}
-	log.Println("Table segment created successfully!")
+	return segment, nil
+}
 
-	_, err = s.conn.Exec(context.Background(), createUserSegmentSQL)
+// UpdateSegment changes the segment metadata and returns the updated segment.
+func (s *Storage) UpdateSegment(ctx context.Context, params models.UpdateSegmentParams) (models.Segment, error) {
+	updateSQL := `
+		UPDATE segment SET
+			description = COALESCE($2, description),
+			owner = COALESCE($3, owner),
+			tags = COALESCE($4, tags),
+			updated_at = now()
+		WHERE segment_name = $1 AND deleted_at IS NULL
+		RETURNING ` + segmentColumns + `;`
+	row := s.db(ctx).QueryRow(ctx, updateSQL, params.Name, params.Description, params.Owner, params.Tags)
+	segment, err := scanSegment(row)
 	if err != nil {
-		return err
+		return models.Segment{}, notExistIfNoRows(err)
 	}
-	log.Println("Table user_segment created successfully!")
-
-	return nil
+	return segment, nil
 }
 
-func (s *Storage) CreateSegment(ctx context.Context, name string) error {
-	isCreated, err := s.isSegmentCreated(ctx, name)
-	if err != nil {
-		return err
+// RenameSegment renames the segment keeping its members and returns the renamed segment.
+// History records made before the rename keep the old name. It returns storage.ErrAlreadyExist
+// if another segment named newName exists, archived or not; renaming the segment to its own name changes nothing.
+func (s *Storage) RenameSegment(ctx context.Context, name, newName string) (models.Segment, error) {
+	if newName == name {
+		return s.GetSegment(ctx, name)
 	}
-	if isCreated {
-		return storage.ErrAlreadyExist
+
+	updateSQL := `
+		UPDATE segment SET segment_name = $2, updated_at = now()
+		WHERE segment_name = $1 AND deleted_at IS NULL
+		RETURNING ` + segmentColumns + `;`
+	segment, err := scanSegment(s.db(ctx).QueryRow(ctx, updateSQL, name, newName))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return models.Segment{}, storage.ErrAlreadyExist
 	}
+	if err != nil {
+		return models.Segment{}, notExistIfNoRows(err)
+	}
+	return segment, nil
+}
 
-	insertSQL := "INSERT INTO segment(segment_name) VALUES($1);"
-	if _, err = s.conn.Exec(ctx, insertSQL, name); err != nil {
-		return err
+// MergeSegments adds active members of the sources to the target and deletes the sources.
+// A user in several sources gets the latest of their expiry times; existing target memberships are kept.
+// It returns IDs of the users added to the target in ascending order.
+func (s *Storage) MergeSegments(ctx context.Context, target string, sources []string) ([]int, error) {
+	var added []int
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		// The row locks keep members from being added to the sources until they are deleted.
+		var targetID int
+		lockSQL := "SELECT segment_id FROM segment WHERE segment_name = $1 AND deleted_at IS NULL FOR UPDATE;"
+		if err := s.db(ctx).QueryRow(ctx, lockSQL, target).Scan(&targetID); err != nil {
+			return notExistIfNoRows(err)
+		}
+		sourcesSQL := `
+			SELECT segment_id FROM segment
+			WHERE segment_name = ANY($1) AND deleted_at IS NULL
+			ORDER BY segment_id
+			FOR UPDATE;`
+		rows, err := s.db(ctx).Query(ctx, sourcesSQL, sources)
+		if err != nil {
+			return err
+		}
+		sourceIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return err
+		}
+		if len(sourceIDs) != countDistinct(sources) {
+			return storage.ErrNotExist
+		}
+
+		expiredSQL := `
+			WITH us AS (
+				DELETE FROM user_segment us
+				WHERE us.segment_id = $1 AND us.expires_at <= now() AND EXISTS (
+					SELECT 1 FROM user_segment src
+					WHERE src.user_id = us.user_id AND src.segment_id = ANY($2)
+						AND (src.expires_at IS NULL OR src.expires_at > now())
+				) AND ` + activeUserCondition + `
+				RETURNING us.user_id, us.expires_at
+			)
+			INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+			SELECT us.user_id, $3, 'expire', us.expires_at
+			FROM us
+			ORDER BY us.user_id;`
+		if _, err = s.db(ctx).Exec(ctx, expiredSQL, targetID, sourceIDs, target); err != nil {
+			return err
+		}
+
+		addSQL := `
+			WITH added AS (
+				INSERT INTO user_segment(user_id, segment_id, expires_at)
+				SELECT us.user_id, $1, CASE WHEN bool_or(us.expires_at IS NULL) THEN NULL ELSE max(us.expires_at) END
+				FROM user_segment us
+				WHERE us.segment_id = ANY($2) AND ` + activeMembershipCondition + ` AND ` + activeUserCondition + `
+				GROUP BY us.user_id
+				ON CONFLICT (user_id, segment_id) DO NOTHING
+				RETURNING user_id
+			)
+			INSERT INTO user_segment_history(user_id, segment_name, operation)
+			SELECT added.user_id, $3, 'add'
+			FROM added
+			ORDER BY added.user_id
+			RETURNING user_id;`
+		rows, err = s.db(ctx).Query(ctx, addSQL, targetID, sourceIDs, target)
+		if err != nil {
+			return err
+		}
+		if added, err = pgx.CollectRows(rows, pgx.RowTo[int]); err != nil {
+			return err
+		}
+		sort.Ints(added)
+
+		for _, source := range sources {
+			if err = s.DeleteSegment(ctx, source); err != nil && !errors.Is(err, storage.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return added, nil
 }
 
+// DeleteSegment archives the segment and logs the removal of all its members to the history.
+// Active memberships are kept, so RestoreSegment brings them back.
 func (s *Storage) DeleteSegment(ctx context.Context, name string) error {
-	log.Println("[DEBUG] Delete segment:", name)
-	tag, err := s.conn.Exec(ctx, "DELETE FROM segment WHERE segment_name = $1", name)
-	if err != nil {
+	s.log.DebugContext(ctx, "delete segment", "segment", name)
+	return s.InTx(ctx, func(ctx context.Context) error {
+		// The row lock keeps members from being added until the segment is archived.
+		var segmentID int
+		lockSQL := "SELECT segment_id FROM segment WHERE segment_name = $1 AND deleted_at IS NULL FOR UPDATE;"
+		if err := s.db(ctx).QueryRow(ctx, lockSQL, name).Scan(&segmentID); err != nil {
+			return notExistIfNoRows(err)
+		}
+
+		historySQL := `
+			INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+			SELECT us.user_id, $2, ` + removedMembershipColumns + `
+			FROM user_segment us
+			WHERE us.segment_id = $1 AND ` + activeUserCondition + `
+			ORDER BY us.user_id;`
+		if _, err := s.db(ctx).Exec(ctx, historySQL, segmentID, name); err != nil {
+			return err
+		}
+		expiredSQL := "DELETE FROM user_segment WHERE segment_id = $1 AND expires_at <= now();"
+		if _, err := s.db(ctx).Exec(ctx, expiredSQL, segmentID); err != nil {
+			return err
+		}
+
+		_, err := s.db(ctx).Exec(ctx, "UPDATE segment SET deleted_at = now() WHERE segment_id = $1", segmentID)
 		return err
-	}
-	if tag.RowsAffected() == 0 {
-		return storage.ErrNotExist
-	}
-	return nil
+	})
 }
 
-func (s *Storage) CreateUser(ctx context.Context, id int) error {
-	var tempUserID int
-	requestSQL := "SELECT user_id FROM users WHERE user_id = $1"
-	row := s.conn.QueryRow(ctx, requestSQL, id)
-	err := row.Scan(&tempUserID)
-	if err == nil {
-		return storage.ErrAlreadyExist
-	}
+// RestoreSegment brings the archived segment back with its memberships that have not expired since
+// and logs them to the history as added.
+func (s *Storage) RestoreSegment(ctx context.Context, name string) error {
+	return s.InTx(ctx, func(ctx context.Context) error {
+		var segmentID int
+		lockSQL := "SELECT segment_id FROM segment WHERE segment_name = $1 AND deleted_at IS NOT NULL FOR UPDATE;"
+		if err := s.db(ctx).QueryRow(ctx, lockSQL, name).Scan(&segmentID); err != nil {
+			return notExistIfNoRows(err)
+		}
 
-	insertSQL := "INSERT INTO users(user_id) VALUES($1)"
-	_, err = s.conn.Exec(ctx, insertSQL, id)
-	if err != nil {
+		// Removal of these memberships was already logged when the segment was archived.
+		expiredSQL := "DELETE FROM user_segment WHERE segment_id = $1 AND expires_at <= now();"
+		if _, err := s.db(ctx).Exec(ctx, expiredSQL, segmentID); err != nil {
+			return err
+		}
+		historySQL := `
+			INSERT INTO user_segment_history(user_id, segment_name, operation)
+			SELECT us.user_id, $2, 'add'
+			FROM user_segment us
+			WHERE us.segment_id = $1 AND ` + activeUserCondition + `
+			ORDER BY us.user_id;`
+		if _, err := s.db(ctx).Exec(ctx, historySQL, segmentID, name); err != nil {
+			return err
+		}
+
+		_, err := s.db(ctx).Exec(ctx, "UPDATE segment SET deleted_at = NULL WHERE segment_id = $1", segmentID)
 		return err
-	}
-	return nil
+	})
 }
 
-func (s *Storage) DeleteUser(ctx context.Context, id int) error {
-	tag, err := s.conn.Exec(ctx, "DELETE FROM users WHERE user_id = $1", id)
+// CreateUser creates the user. It returns storage.ErrAlreadyExist if a user with the ID exists,
+// archived users included: the ID stays taken until the archived user is restored or purged.
+func (s *Storage) CreateUser(ctx context.Context, id int) error {
+	insertSQL := "INSERT INTO users(user_id) VALUES($1) ON CONFLICT (user_id) DO NOTHING"
+	tag, err := s.db(ctx).Exec(ctx, insertSQL, id)
 	if err != nil {
 		return err
 	}
 	if tag.RowsAffected() == 0 {
-		return storage.ErrNotExist
+		return storage.ErrAlreadyExist
 	}
 	return nil
 }
 
-func (s *Storage) AddUserToSegment(ctx context.Context, userID int, segment string) error {
-	segmentID, err := s.getSegmentIDByName(ctx, segment)
-	if err != nil {
+// DeleteUser archives the user and logs the removal from all its segments to the history.
+// Active memberships are kept, so RestoreUser brings them back.
+func (s *Storage) DeleteUser(ctx context.Context, id int) error {
+	return s.InTx(ctx, func(ctx context.Context) error {
+		// The row lock keeps the user from being added to segments until it is archived.
+		var userID int
+		lockSQL := "SELECT user_id FROM users WHERE user_id = $1 AND deleted_at IS NULL FOR UPDATE;"
+		if err := s.db(ctx).QueryRow(ctx, lockSQL, id).Scan(&userID); err != nil {
+			return notExistIfNoRows(err)
+		}
+
+		historySQL := `
+			INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+			SELECT us.user_id, s.segment_name, ` + removedMembershipColumns + `
+			FROM user_segment us
+			JOIN segment s ON s.segment_id = us.segment_id
+			WHERE us.user_id = $1 AND s.deleted_at IS NULL;`
+		if _, err := s.db(ctx).Exec(ctx, historySQL, id); err != nil {
+			return err
+		}
+		expiredSQL := "DELETE FROM user_segment WHERE user_id = $1 AND expires_at <= now();"
+		if _, err := s.db(ctx).Exec(ctx, expiredSQL, id); err != nil {
+			return err
+		}
+
+		_, err := s.db(ctx).Exec(ctx, "UPDATE users SET deleted_at = now() WHERE user_id = $1", id)
 		return err
-	}
+	})
+}
 
-	var tempSegmentID int
-	row := s.conn.QueryRow(ctx, joinUsersAndSegmentSQL, userID, segmentID)
-	if err := row.Scan(&tempSegmentID); err == nil {
-		return storage.ErrAlreadyExist
+// RestoreUser brings the archived user back with its memberships that have not expired since
+// and logs them to the history as added.
+func (s *Storage) RestoreUser(ctx context.Context, id int) error {
+	return s.InTx(ctx, func(ctx context.Context) error {
+		var userID int
+		lockSQL := "SELECT user_id FROM users WHERE user_id = $1 AND deleted_at IS NOT NULL FOR UPDATE;"
+		if err := s.db(ctx).QueryRow(ctx, lockSQL, id).Scan(&userID); err != nil {
+			return notExistIfNoRows(err)
+		}
+
+		// Removal of these memberships was already logged when the user was archived.
+		expiredSQL := "DELETE FROM user_segment WHERE user_id = $1 AND expires_at <= now();"
+		if _, err := s.db(ctx).Exec(ctx, expiredSQL, id); err != nil {
+			return err
+		}
+		historySQL := `
+			INSERT INTO user_segment_history(user_id, segment_name, operation)
+			SELECT us.user_id, s.segment_name, 'add'
+			FROM user_segment us
+			JOIN segment s ON s.segment_id = us.segment_id
+			WHERE us.user_id = $1 AND s.deleted_at IS NULL;`
+		if _, err := s.db(ctx).Exec(ctx, historySQL, id); err != nil {
+			return err
+		}
+
+		_, err := s.db(ctx).Exec(ctx, "UPDATE users SET deleted_at = NULL WHERE user_id = $1", id)
+		return err
+	})
+}
+
+// PurgeArchived deletes for good segments and users archived before the given time, together with their memberships.
+// It returns the number of purged segments and users.
+func (s *Storage) PurgeArchived(ctx context.Context, before time.Time) (int64, error) {
+	purgeSQL := `
+		WITH purged_segments AS (
+			DELETE FROM segment WHERE deleted_at < $1 RETURNING 1
+		), purged_users AS (
+			DELETE FROM users WHERE deleted_at < $1 RETURNING 1
+		)
+		SELECT (SELECT count(*) FROM purged_segments) + (SELECT count(*) FROM purged_users);`
+	var purged int64
+	if err := s.db(ctx).QueryRow(ctx, purgeSQL, before).Scan(&purged); err != nil {
+		return 0, err
 	}
+	return purged, nil
+}
 
-	_, err = s.conn.Exec(ctx, "INSERT INTO user_segment(user_id, segment_id) VALUES($1, $2);", userID, segmentID)
+// AddUserToSegment adds the user to the segment until expiresAt, or permanently if expiresAt is nil.
+// An expired membership that was not reaped yet is replaced.
+// It returns storage.ErrNotExist if either the user or the segment does not exist.
+func (s *Storage) AddUserToSegment(ctx context.Context, userID int, segment string, expiresAt *time.Time) error {
+	segmentID, err := s.getSegmentIDByName(ctx, segment)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return s.InTx(ctx, func(ctx context.Context) error {
+		// Checked up front: a foreign key violation would abort the whole transaction.
+		var tempUserID int
+		userSQL := "SELECT user_id FROM users WHERE user_id = $1 AND deleted_at IS NULL FOR KEY SHARE;"
+		if err := s.db(ctx).QueryRow(ctx, userSQL, userID).Scan(&tempUserID); err != nil {
+			return notExistIfNoRows(err)
+		}
+
+		expiredSQL := `
+			WITH us AS (
+				DELETE FROM user_segment WHERE user_id = $1 AND segment_id = $2 AND expires_at <= now()
+				RETURNING user_id, expires_at
+			)
+			INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+			SELECT us.user_id, $3, 'expire', us.expires_at
+			FROM us;`
+		if _, err := s.db(ctx).Exec(ctx, expiredSQL, userID, segmentID, segment); err != nil {
+			return err
+		}
+
+		insertSQL := `
+			INSERT INTO user_segment(user_id, segment_id, expires_at) VALUES($1, $2, $3)
+			ON CONFLICT (user_id, segment_id) DO NOTHING;`
+		tag, err := s.db(ctx).Exec(ctx, insertSQL, userID, segmentID, expiresAt)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrAlreadyExist
+		}
+		return s.addHistory(ctx, userID, segment, models.OperationAdd)
+	})
 }
 
 func (s *Storage) DeleteUserFromSegment(ctx context.Context, userID int, segment string) error {
@@ -172,17 +448,147 @@ func (s *Storage) DeleteUserFromSegment(ctx context.Context, userID int, segment
 		return err
 	}
 
-	deleteSQL := "DELETE FROM user_segment us WHERE us.user_id = $1 AND us.segment_id = $2"
-	_, err = s.conn.Exec(ctx, deleteSQL, userID, segmentID)
+	deleteSQL := `
+		WITH us AS (
+			DELETE FROM user_segment us
+			WHERE us.user_id = $1 AND us.segment_id = $2 AND ` + activeUserCondition + `
+			RETURNING us.user_id, us.expires_at
+		)
+		INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+		SELECT us.user_id, $3, ` + removedMembershipColumns + `
+		FROM us;`
+	_, err = s.db(ctx).Exec(ctx, deleteSQL, userID, segmentID, segment)
+	return err
+}
+
+// AddUsersToSegment adds the users to the segment until expiresAt, or permanently if expiresAt is nil.
+// Users are processed in batches within one transaction; expired memberships that were not reaped yet are replaced.
+// It returns storage.ErrNotExist if the segment does not exist.
+func (s *Storage) AddUsersToSegment(
+	ctx context.Context, segment string, userIDs []int, expiresAt *time.Time,
+) (models.BulkMembershipResult, error) {
+	result := models.BulkMembershipResult{Changed: []int{}, Missing: []int{}}
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		segmentID, err := s.lockSegment(ctx, segment)
+		if err != nil {
+			return err
+		}
+
+		// Users go in ascending order, as the outbox locks of their events are taken,
+		// so concurrent bulk changes of other segments do not deadlock.
+		ids := distinct(userIDs)
+		found := make([]int, 0, len(ids))
+		for _, batch := range batches(sorted(ids), bulkBatchSize) {
+			existing, err := s.lockUsers(ctx, batch)
+			if err != nil {
+				return err
+			}
+			found = append(found, existing...)
+
+			expiredSQL := `
+				WITH us AS (
+					DELETE FROM user_segment
+					WHERE segment_id = $1 AND user_id = ANY($2) AND expires_at <= now()
+					RETURNING user_id, expires_at
+				)
+				INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+				SELECT us.user_id, $3, 'expire', us.expires_at
+				FROM us
+				ORDER BY us.user_id;`
+			if _, err = s.db(ctx).Exec(ctx, expiredSQL, segmentID, existing, segment); err != nil {
+				return err
+			}
+
+			insertSQL := `
+				WITH added AS (
+					INSERT INTO user_segment(user_id, segment_id, expires_at)
+					SELECT user_id, $1, $3 FROM unnest($2::int[]) AS user_id
+					ON CONFLICT (user_id, segment_id) DO NOTHING
+					RETURNING user_id
+				), history AS (
+					INSERT INTO user_segment_history(user_id, segment_name, operation)
+					SELECT added.user_id, $4, 'add'
+					FROM added
+					ORDER BY added.user_id
+				)
+				SELECT user_id FROM added ORDER BY user_id;`
+			rows, err := s.db(ctx).Query(ctx, insertSQL, segmentID, existing, expiresAt, segment)
+			if err != nil {
+				return err
+			}
+			added, err := pgx.CollectRows(rows, pgx.RowTo[int])
+			if err != nil {
+				return err
+			}
+			result.Changed = append(result.Changed, added...)
+		}
+		result.Missing = missing(ids, found)
+		return nil
+	})
 	if err != nil {
-		return err
+		return models.BulkMembershipResult{}, err
 	}
-	return nil
+	return result, nil
+}
+
+// DeleteUsersFromSegment removes the users from the segment in batches within one transaction.
+// Expired memberships that were not reaped yet are removed too, but are not reported as changed.
+// It returns storage.ErrNotExist if the segment does not exist.
+func (s *Storage) DeleteUsersFromSegment(
+	ctx context.Context, segment string, userIDs []int,
+) (models.BulkMembershipResult, error) {
+	result := models.BulkMembershipResult{Changed: []int{}, Missing: []int{}}
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		segmentID, err := s.lockSegment(ctx, segment)
+		if err != nil {
+			return err
+		}
+
+		// Users go in ascending order, as the outbox locks of their events are taken,
+		// so concurrent bulk changes of other segments do not deadlock.
+		ids := distinct(userIDs)
+		found := make([]int, 0, len(ids))
+		for _, batch := range batches(sorted(ids), bulkBatchSize) {
+			existing, err := s.lockUsers(ctx, batch)
+			if err != nil {
+				return err
+			}
+			found = append(found, existing...)
+
+			deleteSQL := `
+				WITH us AS (
+					DELETE FROM user_segment
+					WHERE segment_id = $1 AND user_id = ANY($2)
+					RETURNING user_id, expires_at
+				), history AS (
+					INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+					SELECT us.user_id, $3, ` + removedMembershipColumns + `
+					FROM us
+					ORDER BY us.user_id
+				)
+				SELECT us.user_id FROM us WHERE ` + activeMembershipCondition + ` ORDER BY us.user_id;`
+			rows, err := s.db(ctx).Query(ctx, deleteSQL, segmentID, existing, segment)
+			if err != nil {
+				return err
+			}
+			removed, err := pgx.CollectRows(rows, pgx.RowTo[int])
+			if err != nil {
+				return err
+			}
+			result.Changed = append(result.Changed, removed...)
+		}
+		result.Missing = missing(ids, found)
+		return nil
+	})
+	if err != nil {
+		return models.BulkMembershipResult{}, err
+	}
+	return result, nil
 }
 
 func (s *Storage) GetUser(ctx context.Context, id int) (models.User, error) {
 	var tempUserID int
-	row := s.conn.QueryRow(ctx, "SELECT user_id FROM users WHERE user_id = $1", id)
+	row := s.db(ctx).QueryRow(ctx, "SELECT user_id FROM users WHERE user_id = $1 AND deleted_at IS NULL", id)
 	err := row.Scan(&tempUserID)
 	if err != nil {
 		return models.User{}, storage.ErrNotExist
@@ -192,45 +598,264 @@ func (s *Storage) GetUser(ctx context.Context, id int) (models.User, error) {
 		Segments: []string{},
 	}
 	getSegmentsSQL := `
-		SELECT s.segment_name 
+		SELECT s.segment_name, us.expires_at
 		FROM segment s
 		JOIN user_segment us ON s.segment_id = us.segment_id
-		WHERE us.user_id = $1;`
+		WHERE us.user_id = $1 AND s.deleted_at IS NULL AND ` + activeMembershipCondition + `;`
 
-	rows, err := s.conn.Query(ctx, getSegmentsSQL, id)
+	rows, err := s.db(ctx).Query(ctx, getSegmentsSQL, id)
 	if err != nil {
 		return models.User{}, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var segmentName string
-		err := rows.Scan(&segmentName)
+		var (
+			segmentName string
+			expiresAt   *time.Time
+		)
+		err := rows.Scan(&segmentName, &expiresAt)
 		if err != nil {
 			return models.User{}, err
 		}
 		user.Segments = append(user.Segments, segmentName)
+		if expiresAt != nil && (user.NextExpiry == nil || expiresAt.Before(*user.NextExpiry)) {
+			user.NextExpiry = expiresAt
+		}
 	}
 
 	return user, nil
 }
 
-func (s *Storage) IsUserCreated(ctx context.Context, userID int) (bool, error) {
-	row := s.conn.QueryRow(ctx, "SELECT 1 FROM users WHERE user_id = $1", userID)
-	err := row.Scan(&userID)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return false, nil
+// GetUsersSegments returns segments of many users in one query. Users that do not exist are reported as missing.
+func (s *Storage) GetUsersSegments(ctx context.Context, userIDs []int) (models.UsersSegments, error) {
+	selectSQL := `
+		SELECT u.user_id,
+			COALESCE(
+				array_agg(s.segment_name ORDER BY s.segment_name COLLATE "C") FILTER (WHERE s.segment_name IS NOT NULL),
+				'{}'
+			),
+			min(us.expires_at) FILTER (WHERE s.segment_name IS NOT NULL)
+		FROM users u
+		LEFT JOIN user_segment us ON us.user_id = u.user_id AND ` + activeMembershipCondition + `
+		LEFT JOIN segment s ON s.segment_id = us.segment_id AND s.deleted_at IS NULL
+		WHERE u.user_id = ANY($1) AND u.deleted_at IS NULL
+		GROUP BY u.user_id;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, userIDs)
+	if err != nil {
+		return models.UsersSegments{}, err
+	}
+	defer rows.Close()
+
+	result := models.UsersSegments{Segments: make(map[int][]string), NextExpiry: make(map[int]time.Time)}
+	found := make([]int, 0, len(userIDs))
+	for rows.Next() {
+		var (
+			userID     int
+			segments   []string
+			nextExpiry *time.Time
+		)
+		if err = rows.Scan(&userID, &segments, &nextExpiry); err != nil {
+			return models.UsersSegments{}, err
+		}
+		result.Segments[userID] = segments
+		if nextExpiry != nil {
+			result.NextExpiry[userID] = *nextExpiry
+		}
+		found = append(found, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return models.UsersSegments{}, err
 	}
+	result.Missing = missing(distinct(userIDs), found)
+	return result, nil
+}
+
+// DeleteExpiredSegments removes all expired memberships and returns the history records of the removals.
+// Every removal is logged to the history as expired at the membership expiry time.
+// Memberships of archived users and segments are left to RestoreUser, RestoreSegment and PurgeArchived.
+func (s *Storage) DeleteExpiredSegments(ctx context.Context) ([]models.HistoryRecord, error) {
+	deleteSQL := `
+		WITH us AS (
+			DELETE FROM user_segment us
+			WHERE us.expires_at <= now() AND ` + activeUserCondition + ` AND ` + activeSegmentCondition + `
+			RETURNING us.user_id, us.segment_id, us.expires_at
+		)
+		INSERT INTO user_segment_history(user_id, segment_name, operation, created_at)
+		SELECT us.user_id, s.segment_name, 'expire', us.expires_at
+		FROM us
+		JOIN segment s ON s.segment_id = us.segment_id
+		ORDER BY us.user_id
+		RETURNING user_id, segment_name, operation, created_at;`
+	rows, err := s.db(ctx).Query(ctx, deleteSQL)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.HistoryRecord, error) {
+		var record models.HistoryRecord
+		err := row.Scan(&record.UserID, &record.Segment, &record.Operation, &record.Timestamp)
+		return record, err
+	})
+}
+
+// GetHistory returns membership changes made in [from, to) ordered by time.
+func (s *Storage) GetHistory(ctx context.Context, from, to time.Time) ([]models.HistoryRecord, error) {
+	selectSQL := `
+		SELECT user_id, segment_name, operation, created_at
+		FROM user_segment_history
+		WHERE created_at >= $1 AND created_at < $2
+		ORDER BY created_at, history_id;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]models.HistoryRecord, 0)
+	for rows.Next() {
+		var record models.HistoryRecord
+		err := rows.Scan(&record.UserID, &record.Segment, &record.Operation, &record.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *Storage) GetUserIDs(ctx context.Context) ([]int, error) {
+	rows, err := s.db(ctx).Query(ctx, "SELECT user_id FROM users WHERE deleted_at IS NULL ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+// GetAutoSegments returns segments that automatically enroll a share of users.
+func (s *Storage) GetAutoSegments(ctx context.Context) ([]models.Segment, error) {
+	selectSQL := `
+		SELECT ` + segmentColumns + `
+		FROM segment
+		WHERE auto_percent > 0 AND deleted_at IS NULL
+		ORDER BY segment_name COLLATE "C";`
+	rows, err := s.db(ctx).Query(ctx, selectSQL)
+	if err != nil {
+		return nil, err
+	}
+	return collectSegments(rows)
+}
+
+// ListSegments returns up to limit segments whose names start with prefix and follow after, ordered by name.
+func (s *Storage) ListSegments(ctx context.Context, prefix, after string, limit int) ([]models.Segment, error) {
+	selectSQL := `
+		SELECT ` + segmentColumns + `
+		FROM segment
+		WHERE segment_name COLLATE "C" > $1 AND segment_name COLLATE "C" LIKE $2 AND deleted_at IS NULL
+		ORDER BY segment_name COLLATE "C"
+		LIMIT $3;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, after, escapeLike(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectSegments(rows)
+}
+
+// GetSegmentMembers returns up to limit members of the segment with ID greater than after, ordered by ID.
+func (s *Storage) GetSegmentMembers(
+	ctx context.Context, segment string, after *int, limit int,
+) ([]models.SegmentMember, error) {
+	segmentID, err := s.getSegmentIDByName(ctx, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	selectSQL := `
+		SELECT us.user_id, us.expires_at
+		FROM user_segment us
+		WHERE us.segment_id = $1 AND ($2::int IS NULL OR us.user_id > $2)
+			AND ` + activeMembershipCondition + ` AND ` + activeUserCondition + `
+		ORDER BY us.user_id
+		LIMIT $3;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, segmentID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.SegmentMember, error) {
+		var member models.SegmentMember
+		err := row.Scan(&member.UserID, &member.ExpiresAt)
+		return member, err
+	})
 }
 
-func (s *Storage) isSegmentCreated(ctx context.Context, segmentName string) (bool, error) {
-	var temp int
-	row := s.conn.QueryRow(ctx, "SELECT 1 FROM segment WHERE segment_name = $1", segmentName)
-	err := row.Scan(&temp)
+// GetSegmentUsers returns up to limit IDs of the segment members greater than after, ordered by ID.
+func (s *Storage) GetSegmentUsers(ctx context.Context, segment string, after *int, limit int) ([]int, error) {
+	segmentID, err := s.getSegmentIDByName(ctx, segment)
+	if err != nil {
+		return nil, err
+	}
+
+	selectSQL := `
+		SELECT us.user_id
+		FROM user_segment us
+		WHERE us.segment_id = $1 AND ($2::int IS NULL OR us.user_id > $2)
+			AND ` + activeMembershipCondition + ` AND ` + activeUserCondition + `
+		ORDER BY us.user_id
+		LIMIT $3;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, segmentID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+func (s *Storage) CountSegmentUsers(ctx context.Context, segment string) (int, error) {
+	segmentID, err := s.getSegmentIDByName(ctx, segment)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	countSQL := `
+		SELECT count(*) FROM user_segment us
+		WHERE us.segment_id = $1 AND ` + activeMembershipCondition + ` AND ` + activeUserCondition
+	if err = s.db(ctx).QueryRow(ctx, countSQL, segmentID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountMembers returns the number of members of every segment.
+func (s *Storage) CountMembers(ctx context.Context) (map[string]int, error) {
+	countSQL := `
+		SELECT s.segment_name, count(us.user_id)
+		FROM segment s
+		LEFT JOIN user_segment us ON us.segment_id = s.segment_id
+			AND ` + activeMembershipCondition + ` AND ` + activeUserCondition + `
+		WHERE s.deleted_at IS NULL
+		GROUP BY s.segment_name;`
+	rows, err := s.db(ctx).Query(ctx, countSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			segment string
+			count   int
+		)
+		if err = rows.Scan(&segment, &count); err != nil {
+			return nil, err
+		}
+		counts[segment] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *Storage) IsUserCreated(ctx context.Context, userID int) (bool, error) {
+	row := s.db(ctx).QueryRow(ctx, "SELECT 1 FROM users WHERE user_id = $1 AND deleted_at IS NULL", userID)
+	err := row.Scan(&userID)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return false, nil
 	}
@@ -242,9 +867,142 @@ func (s *Storage) isSegmentCreated(ctx context.Context, segmentName string) (boo
 
 func (s *Storage) getSegmentIDByName(ctx context.Context, name string) (int, error) {
 	var segmentID int
-	row := s.conn.QueryRow(ctx, `SELECT segment_id FROM segment WHERE segment_name = $1;`, name)
+	row := s.db(ctx).QueryRow(ctx, `SELECT segment_id FROM segment WHERE segment_name = $1 AND deleted_at IS NULL;`, name)
 	if err := row.Scan(&segmentID); err != nil {
 		return 0, storage.ErrNotExist
 	}
 	return segmentID, nil
 }
+
+// lockSegment returns the ID of the segment and keeps it from being archived until the transaction ends.
+func (s *Storage) lockSegment(ctx context.Context, name string) (int, error) {
+	var segmentID int
+	lockSQL := "SELECT segment_id FROM segment WHERE segment_name = $1 AND deleted_at IS NULL FOR KEY SHARE;"
+	if err := s.db(ctx).QueryRow(ctx, lockSQL, name).Scan(&segmentID); err != nil {
+		return 0, notExistIfNoRows(err)
+	}
+	return segmentID, nil
+}
+
+// lockUsers returns the given users that exist and keeps them from being archived until the transaction ends.
+func (s *Storage) lockUsers(ctx context.Context, ids []int) ([]int, error) {
+	lockSQL := `
+		SELECT user_id FROM users
+		WHERE user_id = ANY($1) AND deleted_at IS NULL
+		ORDER BY user_id
+		FOR KEY SHARE;`
+	rows, err := s.db(ctx).Query(ctx, lockSQL, ids)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+var likeReplacer = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(pattern string) string {
+	return likeReplacer.Replace(pattern)
+}
+
+func (s *Storage) addHistory(ctx context.Context, userID int, segment string, operation models.Operation) error {
+	insertSQL := "INSERT INTO user_segment_history(user_id, segment_name, operation) VALUES($1, $2, $3);"
+	_, err := s.db(ctx).Exec(ctx, insertSQL, userID, segment, operation)
+	return err
+}
+
+// scanSegment scans a row of segmentColumns.
+func scanSegment(row pgx.Row) (models.Segment, error) {
+	var segment models.Segment
+	err := row.Scan(
+		&segment.Name,
+		&segment.AutoPercent,
+		&segment.Description,
+		&segment.Owner,
+		&segment.Tags,
+		&segment.CreatedAt,
+		&segment.UpdatedAt,
+	)
+	return segment, err
+}
+
+func collectSegments(rows pgx.Rows) ([]models.Segment, error) {
+	defer rows.Close()
+
+	segments := make([]models.Segment, 0)
+	for rows.Next() {
+		segment, err := scanSegment(rows)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, rows.Err()
+}
+
+func distinct(ids []int) []int {
+	seen := make(map[int]struct{}, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func batches(ids []int, size int) [][]int {
+	result := make([][]int, 0, len(ids)/size+1)
+	for len(ids) > size {
+		result = append(result, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		result = append(result, ids)
+	}
+	return result
+}
+
+// sorted returns a copy of ids in ascending order.
+func sorted(ids []int) []int {
+	result := append([]int(nil), ids...)
+	sort.Ints(result)
+	return result
+}
+
+// missing returns ids that are not in existing.
+func missing(ids, existing []int) []int {
+	found := make(map[int]struct{}, len(existing))
+	for _, id := range existing {
+		found[id] = struct{}{}
+	}
+	result := make([]int, 0)
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func countDistinct(values []string) int {
+	seen := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		seen[value] = struct{}{}
+	}
+	return len(seen)
+}
+
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+func notExistIfNoRows(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/config"
+	"github.com/iTcatt/segmenter/internal/logger"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/storagetest"
+)
+
+const truncateSQL = `
+	TRUNCATE users, segment, user_segment, user_segment_history, webhook, webhook_dead_letter, outbox
+	RESTART IDENTITY CASCADE;`
+
+// TestStorage runs the conformance suite against the database from TEST_DB_* variables.
+// It is skipped unless TEST_DB_HOST is set; the database is truncated before every test.
+func TestStorage(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set")
+	}
+
+	db, err := NewStorage(config.DatabaseConfig{
+		Host:     host,
+		Port:     getenv("TEST_DB_PORT", "5432"),
+		DBName:   getenv("TEST_DB_NAME", "postgres"),
+		User:     getenv("TEST_DB_USER", "postgres"),
+		Password: getenv("TEST_DB_PASSWORD", "postgres"),
+		Timeout:  10 * time.Second,
+		MaxConns: 10,
+	}, logger.Discard())
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	require.NoError(t, db.MigrateUp(context.Background()))
+
+	storagetest.Run(t, func(t *testing.T) service.SegmentStorage {
+		_, err := db.pool.Exec(context.Background(), truncateSQL)
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func TestBatches(t *testing.T) {
+	require.Empty(t, batches(nil, 2))
+	require.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, batches([]int{1, 2, 3, 4, 5}, 2))
+	require.Equal(t, [][]int{{1, 2}}, batches([]int{1, 2}, 2))
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/iTcatt/segmenter/internal/models"
+)
+
+// eventsChannel is the notification channel that broadcasts published events to all app instances.
+const eventsChannel = "segmenter_events"
+
+// NotifyEvents broadcasts the events to the listeners of all app instances in their order.
+func (s *Storage) NotifyEvents(ctx context.Context, events []models.Event) error {
+	payloads := make([]string, 0, len(events))
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, string(payload))
+	}
+	notifySQL := "SELECT pg_notify($1, payload) FROM unnest($2::text[]) AS payload;"
+	_, err := s.db(ctx).Exec(ctx, notifySQL, eventsChannel, payloads)
+	return err
+}
+
+// ListenEvents calls handle for every broadcast event until ctx is done or the connection fails.
+// Events broadcast before the listener is registered are not received.
+func (s *Storage) ListenEvents(ctx context.Context, handle func(models.Event)) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, "LISTEN "+eventsChannel+";"); err != nil {
+		return err
+	}
+	defer func() {
+		// A connection broken by the cancelled wait is discarded by the pool anyway.
+		if _, err := conn.Exec(context.Background(), "UNLISTEN *;"); err != nil && !conn.Conn().IsClosed() {
+			s.log.Error("unlisten events", "error", err)
+		}
+	}()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var event models.Event
+		if err = json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			s.log.ErrorContext(ctx, "decode event notification", "error", err)
+			continue
+		}
+		handle(event)
+	}
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/iTcatt/segmenter/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// querier is implemented by the pool, acquired connections and transactions.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+// InTx runs fn in a transaction that is committed if fn returns nil and rolled back otherwise.
+// Storage methods called with the ctx passed to fn take part in the transaction.
+// A nested InTx joins the outer transaction.
+// With a ctx from storage.ReadOnly the transaction is read-only and repeatable read.
+func (s *Storage) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return fn(ctx)
+	}
+
+	var options pgx.TxOptions
+	if storage.IsReadOnly(ctx) {
+		options = pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+	}
+	tx, err := s.pool.BeginTx(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(context.Background()) }()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// db returns the transaction started by InTx or the pool outside of it.
+func (s *Storage) db(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return s.pool
+}
@@ -0,0 +1,223 @@
+package postgres
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// CreateWebhook saves the webhook and returns it with the assigned ID and creation time.
+func (s *Storage) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	insertSQL := `
+		INSERT INTO webhook(url, secret, events) VALUES($1, $2, $3)
+		RETURNING webhook_id, created_at;`
+	err := s.db(ctx).QueryRow(ctx, insertSQL, webhook.URL, webhook.Secret, eventNames(webhook.Events)).
+		Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	webhook.Events = nonNilEvents(webhook.Events)
+	return webhook, nil
+}
+
+// ListWebhooks returns all webhooks with their secrets ordered by ID.
+func (s *Storage) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	selectSQL := "SELECT webhook_id, url, secret, events, created_at FROM webhook ORDER BY webhook_id;"
+	rows, err := s.db(ctx).Query(ctx, selectSQL)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Webhook, error) {
+		var webhook models.Webhook
+		var events []string
+		err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &events, &webhook.CreatedAt)
+		webhook.Events = make([]models.EventType, 0, len(events))
+		for _, event := range events {
+			webhook.Events = append(webhook.Events, models.EventType(event))
+		}
+		return webhook, err
+	})
+}
+
+// DeleteWebhook deletes the webhook with its dead letters and queued deliveries.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int) error {
+	tag, err := s.db(ctx).Exec(ctx, "DELETE FROM webhook WHERE webhook_id = $1;", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotExist
+	}
+	return nil
+}
+
+// AddDeadLetter saves an undelivered event. It returns storage.ErrNotExist if the webhook was deleted.
+func (s *Storage) AddDeadLetter(ctx context.Context, letter models.DeadLetter) error {
+	insertSQL := `
+		INSERT INTO webhook_dead_letter(webhook_id, event, attempts, last_error, failed_at)
+		SELECT webhook_id, $2, $3, $4, $5 FROM webhook WHERE webhook_id = $1;`
+	tag, err := s.db(ctx).Exec(ctx, insertSQL,
+		letter.WebhookID, letter.Event, letter.Attempts, letter.LastError, letter.FailedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotExist
+	}
+	return nil
+}
+
+// ListDeadLetters returns dead letters of the webhook in the order they were added.
+func (s *Storage) ListDeadLetters(ctx context.Context, webhookID int) ([]models.DeadLetter, error) {
+	if err := s.checkWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	selectSQL := `
+		SELECT dead_letter_id, webhook_id, event, attempts, last_error, failed_at
+		FROM webhook_dead_letter
+		WHERE webhook_id = $1
+		ORDER BY dead_letter_id;`
+	rows, err := s.db(ctx).Query(ctx, selectSQL, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanDeadLetter)
+}
+
+// DeleteDeadLetter deletes the dead letter. It returns storage.ErrNotExist if there is no dead letter with the ID.
+func (s *Storage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	tag, err := s.db(ctx).Exec(ctx, "DELETE FROM webhook_dead_letter WHERE dead_letter_id = $1;", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotExist
+	}
+	return nil
+}
+
+// AddWebhookDelivery queues the delivery. It returns storage.ErrNotExist if the webhook was deleted.
+func (s *Storage) AddWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	insertSQL := `
+		INSERT INTO webhook_delivery(webhook_id, event, attempts, last_error, next_attempt_at)
+		SELECT webhook_id, $2, $3, $4, $5 FROM webhook WHERE webhook_id = $1;`
+	tag, err := s.db(ctx).Exec(ctx, insertSQL,
+		delivery.WebhookID, delivery.Event, delivery.Attempts, delivery.LastError, delivery.NextAttemptAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotExist
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries due at now in the order they were queued
+// and postpones them until lockedUntil. Deliveries claimed by a concurrent call are skipped.
+func (s *Storage) ClaimWebhookDeliveries(
+	ctx context.Context, now, lockedUntil time.Time, limit int,
+) ([]models.WebhookDelivery, error) {
+	updateSQL := `
+		UPDATE webhook_delivery SET next_attempt_at = $2
+		WHERE delivery_id IN (
+			SELECT delivery_id FROM webhook_delivery
+			WHERE next_attempt_at <= $1
+			ORDER BY delivery_id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING delivery_id, webhook_id, event, attempts, last_error, next_attempt_at;`
+	rows, err := s.db(ctx).Query(ctx, updateSQL, now, lockedUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.WebhookDelivery, error) {
+		var delivery models.WebhookDelivery
+		err := row.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Attempts,
+			&delivery.LastError,
+			&delivery.NextAttemptAt,
+		)
+		return delivery, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING keeps no order.
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery saves the attempts, the last error and the next attempt time of the delivery.
+// It returns storage.ErrNotExist if the delivery was deleted.
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	updateSQL := `
+		UPDATE webhook_delivery SET attempts = $2, last_error = $3, next_attempt_at = $4
+		WHERE delivery_id = $1;`
+	tag, err := s.db(ctx).Exec(ctx, updateSQL,
+		delivery.ID, delivery.Attempts, delivery.LastError, delivery.NextAttemptAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotExist
+	}
+	return nil
+}
+
+// DeleteWebhookDelivery deletes the delivery. It returns storage.ErrNotExist if there is no delivery with the ID.
+func (s *Storage) DeleteWebhookDelivery(ctx context.Context, id int64) error {
+	tag, err := s.db(ctx).Exec(ctx, "DELETE FROM webhook_delivery WHERE delivery_id = $1;", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotExist
+	}
+	return nil
+}
+
+// checkWebhook returns storage.ErrNotExist if the webhook does not exist.
+// The row lock keeps the webhook from being deleted until the transaction ends.
+func (s *Storage) checkWebhook(ctx context.Context, id int) error {
+	var exists int
+	err := s.db(ctx).QueryRow(ctx, "SELECT 1 FROM webhook WHERE webhook_id = $1 FOR KEY SHARE;", id).Scan(&exists)
+	return notExistIfNoRows(err)
+}
+
+func scanDeadLetter(row pgx.CollectableRow) (models.DeadLetter, error) {
+	var letter models.DeadLetter
+	err := row.Scan(
+		&letter.ID,
+		&letter.WebhookID,
+		&letter.Event,
+		&letter.Attempts,
+		&letter.LastError,
+		&letter.FailedAt,
+	)
+	return letter, err
+}
+
+func eventNames(events []models.EventType) []string {
+	names := make([]string, 0, len(events))
+	for _, event := range events {
+		names = append(names, string(event))
+	}
+	return names
+}
+
+func nonNilEvents(events []models.EventType) []models.EventType {
+	if events == nil {
+		return []models.EventType{}
+	}
+	return events
+}
@@ -1,9 +1,31 @@
 package storage
 
 import (
+	"context"
 	"errors"
 )
 
 var ErrAlreadyExist = errors.New("already exist")
 var ErrNotExist = errors.New("not exist")
 var ErrNotCreated = errors.New("not created")
+
+// IsExpected reports whether err is an outcome of a valid call rather than a failure of the storage:
+// missing and duplicate users and segments are caused by the request and answered to its caller.
+// Decorators observing the storage do not count them as errors.
+func IsExpected(err error) bool {
+	return errors.Is(err, ErrNotExist) || errors.Is(err, ErrNotCreated) || errors.Is(err, ErrAlreadyExist)
+}
+
+type readOnlyKey struct{}
+
+// ReadOnly returns a ctx for InTx that starts a read-only transaction seeing the data as of its first read,
+// so that reads spread over many calls are consistent with each other.
+func ReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// IsReadOnly reports whether ctx was returned by ReadOnly.
+func IsReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
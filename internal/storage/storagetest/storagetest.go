@@ -0,0 +1,1118 @@
+// Package storagetest is the conformance suite every service.SegmentStorage implementation must pass.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+// NewStorage returns an empty storage for a single test.
+type NewStorage func(t *testing.T) service.SegmentStorage
+
+// Run runs the conformance suite against storages created by newStorage.
+func Run(t *testing.T, newStorage NewStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s service.SegmentStorage)
+	}{
+		{name: "segments", test: testSegments},
+		{name: "segment metadata", test: testSegmentMetadata},
+		{name: "rename segment", test: testRenameSegment},
+		{name: "merge segments", test: testMergeSegments},
+		{name: "users", test: testUsers},
+		{name: "users segments", test: testUsersSegments},
+		{name: "memberships", test: testMemberships},
+		{name: "bulk memberships", test: testBulkMemberships},
+		{name: "expiration", test: testExpiration},
+		{name: "delete cascades", test: testDeleteCascades},
+		{name: "archive and restore", test: testArchive},
+		{name: "purge archived", test: testPurgeArchived},
+		{name: "history", test: testHistory},
+		{name: "auto segments", test: testAutoSegments},
+		{name: "list segments", test: testListSegments},
+		{name: "segment users", test: testSegmentUsers},
+		{name: "webhooks", test: testWebhooks},
+		{name: "webhook deliveries", test: testWebhookDeliveries},
+		{name: "outbox", test: testOutbox},
+		{name: "event broadcast", test: testEventBroadcast},
+		{name: "transactions", test: testTransactions},
+		{name: "concurrent writes", test: testConcurrentWrites},
+		{name: "concurrent outbox order", test: testConcurrentOutboxOrder},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStorage(t))
+		})
+	}
+}
+
+func testSegments(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "a"}))
+	assert.ErrorIs(t, s.CreateSegment(ctx, models.Segment{Name: "a"}), storage.ErrAlreadyExist)
+
+	require.NoError(t, s.DeleteSegment(ctx, "a"))
+	assert.ErrorIs(t, s.DeleteSegment(ctx, "a"), storage.ErrNotExist)
+	assert.ErrorIs(t, s.CreateSegment(ctx, models.Segment{Name: "a"}), storage.ErrAlreadyExist)
+	_, err := s.PurgeArchived(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "a"}))
+}
+
+func testSegmentMetadata(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Minute)
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{
+		Name:        "a",
+		AutoPercent: 10,
+		Description: "voice messages",
+		Owner:       "messenger",
+		Tags:        []string{"voice", "experiment"},
+	}))
+	createSegments(t, s, "b")
+
+	segment, err := s.GetSegment(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", segment.Name)
+	assert.Equal(t, 10, segment.AutoPercent)
+	assert.Equal(t, "voice messages", segment.Description)
+	assert.Equal(t, "messenger", segment.Owner)
+	assert.Equal(t, []string{"voice", "experiment"}, segment.Tags)
+	assert.True(t, segment.CreatedAt.After(before))
+	assert.Equal(t, segment.CreatedAt, segment.UpdatedAt)
+
+	segment, err = s.GetSegment(ctx, "b")
+	require.NoError(t, err)
+	assert.Empty(t, segment.Description)
+	assert.Empty(t, segment.Tags)
+
+	owner := "platform"
+	updated, err := s.UpdateSegment(ctx, models.UpdateSegmentParams{Name: "a", Owner: &owner})
+	require.NoError(t, err)
+	assert.Equal(t, "platform", updated.Owner)
+	assert.Equal(t, "voice messages", updated.Description, "omitted fields are left unchanged")
+	assert.Equal(t, []string{"voice", "experiment"}, updated.Tags)
+	assert.False(t, updated.UpdatedAt.Before(updated.CreatedAt))
+
+	description := ""
+	updated, err = s.UpdateSegment(ctx, models.UpdateSegmentParams{Name: "a", Description: &description, Tags: []string{}})
+	require.NoError(t, err)
+	assert.Empty(t, updated.Description)
+	assert.Empty(t, updated.Tags)
+
+	segment, err = s.GetSegment(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, updated, segment)
+
+	_, err = s.GetSegment(ctx, "c")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = s.UpdateSegment(ctx, models.UpdateSegmentParams{Name: "c", Owner: &owner})
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func testRenameSegment(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	from := time.Now().Add(-time.Minute)
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "a", Owner: "messenger"}))
+	createSegments(t, s, "c")
+	createUsers(t, s, 1, 2)
+	addMemberships(t, s, 1, "a", "c")
+	addMemberships(t, s, 2, "a")
+
+	segment, err := s.RenameSegment(ctx, "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "b", segment.Name)
+	assert.Equal(t, "messenger", segment.Owner)
+
+	_, err = s.GetSegment(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	assertSegments(t, s, 1, "b", "c")
+	assertSegments(t, s, 2, "b")
+
+	_, err = s.RenameSegment(ctx, "b", "c")
+	assert.ErrorIs(t, err, storage.ErrAlreadyExist)
+	_, err = s.RenameSegment(ctx, "a", "d")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = s.RenameSegment(ctx, "a", "a")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	// Renaming a segment to its own name changes nothing.
+	segment, err = s.RenameSegment(ctx, "b", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "b", segment.Name)
+	assertSegments(t, s, 1, "b", "c")
+
+	require.NoError(t, s.DeleteUserFromSegment(ctx, 1, "b"))
+	records, err := s.GetHistory(ctx, from, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []historyEntry{
+		{1, "a", models.OperationAdd},
+		{1, "c", models.OperationAdd},
+		{2, "a", models.OperationAdd},
+		{1, "b", models.OperationRemove},
+	}, historyEntries(records), "the rename itself is not logged")
+
+	// The old name is free and does not inherit the members.
+	createSegments(t, s, "a")
+	count, err := s.CountSegmentUsers(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func testMergeSegments(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	from := time.Now().Add(-2 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+	createSegments(t, s, "target", "a", "b", "c")
+	createUsers(t, s, 1, 2, 3, 4)
+	addMemberships(t, s, 1, "a", "b")
+	addMemberships(t, s, 2, "a", "target")
+	require.NoError(t, s.AddUserToSegment(ctx, 3, "b", &past))
+	require.NoError(t, s.AddUserToSegment(ctx, 4, "target", &past))
+	addMemberships(t, s, 4, "a", "c")
+
+	// A missing source fails the whole merge.
+	_, err := s.MergeSegments(ctx, "target", []string{"c", "missing"})
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	assertSegments(t, s, 4, "a", "c")
+	_, err = s.MergeSegments(ctx, "missing", []string{"c"})
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	added, err := s.MergeSegments(ctx, "target", []string{"a", "b", "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 4}, added)
+
+	assertSegments(t, s, 1, "target")
+	assertSegments(t, s, 2, "target")
+	assertSegments(t, s, 3)
+	assertSegments(t, s, 4, "c", "target")
+	for _, name := range []string{"a", "b"} {
+		_, err = s.GetSegment(ctx, name)
+		assert.ErrorIs(t, err, storage.ErrNotExist)
+	}
+
+	records, err := s.GetHistory(ctx, from, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []historyEntry{
+		{1, "a", models.OperationAdd},
+		{1, "b", models.OperationAdd},
+		{2, "a", models.OperationAdd},
+		{2, "target", models.OperationAdd},
+		{3, "b", models.OperationAdd},
+		{4, "target", models.OperationAdd},
+		{4, "a", models.OperationAdd},
+		{4, "c", models.OperationAdd},
+		{1, "target", models.OperationAdd},
+		{4, "target", models.OperationExpire},
+		{4, "target", models.OperationAdd},
+		{1, "a", models.OperationRemove},
+		{2, "a", models.OperationRemove},
+		{4, "a", models.OperationRemove},
+		{1, "b", models.OperationRemove},
+		{3, "b", models.OperationExpire},
+	}, historyEntries(records))
+}
+
+func testUsers(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+
+	created, err := s.IsUserCreated(ctx, 1)
+	require.NoError(t, err)
+	assert.False(t, created)
+	_, err = s.GetUser(ctx, 1)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	require.NoError(t, s.CreateUser(ctx, 1))
+	assert.ErrorIs(t, s.CreateUser(ctx, 1), storage.ErrAlreadyExist)
+	created, err = s.IsUserCreated(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	user, err := s.GetUser(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.User{ID: 1, Segments: []string{}}, user)
+
+	require.NoError(t, s.CreateUser(ctx, 2))
+	ids, err := s.GetUserIDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+
+	require.NoError(t, s.DeleteUser(ctx, 1))
+	assert.ErrorIs(t, s.DeleteUser(ctx, 1), storage.ErrNotExist)
+	created, err = s.IsUserCreated(ctx, 1)
+	require.NoError(t, err)
+	assert.False(t, created)
+}
+
+func testUsersSegments(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+	createSegments(t, s, "b", "a", "archived")
+	createUsers(t, s, 1, 2, 3, 4)
+	addMemberships(t, s, 1, "b", "a", "archived")
+	require.NoError(t, s.AddUserToSegment(ctx, 2, "a", &past))
+	require.NoError(t, s.DeleteSegment(ctx, "archived"))
+	require.NoError(t, s.DeleteUser(ctx, 4))
+
+	result, err := s.GetUsersSegments(ctx, []int{9, 1, 2, 1, 4, 3})
+	require.NoError(t, err)
+	assert.Equal(t, map[int][]string{1: {"a", "b"}, 2: {}, 3: {}}, result.Segments)
+	assert.Equal(t, []int{9, 4}, result.Missing)
+
+	result, err = s.GetUsersSegments(ctx, []int{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Segments)
+	assert.Empty(t, result.Missing)
+}
+
+func testMemberships(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 1)
+
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "a", nil))
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "b", nil))
+	assert.ErrorIs(t, s.AddUserToSegment(ctx, 1, "a", nil), storage.ErrAlreadyExist)
+	assert.ErrorIs(t, s.AddUserToSegment(ctx, 1, "c", nil), storage.ErrNotExist)
+	assert.ErrorIs(t, s.AddUserToSegment(ctx, 2, "a", nil), storage.ErrNotExist)
+	assertSegments(t, s, 1, "a", "b")
+
+	require.NoError(t, s.DeleteUserFromSegment(ctx, 1, "a"))
+	assert.NoError(t, s.DeleteUserFromSegment(ctx, 1, "a"))
+	assert.ErrorIs(t, s.DeleteUserFromSegment(ctx, 1, "c"), storage.ErrNotExist)
+	assertSegments(t, s, 1, "b")
+}
+
+func testBulkMemberships(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	from := time.Now().Add(-2 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	createSegments(t, s, "a")
+	createUsers(t, s, 1, 2, 3, 4, 5, 6)
+	addMemberships(t, s, 1, "a")
+	require.NoError(t, s.AddUserToSegment(ctx, 2, "a", &past))
+	require.NoError(t, s.AddUserToSegment(ctx, 5, "a", &past))
+	require.NoError(t, s.DeleteUser(ctx, 6))
+
+	result, err := s.AddUsersToSegment(ctx, "a", []int{1, 2, 3, 3, 6, 9}, &future)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, result.Changed)
+	assert.ElementsMatch(t, []int{6, 9}, result.Missing)
+	assertSegments(t, s, 1, "a")
+	assertSegments(t, s, 2, "a")
+	assertSegments(t, s, 3, "a")
+
+	result, err = s.DeleteUsersFromSegment(ctx, "a", []int{1, 4, 5, 9})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, result.Changed)
+	assert.Equal(t, []int{9}, result.Missing)
+	assertSegments(t, s, 1)
+	users, err := s.GetSegmentUsers(ctx, "a", nil, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, users)
+
+	_, err = s.AddUsersToSegment(ctx, "b", []int{1}, nil)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = s.DeleteUsersFromSegment(ctx, "b", []int{1})
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	records, err := s.GetHistory(ctx, from, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []historyEntry{
+		{1, "a", models.OperationAdd},
+		{2, "a", models.OperationAdd},
+		{5, "a", models.OperationAdd},
+		{2, "a", models.OperationExpire},
+		{2, "a", models.OperationAdd},
+		{3, "a", models.OperationAdd},
+		{1, "a", models.OperationRemove},
+		{5, "a", models.OperationExpire},
+	}, historyEntries(records))
+}
+
+func testExpiration(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "expired", "active", "permanent")
+	createUsers(t, s, 1, 2)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "expired", &past))
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "active", &future))
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "permanent", nil))
+	require.NoError(t, s.AddUserToSegment(ctx, 2, "permanent", nil))
+	assertSegments(t, s, 1, "active", "permanent")
+
+	// The next expiry is the first of the active memberships, caches keep the user until then.
+	user, err := s.GetUser(ctx, 1)
+	require.NoError(t, err)
+	require.NotNil(t, user.NextExpiry)
+	assert.True(t, future.Equal(*user.NextExpiry))
+	user, err = s.GetUser(ctx, 2)
+	require.NoError(t, err)
+	assert.Nil(t, user.NextExpiry)
+	result, err := s.GetUsersSegments(ctx, []int{1, 2})
+	require.NoError(t, err)
+	require.Len(t, result.NextExpiry, 1)
+	assert.True(t, future.Equal(result.NextExpiry[1]))
+
+	count, err := s.CountSegmentUsers(ctx, "expired")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	deleted, err := s.DeleteExpiredSegments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []historyEntry{{1, "expired", models.OperationExpire}}, historyEntries(deleted))
+	deleted, err = s.DeleteExpiredSegments(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	// An expired membership that was not reaped yet does not block a new one.
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "expired", &past))
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "expired", nil))
+	assertSegments(t, s, 1, "active", "expired", "permanent")
+}
+
+func testDeleteCascades(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 1, 2)
+	addMemberships(t, s, 1, "a", "b")
+	addMemberships(t, s, 2, "a", "b")
+
+	require.NoError(t, s.DeleteSegment(ctx, "a"))
+	assertSegments(t, s, 1, "b")
+	assertSegments(t, s, 2, "b")
+
+	require.NoError(t, s.DeleteUser(ctx, 1))
+	count, err := s.CountSegmentUsers(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Recreated entities do not inherit memberships of purged ones.
+	_, err = s.PurgeArchived(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	createSegments(t, s, "a")
+	createUsers(t, s, 1)
+	assertSegments(t, s, 1)
+	count, err = s.CountSegmentUsers(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func testArchive(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	from := time.Now().Add(-2 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 1, 2, 3)
+	addMemberships(t, s, 1, "a", "b")
+	addMemberships(t, s, 2, "a")
+	require.NoError(t, s.AddUserToSegment(ctx, 3, "a", &past))
+
+	require.NoError(t, s.DeleteSegment(ctx, "a"))
+	assert.ErrorIs(t, s.DeleteSegment(ctx, "a"), storage.ErrNotExist)
+	_, err := s.GetSegment(ctx, "a")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	segments, err := s.ListSegments(ctx, "", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, segmentNames(segments))
+	assert.ErrorIs(t, s.AddUserToSegment(ctx, 3, "a", nil), storage.ErrNotExist)
+	assertSegments(t, s, 1, "b")
+	assertSegments(t, s, 2)
+	deleted, err := s.DeleteExpiredSegments(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	require.NoError(t, s.DeleteUser(ctx, 2))
+	assert.ErrorIs(t, s.DeleteUser(ctx, 2), storage.ErrNotExist)
+	_, err = s.GetUser(ctx, 2)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	created, err := s.IsUserCreated(ctx, 2)
+	require.NoError(t, err)
+	assert.False(t, created)
+	ids, err := s.GetUserIDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, ids)
+
+	// The segment comes back with the memberships that have not expired, except for archived users.
+	require.NoError(t, s.RestoreSegment(ctx, "a"))
+	assertSegments(t, s, 1, "a", "b")
+	assertSegments(t, s, 3)
+	count, err := s.CountSegmentUsers(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, s.RestoreUser(ctx, 2))
+	assertSegments(t, s, 2, "a")
+	users, err := s.GetSegmentUsers(ctx, "a", nil, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, users)
+
+	assert.ErrorIs(t, s.RestoreSegment(ctx, "a"), storage.ErrNotExist)
+	assert.ErrorIs(t, s.RestoreSegment(ctx, "c"), storage.ErrNotExist)
+	assert.ErrorIs(t, s.RestoreUser(ctx, 2), storage.ErrNotExist)
+	assert.ErrorIs(t, s.RestoreUser(ctx, 4), storage.ErrNotExist)
+
+	records, err := s.GetHistory(ctx, from, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []historyEntry{
+		{1, "a", models.OperationAdd},
+		{1, "b", models.OperationAdd},
+		{2, "a", models.OperationAdd},
+		{3, "a", models.OperationAdd},
+		{1, "a", models.OperationRemove},
+		{2, "a", models.OperationRemove},
+		{3, "a", models.OperationExpire},
+		{1, "a", models.OperationAdd},
+		{2, "a", models.OperationAdd},
+	}, historyEntries(records))
+}
+
+func testPurgeArchived(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 1, 2)
+	addMemberships(t, s, 1, "a", "b")
+	addMemberships(t, s, 2, "a")
+	require.NoError(t, s.DeleteSegment(ctx, "a"))
+	require.NoError(t, s.DeleteUser(ctx, 2))
+
+	purged, err := s.PurgeArchived(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+
+	purged, err = s.PurgeArchived(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	assert.ErrorIs(t, s.RestoreSegment(ctx, "a"), storage.ErrNotExist)
+	assert.ErrorIs(t, s.RestoreUser(ctx, 2), storage.ErrNotExist)
+
+	// The name or ID of an archived entity stays taken, so a failed create leaves it restorable.
+	require.NoError(t, s.DeleteUser(ctx, 1))
+	assert.ErrorIs(t, s.CreateUser(ctx, 1), storage.ErrAlreadyExist)
+	require.NoError(t, s.RestoreUser(ctx, 1))
+	assertSegments(t, s, 1, "b")
+
+	require.NoError(t, s.DeleteSegment(ctx, "b"))
+	assert.ErrorIs(t, s.CreateSegment(ctx, models.Segment{Name: "b"}), storage.ErrAlreadyExist)
+	createSegments(t, s, "c")
+	_, err = s.RenameSegment(ctx, "c", "b")
+	assert.ErrorIs(t, err, storage.ErrAlreadyExist)
+	require.NoError(t, s.RestoreSegment(ctx, "b"))
+	assertSegments(t, s, 1, "b")
+
+	// Purged names and IDs can be taken again, without the purged memberships.
+	createUsers(t, s, 2)
+	createSegments(t, s, "a")
+	assertSegments(t, s, 1, "b")
+	assertSegments(t, s, 2)
+}
+
+func testHistory(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	from := time.Now().Add(-2 * time.Hour)
+	createSegments(t, s, "a", "b", "c")
+	createUsers(t, s, 1, 2)
+
+	expiresAt := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	addMemberships(t, s, 1, "a", "b")
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "c", &expiresAt))
+	addMemberships(t, s, 2, "a")
+	require.NoError(t, s.DeleteUserFromSegment(ctx, 1, "a"))
+	_, err := s.DeleteExpiredSegments(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteSegment(ctx, "b"))
+	require.NoError(t, s.DeleteUser(ctx, 2))
+
+	records, err := s.GetHistory(ctx, from, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []historyEntry{
+		{1, "a", models.OperationAdd},
+		{1, "b", models.OperationAdd},
+		{1, "c", models.OperationAdd},
+		{2, "a", models.OperationAdd},
+		{1, "a", models.OperationRemove},
+		{1, "c", models.OperationExpire},
+		{1, "b", models.OperationRemove},
+		{2, "a", models.OperationRemove},
+	}, historyEntries(records))
+
+	for i := 1; i < len(records); i++ {
+		assert.False(t, records[i].Timestamp.Before(records[i-1].Timestamp), "history must be ordered by time")
+	}
+	for _, record := range records {
+		if record.Operation == models.OperationExpire {
+			assert.True(t, expiresAt.Equal(record.Timestamp), "expiry is logged at the expiry time")
+		}
+	}
+
+	records, err = s.GetHistory(ctx, from, from.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func testAutoSegments(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "b", AutoPercent: 10}))
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "a", AutoPercent: 100}))
+	require.NoError(t, s.CreateSegment(ctx, models.Segment{Name: "c"}))
+
+	segments, err := s.GetAutoSegments(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, segmentNames(segments))
+	assert.Equal(t, 100, segments[0].AutoPercent)
+	assert.Equal(t, 10, segments[1].AutoPercent)
+}
+
+func testListSegments(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "AVITO_B", "AVITO_A", "OTHER", "AVITO_C", "AVITO%")
+
+	segments, err := s.ListSegments(ctx, "", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO%", "AVITO_A", "AVITO_B", "AVITO_C", "OTHER"}, segmentNames(segments))
+
+	segments, err = s.ListSegments(ctx, "AVITO_", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_A", "AVITO_B"}, segmentNames(segments))
+
+	segments, err = s.ListSegments(ctx, "AVITO_", "AVITO_B", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO_C"}, segmentNames(segments))
+
+	segments, err = s.ListSegments(ctx, "AVITO%", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AVITO%"}, segmentNames(segments))
+
+	segments, err = s.ListSegments(ctx, "NONE", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+}
+
+func testSegmentUsers(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 3, 1, 2, 4)
+	addMemberships(t, s, 1, "a")
+	addMemberships(t, s, 2, "a")
+	addMemberships(t, s, 3, "a")
+	addMemberships(t, s, 4, "b")
+
+	users, err := s.GetSegmentUsers(ctx, "a", nil, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, users)
+
+	after := 2
+	users, err = s.GetSegmentUsers(ctx, "a", &after, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, users)
+
+	count, err := s.CountSegmentUsers(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	createUsers(t, s, 5)
+	require.NoError(t, s.AddUserToSegment(ctx, 5, "a", &expiresAt))
+	members, err := s.GetSegmentMembers(ctx, "a", &after, 10)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, models.SegmentMember{UserID: 3}, members[0])
+	assert.Equal(t, 5, members[1].UserID)
+	require.NotNil(t, members[1].ExpiresAt)
+	assert.True(t, expiresAt.Equal(*members[1].ExpiresAt))
+
+	_, err = s.GetSegmentUsers(ctx, "c", nil, 2)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = s.GetSegmentMembers(ctx, "c", nil, 2)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = s.CountSegmentUsers(ctx, "c")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	past := time.Now().Add(-time.Hour)
+	createSegments(t, s, "empty", "archived")
+	createUsers(t, s, 6)
+	require.NoError(t, s.AddUserToSegment(ctx, 6, "b", &past))
+	addMemberships(t, s, 6, "archived")
+	require.NoError(t, s.DeleteSegment(ctx, "archived"))
+	require.NoError(t, s.DeleteUser(ctx, 1))
+	counts, err := s.CountMembers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 3, "b": 1, "empty": 0}, counts)
+}
+
+func testWebhooks(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+
+	first, err := s.CreateWebhook(ctx, models.Webhook{URL: "http://a", Secret: "s1"})
+	require.NoError(t, err)
+	assert.NotZero(t, first.ID)
+	assert.False(t, first.CreatedAt.IsZero())
+	second, err := s.CreateWebhook(ctx, models.Webhook{
+		URL:    "http://b",
+		Secret: "s2",
+		Events: []models.EventType{models.EventMembershipAdded, models.EventUserCreated},
+	})
+	require.NoError(t, err)
+
+	webhooks, err := s.ListWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	assert.Equal(t, first.ID, webhooks[0].ID)
+	assert.Equal(t, "s1", webhooks[0].Secret)
+	assert.Empty(t, webhooks[0].Events)
+	assert.Equal(t, []models.EventType{models.EventMembershipAdded, models.EventUserCreated}, webhooks[1].Events)
+
+	userID := 1
+	failedAt := time.Now().Truncate(time.Microsecond)
+	letter := func(webhookID int, eventID string) models.DeadLetter {
+		return models.DeadLetter{
+			WebhookID: webhookID,
+			Event:     models.Event{ID: eventID, Type: models.EventUserCreated, UserID: &userID, Timestamp: failedAt},
+			Attempts:  3,
+			LastError: "timeout",
+			FailedAt:  failedAt,
+		}
+	}
+	require.NoError(t, s.AddDeadLetter(ctx, letter(first.ID, "e1")))
+	require.NoError(t, s.AddDeadLetter(ctx, letter(second.ID, "e2")))
+	require.NoError(t, s.AddDeadLetter(ctx, letter(first.ID, "e3")))
+	assert.ErrorIs(t, s.AddDeadLetter(ctx, letter(second.ID+100, "e4")), storage.ErrNotExist)
+
+	letters, err := s.ListDeadLetters(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "e1", letters[0].Event.ID)
+	assert.Equal(t, "e3", letters[1].Event.ID)
+	assert.Equal(t, first.ID, letters[0].WebhookID)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Equal(t, "timeout", letters[0].LastError)
+	assert.True(t, failedAt.Equal(letters[0].FailedAt))
+	assert.Equal(t, &userID, letters[0].Event.UserID)
+
+	require.NoError(t, s.DeleteDeadLetter(ctx, letters[0].ID))
+	assert.ErrorIs(t, s.DeleteDeadLetter(ctx, letters[0].ID), storage.ErrNotExist)
+	remaining, err := s.ListDeadLetters(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, letters[1:], remaining)
+
+	require.NoError(t, s.DeleteWebhook(ctx, second.ID))
+	assert.ErrorIs(t, s.DeleteWebhook(ctx, second.ID), storage.ErrNotExist)
+	_, err = s.ListDeadLetters(ctx, second.ID)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	webhooks, err = s.ListWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, first.ID, webhooks[0].ID)
+}
+
+func testWebhookDeliveries(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	first, err := s.CreateWebhook(ctx, models.Webhook{URL: "http://a", Secret: "s1"})
+	require.NoError(t, err)
+	second, err := s.CreateWebhook(ctx, models.Webhook{URL: "http://b", Secret: "s2"})
+	require.NoError(t, err)
+
+	userID := 1
+	now := time.Now().Truncate(time.Microsecond)
+	delivery := func(webhookID int, eventID string, nextAttemptAt time.Time) models.WebhookDelivery {
+		return models.WebhookDelivery{
+			WebhookID:     webhookID,
+			Event:         models.Event{ID: eventID, Type: models.EventUserCreated, UserID: &userID, Timestamp: now},
+			NextAttemptAt: nextAttemptAt,
+		}
+	}
+	require.NoError(t, s.AddWebhookDelivery(ctx, delivery(first.ID, "e1", now)))
+	require.NoError(t, s.AddWebhookDelivery(ctx, delivery(second.ID, "e2", now.Add(-time.Minute))))
+	require.NoError(t, s.AddWebhookDelivery(ctx, delivery(first.ID, "e3", now.Add(time.Minute))))
+	require.NoError(t, s.AddWebhookDelivery(ctx, delivery(second.ID, "e4", now)))
+	assert.ErrorIs(t, s.AddWebhookDelivery(ctx, delivery(second.ID+100, "e5", now)), storage.ErrNotExist)
+
+	// Due deliveries are taken in the order they were queued and hidden until lockedUntil.
+	lockedUntil := now.Add(time.Hour)
+	claimed, err := s.ClaimWebhookDeliveries(ctx, now, lockedUntil, 2)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, "e1", claimed[0].Event.ID)
+	assert.Equal(t, "e2", claimed[1].Event.ID)
+	assert.Equal(t, first.ID, claimed[0].WebhookID)
+	assert.Equal(t, &userID, claimed[0].Event.UserID)
+	assert.True(t, lockedUntil.Equal(claimed[0].NextAttemptAt))
+	assert.Less(t, claimed[0].ID, claimed[1].ID)
+
+	claimed2, err := s.ClaimWebhookDeliveries(ctx, now, lockedUntil, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed2, 1)
+	assert.Equal(t, "e4", claimed2[0].Event.ID)
+
+	retry := claimed[0]
+	retry.Attempts = 1
+	retry.LastError = "timeout"
+	retry.NextAttemptAt = now.Add(time.Second)
+	require.NoError(t, s.UpdateWebhookDelivery(ctx, retry))
+	require.NoError(t, s.DeleteWebhookDelivery(ctx, claimed[1].ID))
+	assert.ErrorIs(t, s.DeleteWebhookDelivery(ctx, claimed[1].ID), storage.ErrNotExist)
+	assert.ErrorIs(t, s.UpdateWebhookDelivery(ctx, claimed[1]), storage.ErrNotExist)
+
+	due, err := s.ClaimWebhookDeliveries(ctx, now.Add(2*time.Minute), lockedUntil, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	assert.Equal(t, "e1", due[0].Event.ID)
+	assert.Equal(t, 1, due[0].Attempts)
+	assert.Equal(t, "timeout", due[0].LastError)
+	assert.Equal(t, "e3", due[1].Event.ID)
+
+	// Deliveries are deleted with their webhook.
+	require.NoError(t, s.DeleteWebhook(ctx, first.ID))
+	due, err = s.ClaimWebhookDeliveries(ctx, lockedUntil.Add(time.Minute), lockedUntil, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, "e4", due[0].Event.ID)
+	assert.ErrorIs(t, s.UpdateWebhookDelivery(ctx, retry), storage.ErrNotExist)
+}
+
+func testOutbox(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 1)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "a", &expiresAt))
+	require.NoError(t, s.DeleteUserFromSegment(ctx, 1, "a"))
+	require.NoError(t, s.AddUserToSegment(ctx, 1, "b", nil))
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, s.CreateUser(ctx, 2))
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+	require.NoError(t, s.DeleteUser(ctx, 1))
+	require.NoError(t, s.DeleteSegment(ctx, "a"))
+
+	type event struct {
+		Type    models.EventType
+		UserID  int
+		Segment string
+	}
+	expected := []event{
+		{Type: models.EventSegmentCreated, Segment: "a"},
+		{Type: models.EventSegmentCreated, Segment: "b"},
+		{Type: models.EventUserCreated, UserID: 1},
+		{Type: models.EventMembershipAdded, UserID: 1, Segment: "a"},
+		{Type: models.EventMembershipRemoved, UserID: 1, Segment: "a"},
+		{Type: models.EventMembershipAdded, UserID: 1, Segment: "b"},
+		{Type: models.EventMembershipRemoved, UserID: 1, Segment: "b"},
+		{Type: models.EventUserDeleted, UserID: 1},
+		{Type: models.EventSegmentDeleted, Segment: "a"},
+	}
+	outbox, err := s.FetchOutbox(ctx, 100)
+	require.NoError(t, err)
+	actual := make([]event, 0, len(outbox))
+	for i, e := range outbox {
+		if i > 0 {
+			assert.Greater(t, e.Seq, outbox[i-1].Seq, "events are ordered as written")
+		}
+		assert.Equal(t, strconv.FormatInt(e.Seq, 10), e.Event.ID)
+		assert.False(t, e.Event.Timestamp.IsZero())
+		userID := 0
+		if e.Event.UserID != nil {
+			userID = *e.Event.UserID
+		}
+		actual = append(actual, event{Type: e.Event.Type, UserID: userID, Segment: e.Event.Segment})
+	}
+	require.Equal(t, expected, actual)
+	require.NotNil(t, outbox[3].Event.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*outbox[3].Event.ExpiresAt))
+	assert.Nil(t, outbox[5].Event.ExpiresAt)
+
+	first, err := s.FetchOutbox(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, outbox[:2], first)
+
+	require.NoError(t, s.DeleteOutbox(ctx, []int64{outbox[0].Seq, outbox[2].Seq}))
+	rest, err := s.FetchOutbox(ctx, 100)
+	require.NoError(t, err)
+	require.Len(t, rest, len(outbox)-2)
+	assert.Equal(t, outbox[1], rest[0])
+	assert.Equal(t, outbox[3], rest[1])
+
+	unlock, locked, err := s.LockOutbox(ctx)
+	require.NoError(t, err)
+	require.True(t, locked)
+	_, locked, err = s.LockOutbox(ctx)
+	require.NoError(t, err)
+	assert.False(t, locked, "only one relay holds the lock")
+	unlock()
+	unlock, locked, err = s.LockOutbox(ctx)
+	require.NoError(t, err)
+	assert.True(t, locked)
+	unlock()
+}
+
+func testEventBroadcast(t *testing.T, s service.SegmentStorage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan models.Event, 100)
+	done := make(chan error)
+	go func() {
+		done <- s.ListenEvents(ctx, func(event models.Event) { received <- event })
+	}()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	// Events broadcast before the listener is registered are lost, so ping until one arrives.
+	ping := models.Event{ID: "ping", Type: models.EventSegmentCreated, Segment: "ping"}
+	require.Eventually(t, func() bool {
+		require.NoError(t, s.NotifyEvents(ctx, []models.Event{ping}))
+		select {
+		case <-received:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+
+	userID := 1
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	events := []models.Event{
+		{ID: "1", Type: models.EventMembershipAdded, UserID: &userID, Segment: "a", ExpiresAt: &expiresAt},
+		{ID: "2", Type: models.EventUserDeleted, UserID: &userID},
+	}
+	require.NoError(t, s.NotifyEvents(ctx, events))
+
+	for _, expected := range events {
+		var event models.Event
+		for {
+			select {
+			case event = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("event was not received")
+			}
+			if event.ID != ping.ID {
+				break
+			}
+		}
+		assert.Equal(t, expected.ID, event.ID)
+		assert.Equal(t, expected.Type, event.Type)
+		assert.Equal(t, expected.UserID, event.UserID)
+		assert.Equal(t, expected.Segment, event.Segment)
+		if expected.ExpiresAt != nil {
+			require.NotNil(t, event.ExpiresAt)
+			assert.True(t, expected.ExpiresAt.Equal(*event.ExpiresAt))
+		}
+	}
+}
+
+func testTransactions(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a", "b")
+	createUsers(t, s, 1)
+
+	errRollback := errors.New("rollback")
+	err := s.InTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, s.AddUserToSegment(ctx, 1, "a", nil))
+		require.NoError(t, s.CreateUser(ctx, 2))
+		user, err := s.GetUser(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, user.Segments, "changes are visible inside the transaction")
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+	assertSegments(t, s, 1)
+	created, err := s.IsUserCreated(ctx, 2)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	err = s.InTx(ctx, func(ctx context.Context) error {
+		if err := s.AddUserToSegment(ctx, 1, "a", nil); err != nil {
+			return err
+		}
+		// A nested transaction joins the outer one.
+		return s.InTx(ctx, func(ctx context.Context) error {
+			return s.AddUserToSegment(ctx, 1, "b", nil)
+		})
+	})
+	require.NoError(t, err)
+	assertSegments(t, s, 1, "a", "b")
+}
+
+func testConcurrentWrites(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	createSegments(t, s, "a")
+
+	const workers = 8
+	var wg sync.WaitGroup
+	results := make([]error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.CreateUser(ctx, 1)
+		}(i)
+	}
+	wg.Wait()
+	assertOneSucceeded(t, results)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.AddUserToSegment(ctx, 1, "a", nil)
+		}(i)
+	}
+	wg.Wait()
+	assertOneSucceeded(t, results)
+}
+
+// Seqs are taken when events are written, not when their transactions commit. Transactions changing
+// the same user are committed in the order of their seqs, so the relay never fetches a later event
+// of the user while an earlier one is still uncommitted.
+func testConcurrentOutboxOrder(t *testing.T, s service.SegmentStorage) {
+	ctx := context.Background()
+	const writers = 6
+	segments := make([]string, writers)
+	for i := range segments {
+		segments[i] = "s" + strconv.Itoa(i)
+	}
+	createSegments(t, s, segments...)
+	createUsers(t, s, 1)
+	drained, err := s.FetchOutbox(ctx, 100)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteOutbox(ctx, outboxSeqs(drained)))
+
+	var wg sync.WaitGroup
+	results := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Writers that start earlier commit later, unless they are kept waiting for the earlier ones.
+			results[i] = s.InTx(ctx, func(ctx context.Context) error {
+				if err := s.AddUserToSegment(ctx, 1, segments[i], nil); err != nil {
+					return err
+				}
+				time.Sleep(time.Duration(writers-i) * 5 * time.Millisecond)
+				return nil
+			})
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// The relay deletes what it fetched, an event committed later with a lower seq would be fetched out of order.
+	var fetched []int64
+	for finished := false; ; {
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(time.Millisecond):
+		}
+		events, err := s.FetchOutbox(ctx, 100)
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteOutbox(ctx, outboxSeqs(events)))
+		fetched = append(fetched, outboxSeqs(events)...)
+		if finished && len(events) == 0 {
+			break
+		}
+	}
+	for _, err := range results {
+		require.NoError(t, err)
+	}
+	require.Len(t, fetched, writers)
+	for i := 1; i < len(fetched); i++ {
+		assert.Less(t, fetched[i-1], fetched[i], "events of a user are fetched in the order of their seqs")
+	}
+}
+
+func outboxSeqs(events []models.OutboxEvent) []int64 {
+	seqs := make([]int64, 0, len(events))
+	for _, event := range events {
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
+
+func assertSegments(t *testing.T, s service.SegmentStorage, userID int, segments ...string) {
+	t.Helper()
+	user, err := s.GetUser(context.Background(), userID)
+	require.NoError(t, err)
+	if len(segments) == 0 {
+		assert.Empty(t, user.Segments)
+		return
+	}
+	assert.ElementsMatch(t, segments, user.Segments)
+}
+
+func assertOneSucceeded(t *testing.T, results []error) {
+	t.Helper()
+	succeeded := 0
+	for _, err := range results {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, storage.ErrAlreadyExist)
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func createSegments(t *testing.T, s service.SegmentStorage, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		require.NoError(t, s.CreateSegment(context.Background(), models.Segment{Name: name}))
+	}
+}
+
+func createUsers(t *testing.T, s service.SegmentStorage, ids ...int) {
+	t.Helper()
+	for _, id := range ids {
+		require.NoError(t, s.CreateUser(context.Background(), id))
+	}
+}
+
+func addMemberships(t *testing.T, s service.SegmentStorage, userID int, segments ...string) {
+	t.Helper()
+	for _, segment := range segments {
+		require.NoError(t, s.AddUserToSegment(context.Background(), userID, segment, nil))
+	}
+}
+
+func segmentNames(segments []models.Segment) []string {
+	names := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		names = append(names, segment.Name)
+	}
+	return names
+}
+
+type historyEntry struct {
+	userID    int
+	segment   string
+	operation models.Operation
+}
+
+func historyEntries(records []models.HistoryRecord) []historyEntry {
+	entries := make([]historyEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, historyEntry{record.UserID, record.Segment, record.Operation})
+	}
+	return entries
+}
@@ -0,0 +1,305 @@
+// Package tracing wraps a storage with OpenTelemetry spans of its calls.
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/iTcatt/segmenter/internal/models"
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage"
+)
+
+var tracer = otel.Tracer("github.com/iTcatt/segmenter/internal/storage")
+
+// Storage starts a span for every storage call made within a traced request. Calls of background
+// workers, such as the outbox relay polling every second, start no spans. Expected errors,
+// see storage.IsExpected, do not mark spans as failed.
+// InTx and ListenEvents run for as long as their callers and are not traced.
+type Storage struct {
+	service.SegmentStorage
+}
+
+func NewStorage(repo service.SegmentStorage) *Storage {
+	return &Storage{SegmentStorage: repo}
+}
+
+func start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+	return tracer.Start(ctx, "storage."+operation)
+}
+
+func end(span trace.Span, err *error) {
+	if *err != nil && !storage.IsExpected(*err) {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+func (s *Storage) CreateSegment(ctx context.Context, segment models.Segment) (err error) {
+	ctx, span := start(ctx, "CreateSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.CreateSegment(ctx, segment)
+}
+
+func (s *Storage) GetSegment(ctx context.Context, name string) (_ models.Segment, err error) {
+	ctx, span := start(ctx, "GetSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.GetSegment(ctx, name)
+}
+
+func (s *Storage) UpdateSegment(
+	ctx context.Context, params models.UpdateSegmentParams,
+) (_ models.Segment, err error) {
+	ctx, span := start(ctx, "UpdateSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.UpdateSegment(ctx, params)
+}
+
+func (s *Storage) RenameSegment(ctx context.Context, name, newName string) (_ models.Segment, err error) {
+	ctx, span := start(ctx, "RenameSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.RenameSegment(ctx, name, newName)
+}
+
+func (s *Storage) MergeSegments(ctx context.Context, target string, sources []string) (_ []int, err error) {
+	ctx, span := start(ctx, "MergeSegments")
+	defer end(span, &err)
+	return s.SegmentStorage.MergeSegments(ctx, target, sources)
+}
+
+func (s *Storage) CreateUser(ctx context.Context, id int) (err error) {
+	ctx, span := start(ctx, "CreateUser")
+	defer end(span, &err)
+	return s.SegmentStorage.CreateUser(ctx, id)
+}
+
+func (s *Storage) AddUserToSegment(
+	ctx context.Context, userID int, segment string, expiresAt *time.Time,
+) (err error) {
+	ctx, span := start(ctx, "AddUserToSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.AddUserToSegment(ctx, userID, segment, expiresAt)
+}
+
+func (s *Storage) AddUsersToSegment(
+	ctx context.Context, segment string, userIDs []int, expiresAt *time.Time,
+) (_ models.BulkMembershipResult, err error) {
+	ctx, span := start(ctx, "AddUsersToSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.AddUsersToSegment(ctx, segment, userIDs, expiresAt)
+}
+
+func (s *Storage) DeleteUsersFromSegment(
+	ctx context.Context, segment string, userIDs []int,
+) (_ models.BulkMembershipResult, err error) {
+	ctx, span := start(ctx, "DeleteUsersFromSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteUsersFromSegment(ctx, segment, userIDs)
+}
+
+func (s *Storage) IsUserCreated(ctx context.Context, userID int) (_ bool, err error) {
+	ctx, span := start(ctx, "IsUserCreated")
+	defer end(span, &err)
+	return s.SegmentStorage.IsUserCreated(ctx, userID)
+}
+
+func (s *Storage) GetUser(ctx context.Context, id int) (_ models.User, err error) {
+	ctx, span := start(ctx, "GetUser")
+	defer end(span, &err)
+	return s.SegmentStorage.GetUser(ctx, id)
+}
+
+func (s *Storage) GetUsersSegments(ctx context.Context, userIDs []int) (_ models.UsersSegments, err error) {
+	ctx, span := start(ctx, "GetUsersSegments")
+	defer end(span, &err)
+	return s.SegmentStorage.GetUsersSegments(ctx, userIDs)
+}
+
+func (s *Storage) GetUserIDs(ctx context.Context) (_ []int, err error) {
+	ctx, span := start(ctx, "GetUserIDs")
+	defer end(span, &err)
+	return s.SegmentStorage.GetUserIDs(ctx)
+}
+
+func (s *Storage) GetAutoSegments(ctx context.Context) (_ []models.Segment, err error) {
+	ctx, span := start(ctx, "GetAutoSegments")
+	defer end(span, &err)
+	return s.SegmentStorage.GetAutoSegments(ctx)
+}
+
+func (s *Storage) ListSegments(
+	ctx context.Context, prefix, after string, limit int,
+) (_ []models.Segment, err error) {
+	ctx, span := start(ctx, "ListSegments")
+	defer end(span, &err)
+	return s.SegmentStorage.ListSegments(ctx, prefix, after, limit)
+}
+
+func (s *Storage) GetSegmentUsers(ctx context.Context, segment string, after *int, limit int) (_ []int, err error) {
+	ctx, span := start(ctx, "GetSegmentUsers")
+	defer end(span, &err)
+	return s.SegmentStorage.GetSegmentUsers(ctx, segment, after, limit)
+}
+
+func (s *Storage) GetSegmentMembers(
+	ctx context.Context, segment string, after *int, limit int,
+) (_ []models.SegmentMember, err error) {
+	ctx, span := start(ctx, "GetSegmentMembers")
+	defer end(span, &err)
+	return s.SegmentStorage.GetSegmentMembers(ctx, segment, after, limit)
+}
+
+func (s *Storage) CountSegmentUsers(ctx context.Context, segment string) (_ int, err error) {
+	ctx, span := start(ctx, "CountSegmentUsers")
+	defer end(span, &err)
+	return s.SegmentStorage.CountSegmentUsers(ctx, segment)
+}
+
+func (s *Storage) CountMembers(ctx context.Context) (_ map[string]int, err error) {
+	ctx, span := start(ctx, "CountMembers")
+	defer end(span, &err)
+	return s.SegmentStorage.CountMembers(ctx)
+}
+
+func (s *Storage) DeleteSegment(ctx context.Context, name string) (err error) {
+	ctx, span := start(ctx, "DeleteSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteSegment(ctx, name)
+}
+
+func (s *Storage) DeleteUser(ctx context.Context, id int) (err error) {
+	ctx, span := start(ctx, "DeleteUser")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteUser(ctx, id)
+}
+
+func (s *Storage) DeleteUserFromSegment(ctx context.Context, userID int, segment string) (err error) {
+	ctx, span := start(ctx, "DeleteUserFromSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteUserFromSegment(ctx, userID, segment)
+}
+
+func (s *Storage) DeleteExpiredSegments(ctx context.Context) (_ []models.HistoryRecord, err error) {
+	ctx, span := start(ctx, "DeleteExpiredSegments")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteExpiredSegments(ctx)
+}
+
+func (s *Storage) RestoreSegment(ctx context.Context, name string) (err error) {
+	ctx, span := start(ctx, "RestoreSegment")
+	defer end(span, &err)
+	return s.SegmentStorage.RestoreSegment(ctx, name)
+}
+
+func (s *Storage) RestoreUser(ctx context.Context, id int) (err error) {
+	ctx, span := start(ctx, "RestoreUser")
+	defer end(span, &err)
+	return s.SegmentStorage.RestoreUser(ctx, id)
+}
+
+func (s *Storage) PurgeArchived(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := start(ctx, "PurgeArchived")
+	defer end(span, &err)
+	return s.SegmentStorage.PurgeArchived(ctx, before)
+}
+
+func (s *Storage) GetHistory(ctx context.Context, from, to time.Time) (_ []models.HistoryRecord, err error) {
+	ctx, span := start(ctx, "GetHistory")
+	defer end(span, &err)
+	return s.SegmentStorage.GetHistory(ctx, from, to)
+}
+
+func (s *Storage) CreateWebhook(ctx context.Context, webhook models.Webhook) (_ models.Webhook, err error) {
+	ctx, span := start(ctx, "CreateWebhook")
+	defer end(span, &err)
+	return s.SegmentStorage.CreateWebhook(ctx, webhook)
+}
+
+func (s *Storage) ListWebhooks(ctx context.Context) (_ []models.Webhook, err error) {
+	ctx, span := start(ctx, "ListWebhooks")
+	defer end(span, &err)
+	return s.SegmentStorage.ListWebhooks(ctx)
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, id int) (err error) {
+	ctx, span := start(ctx, "DeleteWebhook")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteWebhook(ctx, id)
+}
+
+func (s *Storage) AddDeadLetter(ctx context.Context, letter models.DeadLetter) (err error) {
+	ctx, span := start(ctx, "AddDeadLetter")
+	defer end(span, &err)
+	return s.SegmentStorage.AddDeadLetter(ctx, letter)
+}
+
+func (s *Storage) ListDeadLetters(ctx context.Context, webhookID int) (_ []models.DeadLetter, err error) {
+	ctx, span := start(ctx, "ListDeadLetters")
+	defer end(span, &err)
+	return s.SegmentStorage.ListDeadLetters(ctx, webhookID)
+}
+
+func (s *Storage) DeleteDeadLetter(ctx context.Context, id int64) (err error) {
+	ctx, span := start(ctx, "DeleteDeadLetter")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteDeadLetter(ctx, id)
+}
+
+func (s *Storage) AddWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (err error) {
+	ctx, span := start(ctx, "AddWebhookDelivery")
+	defer end(span, &err)
+	return s.SegmentStorage.AddWebhookDelivery(ctx, delivery)
+}
+
+func (s *Storage) ClaimWebhookDeliveries(
+	ctx context.Context, now, lockedUntil time.Time, limit int,
+) (_ []models.WebhookDelivery, err error) {
+	ctx, span := start(ctx, "ClaimWebhookDeliveries")
+	defer end(span, &err)
+	return s.SegmentStorage.ClaimWebhookDeliveries(ctx, now, lockedUntil, limit)
+}
+
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (err error) {
+	ctx, span := start(ctx, "UpdateWebhookDelivery")
+	defer end(span, &err)
+	return s.SegmentStorage.UpdateWebhookDelivery(ctx, delivery)
+}
+
+func (s *Storage) DeleteWebhookDelivery(ctx context.Context, id int64) (err error) {
+	ctx, span := start(ctx, "DeleteWebhookDelivery")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteWebhookDelivery(ctx, id)
+}
+
+func (s *Storage) LockOutbox(ctx context.Context) (_ func(), _ bool, err error) {
+	ctx, span := start(ctx, "LockOutbox")
+	defer end(span, &err)
+	return s.SegmentStorage.LockOutbox(ctx)
+}
+
+func (s *Storage) FetchOutbox(ctx context.Context, limit int) (_ []models.OutboxEvent, err error) {
+	ctx, span := start(ctx, "FetchOutbox")
+	defer end(span, &err)
+	return s.SegmentStorage.FetchOutbox(ctx, limit)
+}
+
+func (s *Storage) DeleteOutbox(ctx context.Context, seqs []int64) (err error) {
+	ctx, span := start(ctx, "DeleteOutbox")
+	defer end(span, &err)
+	return s.SegmentStorage.DeleteOutbox(ctx, seqs)
+}
+
+func (s *Storage) NotifyEvents(ctx context.Context, events []models.Event) (err error) {
+	ctx, span := start(ctx, "NotifyEvents")
+	defer end(span, &err)
+	return s.SegmentStorage.NotifyEvents(ctx, events)
+}
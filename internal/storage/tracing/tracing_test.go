@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/iTcatt/segmenter/internal/service"
+	"github.com/iTcatt/segmenter/internal/storage/memory"
+	"github.com/iTcatt/segmenter/internal/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) service.SegmentStorage {
+		return NewStorage(memory.NewStorage())
+	})
+}
+
+func TestStorage_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) })
+
+	s := NewStorage(memory.NewStorage())
+	// Calls outside a traced request start no spans.
+	require.NoError(t, s.CreateUser(context.Background(), 1000))
+	assert.Empty(t, recorder.Ended())
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "PATCH /api/user/{id}")
+	_, err := s.IsUserCreated(ctx, 1000)
+	require.NoError(t, err)
+	_, err = s.GetUser(ctx, 1002)
+	require.Error(t, err)
+	request.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	for _, span := range spans[:2] {
+		assert.Equal(t, request.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	assert.Equal(t, "storage.IsUserCreated", spans[0].Name())
+	assert.Equal(t, "storage.GetUser", spans[1].Name())
+	assert.Equal(t, codes.Unset, spans[1].Status().Code, "a missing user is not a failure")
+}